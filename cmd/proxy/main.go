@@ -1,29 +1,253 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"lab1/config"
 	"lab1/proxy"
-	"log"
+	"lab1/server"
+	"log/slog"
 	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 func main() {
-	err := godotenv.Load(".env")
+	cfg, checkOnly, err := loadConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("failed to load configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if checkOnly {
+		cfg.Dump(os.Stdout)
+		fmt.Println("configuration OK")
+		return
+	}
+
+	logLevel := new(slog.LevelVar)
+	logger, level, err := cfg.Logging.Logger("proxy", logLevel)
 	if err != nil {
-        fmt.Printf("failed to load environment variables, create the .env file: %v\n", err)
+		fmt.Printf("failed to configure logging: %v\n", err)
+		os.Exit(1)
 	}
+	logLevel.Set(level)
+	slog.SetDefault(logger)
 
-	log.SetPrefix("[PROXY] ")
-	port, err := strconv.Atoi(os.Args[1])
-	proxy, err := proxy.CreateProxy(port)
+	proxy, err := proxy.CreateProxy(cfg.Listen.Port)
 	if err != nil {
-		panic(err)
+		fmt.Printf("failed to start proxy with error: %v\n", err)
+		os.Exit(1)
+	}
+
+	errorPages, err := server.LoadErrorPages(cfg.Errors.Pages)
+	if err != nil {
+		fmt.Printf("failed to load error pages: %v\n", err)
+		os.Exit(1)
+	}
+	accessLog, err := cfg.Logging.OpenAccessLog()
+	if err != nil {
+		fmt.Printf("failed to open access log: %v\n", err)
+		os.Exit(1)
+	}
+	proxy.Reconfigure(accessLog, logger, errorPages, cfg.ParserOptions())
+
+	if target := cfg.Observability.TraceExport; target != "" {
+		if proxy.Tracer, err = server.OpenTracer(target); err != nil {
+			fmt.Printf("failed to configure tracing: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	proxy.Listen()
+	var admin *server.Admin
+	reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+		cfg, _, err := loadConfig()
+		return cfg, err
+	}, func(old, next *config.Config) error {
+		return reload(proxy, admin, logLevel, old, next)
+	})
+
+	timeout := time.Duration(cfg.Limits.ShutdownTimeout)
+	if cfg.Admin.Addr != "" {
+		if admin, err = startAdmin(proxy, cfg, logLevel, reloader); err != nil {
+			fmt.Printf("failed to start admin endpoints: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		<-signals
+		proxy.Shutdown(timeout)
+	}()
+
+	// SIGUSR2 hands the listeners over to a new process of the binary, for
+	// deploying a new build without dropping connections.
+	go func() {
+		upgrades := make(chan os.Signal, 1)
+		signal.Notify(upgrades, syscall.SIGUSR2)
+		for range upgrades {
+			upgrade(proxy, admin, timeout)
+		}
+	}()
+
+	// SIGHUP reloads the configuration and reopens the access log for
+	// tools such as logrotate.
+	go func() {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		for range hangups {
+			reloader.Reload()
+			if accessLog := proxy.CurrentAccessLog(); accessLog != nil {
+				if err := accessLog.Reopen(); err != nil {
+					slog.Error("Error reopening access log", "err", err)
+				}
+			}
+		}
+	}()
+
+	listeners, err := cfg.Listeners()
+	if err != nil {
+		fmt.Printf("failed to configure listeners: %v\n", err)
+		os.Exit(1)
+	}
+	if err := proxy.Listen(listeners...); err != nil {
+		os.Exit(1)
+	}
 	proxy.Serve()
-	defer proxy.Close()
+	if err := proxy.Shutdown(timeout); err != nil {
+		slog.Warn("Shutdown did not complete", "err", err)
+	}
+}
+
+// loadConfig loads the configuration from the command line, the
+// environment and the config file.
+func loadConfig() (*config.Config, bool, error) {
+	defaults := config.Default()
+	defaults.Auth.Realm = "proxy"
+	return config.Load("http_proxy", os.Args[1:], defaults, "listen.port")
+}
+
+// reload switches the proxy over to next. In-flight requests finish with the
+// logger, access log, error pages and parsing options they started with, and
+// the old access log is closed once they had time to do so.
+func reload(p *proxy.Proxy, admin *server.Admin, logLevel *slog.LevelVar, old, next *config.Config) error {
+	logger, level, err := next.Logging.Logger("proxy", logLevel)
+	if err != nil {
+		return fmt.Errorf("failed to configure logging: %v", err)
+	}
+	errorPages, err := server.LoadErrorPages(next.Errors.Pages)
+	if err != nil {
+		return fmt.Errorf("failed to load error pages: %v", err)
+	}
+	auth, err := next.Auth.Authenticator()
+	if err != nil {
+		return fmt.Errorf("failed to configure authentication: %v", err)
+	}
+
+	running := p.CurrentAccessLog()
+	accessLog := running
+	if old.Logging.AccessLogChanged(next.Logging) {
+		if accessLog, err = next.Logging.OpenAccessLog(); err != nil {
+			return fmt.Errorf("failed to open access log: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(next.Listen, old.Listen) {
+		listeners, err := next.Listeners()
+		if err == nil {
+			err = p.SetListeners(listeners)
+		}
+		if err != nil {
+			if accessLog != nil && accessLog != running {
+				accessLog.Close()
+			}
+			return err
+		}
+	}
+
+	logLevel.Set(level)
+	slog.SetDefault(logger)
+	p.Reconfigure(accessLog, logger, errorPages, next.ParserOptions())
+	if running != nil && running != accessLog {
+		time.AfterFunc(time.Duration(next.Limits.ShutdownTimeout), func() { running.Close() })
+	}
+	if admin != nil {
+		adminAuth.Store(auth)
+		for _, upstream := range old.Proxy.Upstreams {
+			admin.RemoveCheck("upstream " + upstream)
+		}
+		for _, upstream := range next.Proxy.Upstreams {
+			admin.AddCheck("upstream "+upstream, proxy.CheckUpstream(upstream))
+		}
+	}
+	return nil
+}
+
+// adminAuth is the authentication of the admin endpoints, replaced when the
+// configuration is reloaded.
+var adminAuth atomic.Pointer[server.Auth]
+
+// startAdmin serves the admin endpoints in the background. When
+// authentication is configured they require credentials, and an admin ACL
+// file optionally restricts them further. The configured upstreams must be
+// reachable for the proxy to be ready.
+func startAdmin(p *proxy.Proxy, cfg *config.Config, level *slog.LevelVar, reloader *config.Reloader) (*server.Admin, error) {
+	admin := server.NewAdmin(cfg.Admin.Addr)
+	auth, err := cfg.Auth.Authenticator()
+	if err != nil {
+		return nil, err
+	}
+	adminAuth.Store(auth)
+	admin.AuthFunc = adminAuth.Load
+	if cfg.Admin.ACLFile != "" {
+		acl, err := server.LoadACL(cfg.Admin.ACLFile)
+		if err != nil {
+			return nil, err
+		}
+		admin.ACL = acl
+	}
+
+	timeout := time.Duration(cfg.Limits.ShutdownTimeout)
+	admin.AddCheck("listener", p.Ready)
+	for _, upstream := range cfg.Proxy.Upstreams {
+		admin.AddCheck("upstream "+upstream, proxy.CheckUpstream(upstream))
+	}
+	admin.AddStats("proxy", p.Stats)
+	p.Conns = server.NewConnTracker()
+	admin.Handle("/connections", p.Conns.Handler())
+	admin.Handle("/config", server.JSONHandler(func() any { return reloader.Current() }))
+	admin.Handle("/reload", reloader.Handler())
+	admin.Handle("/drain", p.DrainHandler())
+	admin.Handle("/shutdown", server.TriggerHandler(func() { p.Shutdown(timeout) }))
+	admin.Handle("/upgrade", server.TriggerHandler(func() { upgrade(p, admin, timeout) }))
+	admin.Handle("/loglevel", server.LevelHandler(level))
+	p.Metrics = server.NewMetrics()
+	admin.Handle("/metrics", p.Metrics.Handler())
+
+	if err := admin.Listen(); err != nil {
+		return nil, err
+	}
+	go admin.Serve()
+	return admin, nil
+}
+
+// upgrade hands the listeners over to a new process of the binary and, once
+// it listens, shuts down after the connections in flight finished.
+func upgrade(p *proxy.Proxy, admin *server.Admin, timeout time.Duration) {
+	if err := p.Upgrade(timeout, admin); err != nil {
+		slog.Error("Upgrade failed, continuing to serve", "err", err)
+		return
+	}
+	p.Shutdown(timeout)
 }
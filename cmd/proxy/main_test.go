@@ -0,0 +1,87 @@
+package main
+
+import (
+	"lab1/config"
+	"lab1/proxy"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminRequiresAuth(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	configs := map[string]config.Auth{
+		"users":     {Realm: "proxy", Users: write("htpasswd", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")},
+		"digest":    {Realm: "proxy", Digest: write("htdigest", "alice:proxy:0123456789abcdef0123456789abcdef\n")},
+		"token key": {Realm: "proxy", TokenKey: write("token.key", "0123456789abcdef0123456789abcdef\n")},
+	}
+	for name, auth := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Admin.Addr = "127.0.0.1:0"
+			cfg.Auth = auth
+			p, err := proxy.CreateProxy(0)
+			if err != nil {
+				t.Fatalf("failed to create proxy: %v", err)
+			}
+			admin, err := startAdmin(p, cfg, new(slog.LevelVar), config.NewReloader(cfg, nil, nil))
+			if err != nil {
+				t.Fatalf("failed to start admin: %v", err)
+			}
+			defer admin.Close()
+
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, httptest.NewRequest("POST", "/shutdown", nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("got %d, want 401", rec.Code)
+			}
+		})
+	}
+}
+
+func TestReloadAdminAuth(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(users, []byte("alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Admin.Addr = "127.0.0.1:0"
+	p, err := proxy.CreateProxy(0)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	logLevel := new(slog.LevelVar)
+	admin, err := startAdmin(p, cfg, logLevel, config.NewReloader(cfg, nil, nil))
+	if err != nil {
+		t.Fatalf("failed to start admin: %v", err)
+	}
+	defer admin.Close()
+	stats := func() int {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("GET", "/stats", nil))
+		return rec.Code
+	}
+	if code := stats(); code != http.StatusOK {
+		t.Fatalf("got %d without authentication, want 200", code)
+	}
+
+	next := config.Default()
+	next.Admin.Addr = cfg.Admin.Addr
+	next.Auth.Users = users
+	if err := reload(p, admin, logLevel, cfg, next); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if code := stats(); code != http.StatusUnauthorized {
+		t.Errorf("got %d after enabling authentication, want 401", code)
+	}
+}
@@ -1,42 +1,524 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"lab1/config"
 	"lab1/server"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	err := godotenv.Load(".env")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "token":
+			mintToken(os.Args[2:])
+			return
+		case "presign":
+			presignURL(os.Args[2:])
+			return
+		}
+	}
 
-	fmt.Println("Default directory: ", os.Getenv("FS"))
+	cfg, checkOnly, err := loadConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage()
+	}
 	if err != nil {
-        fmt.Printf("failed to load environment variables, create the .env file: %v\n", err)
+		fmt.Printf("failed to load configuration: %v\n", err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if checkOnly {
+		cfg.Dump(os.Stdout)
+		fmt.Println("configuration OK")
+		return
 	}
 
-	log.SetPrefix("[SERVER] ")
-	if len(os.Args) < 3 {
-		printUsage()
+	logLevel := new(slog.LevelVar)
+	logger, level, err := cfg.Logging.Logger("server", logLevel)
+	if err != nil {
+		fmt.Printf("failed to configure logging: %v\n", err)
+		os.Exit(1)
 	}
+	logLevel.Set(level)
+	slog.SetDefault(logger)
 
-	host := os.Args[1]
-	port, err := strconv.Atoi(os.Args[2])
-	server, err := server.CreateServer(host, port, 10)
+	server, err := server.CreateServer(cfg.Listen.Host, cfg.Listen.Port, cfg.Limits.MaxConnections)
 	if err != nil {
 		fmt.Printf("failed to start server with error: %v", err)
 		os.Exit(1)
 	}
 
-	server.Listen()
+	server.Root = cfg.Storage.Root
+	if err := os.MkdirAll(server.Root, 0777); err != nil {
+		fmt.Printf("failed to create storage root: %v\n", err)
+		os.Exit(1)
+	}
+	slog.Info("Serving files", "root", server.Root)
+
+	settings, err := buildSettings(server.CurrentSettings(), nil, cfg, logger)
+	if err != nil {
+		fmt.Printf("failed to configure server: %v\n", err)
+		os.Exit(1)
+	}
+	server.Reconfigure(settings)
+
+	configureMetrics(server, cfg)
+
+	if err := configureTracing(server, cfg.Observability.TraceExport); err != nil {
+		fmt.Printf("failed to configure tracing: %v\n", err)
+		os.Exit(1)
+	}
+
+	reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+		cfg, _, err := loadConfig()
+		return cfg, err
+	}, func(old, next *config.Config) error {
+		return reload(server, logLevel, old, next)
+	})
+
+	timeout := time.Duration(cfg.Limits.ShutdownTimeout)
+	admin, err := startAdmin(server, cfg, logLevel, reloader)
+	if err != nil {
+		fmt.Printf("failed to start admin endpoints: %v\n", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		<-signals
+		server.Shutdown(timeout)
+	}()
+
+	// SIGUSR2 hands the listeners over to a new process of the binary, for
+	// deploying a new build without dropping connections.
+	go func() {
+		upgrades := make(chan os.Signal, 1)
+		signal.Notify(upgrades, syscall.SIGUSR2)
+		for range upgrades {
+			upgrade(server, admin, timeout)
+		}
+	}()
+
+	// SIGHUP reloads the configuration and reopens the access log for
+	// tools such as logrotate.
+	go func() {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		for range hangups {
+			reloader.Reload()
+			for _, accessLog := range accessLogs(server.CurrentSettings()) {
+				if err := accessLog.Reopen(); err != nil {
+					slog.Error("Error reopening access log", "err", err)
+				}
+			}
+		}
+	}()
+
+	if server.Listeners, err = cfg.Listeners(); err != nil {
+		fmt.Printf("failed to configure listeners: %v\n", err)
+		os.Exit(1)
+	}
+	if err := server.Listen(); err != nil {
+		os.Exit(1)
+	}
 	server.Serve()
-	defer server.Close()
+	if err := server.Shutdown(timeout); err != nil {
+		slog.Warn("Shutdown did not complete", "err", err)
+	}
+}
+
+// loadConfig loads the configuration from the command line, the
+// environment and the config file.
+func loadConfig() (*config.Config, bool, error) {
+	return config.Load("http_server", os.Args[1:], config.Default(), "listen.host", "listen.port")
+}
+
+// buildSettings creates the server settings for cfg. When reloading, old is
+// the running configuration and running the running settings: the rate
+// limiter and access log are kept if their configuration did not change, and
+// IP bans carry over to the new filter.
+func buildSettings(running server.Settings, old, cfg *config.Config, logger *slog.Logger) (server.Settings, error) {
+	settings := server.Settings{Logger: logger, MIMETypes: cfg.MIME.Types, Index: cfg.Storage.Index, Listing: cfg.Storage.Listing, Parsing: cfg.ParserOptions(), AllowTrace: cfg.Observability.AllowTrace}
+
+	if err := configureAuth(&settings, cfg.Auth); err != nil {
+		return settings, fmt.Errorf("failed to configure authentication: %v", err)
+	}
+	if err := configureACL(&settings, cfg.Access.ACLFile); err != nil {
+		return settings, fmt.Errorf("failed to configure access control: %v", err)
+	}
+	if cfg.Rewrite.Rules != "" {
+		var err error
+		if settings.Rewrites, err = server.LoadRewriteRules(cfg.Rewrite.Rules); err != nil {
+			return settings, err
+		}
+	}
+	if len(cfg.Errors.Pages) > 0 {
+		var err error
+		if settings.ErrorPages, err = server.LoadErrorPages(cfg.Errors.Pages); err != nil {
+			return settings, fmt.Errorf("failed to load error pages: %v", err)
+		}
+	}
+	if cfg.Auth.PresignKey != "" {
+		if err := configurePresign(&settings, cfg.Auth.PresignKey); err != nil {
+			return settings, fmt.Errorf("failed to configure presigned URLs: %v", err)
+		}
+	}
+	if err := configureIPFilter(&settings, cfg.Access); err != nil {
+		return settings, fmt.Errorf("failed to configure IP filter: %v", err)
+	}
+	if settings.IPFilter != nil && running.IPFilter != nil {
+		settings.IPFilter.InheritBans(running.IPFilter)
+	}
+	configureHeaders(&settings, cfg.Headers)
+	if cfg.Storage.WebDAV {
+		// Keep the locks clients hold across reloads.
+		if settings.WebDAV = running.WebDAV; settings.WebDAV == nil {
+			settings.WebDAV = server.NewWebDAV()
+		}
+	}
+
+	if old != nil && reflect.DeepEqual(old.Limits.Rate, cfg.Limits.Rate) {
+		settings.RateLimiter = running.RateLimiter
+	} else {
+		configureRateLimits(&settings, cfg.Limits.Rate)
+	}
+
+	if err := configureVirtualHosts(&settings, running, old, cfg); err != nil {
+		return settings, fmt.Errorf("failed to configure virtual hosts: %v", err)
+	}
+
+	if old != nil && !old.Logging.AccessLogChanged(cfg.Logging) {
+		settings.AccessLog = running.AccessLog
+		return settings, nil
+	}
+	var err error
+	if settings.AccessLog, err = cfg.Logging.OpenAccessLog(); err != nil {
+		return settings, fmt.Errorf("failed to open access log: %v", err)
+	}
+	return settings, nil
+}
+
+// reload switches the server over to next. In-flight requests finish with
+// the settings they started with, and the old access log is closed once
+// they had time to do so.
+func reload(s *server.Server, logLevel *slog.LevelVar, old, next *config.Config) error {
+	logger, level, err := next.Logging.Logger("server", logLevel)
+	if err != nil {
+		return fmt.Errorf("failed to configure logging: %v", err)
+	}
+	running := s.CurrentSettings()
+	settings, err := buildSettings(running, old, next, logger)
+	if err == nil && !reflect.DeepEqual(next.Listen, old.Listen) {
+		var listeners []*server.Listener
+		if listeners, err = next.Listeners(); err == nil {
+			err = s.SetListeners(listeners)
+		}
+	}
+	if err != nil {
+		closeAccessLogs(accessLogs(settings), accessLogs(running))
+		return err
+	}
+
+	logLevel.Set(level)
+	slog.SetDefault(logger)
+	s.Reconfigure(settings)
+	time.AfterFunc(time.Duration(next.Limits.ShutdownTimeout), func() {
+		closeAccessLogs(accessLogs(running), accessLogs(settings))
+	})
+	return nil
+}
+
+// configureAuth enables the configured authentication, if any.
+func configureAuth(s *server.Settings, cfg config.Auth) error {
+	auth, err := cfg.Authenticator()
+	if err != nil {
+		return err
+	}
+	s.Auth = auth
+	return nil
+}
+
+// configureVirtualHosts sets up the virtual hosts and creates their roots.
+// When reloading, the access log of a host whose access log path did not
+// change is kept.
+func configureVirtualHosts(s *server.Settings, running server.Settings, old, cfg *config.Config) (err error) {
+	if len(cfg.VHosts.Hosts) == 0 {
+		return nil
+	}
+	var opened []*server.AccessLog
+	defer func() {
+		if err != nil {
+			closeAccessLogs(opened, nil)
+		}
+	}()
+
+	runningLogs := make(map[string]*server.AccessLog)
+	if old != nil && running.VirtualHosts != nil && !old.Logging.AccessLogChanged(cfg.Logging) {
+		for i, host := range old.VHosts.Hosts {
+			if host.AccessLog != "" && i < len(running.VirtualHosts.Hosts) {
+				runningLogs[host.AccessLog] = running.VirtualHosts.Hosts[i].AccessLog
+			}
+		}
+	}
+
+	var hosts []*server.VirtualHost
+	var defaultHost *server.VirtualHost
+	for _, hostCfg := range cfg.VHosts.Hosts {
+		host := &server.VirtualHost{
+			Names:     hostCfg.Names,
+			Root:      hostCfg.Root,
+			Index:     hostCfg.Index,
+			Listing:   hostCfg.Listing,
+			MIMETypes: hostCfg.MIMETypes,
+		}
+		hosts = append(hosts, host)
+		for _, name := range hostCfg.Names {
+			if strings.EqualFold(name, cfg.VHosts.Default) {
+				defaultHost = host
+			}
+		}
+
+		if err := os.MkdirAll(host.Root, 0777); err != nil {
+			return fmt.Errorf("failed to create root of %s: %v", hostCfg.Names[0], err)
+		}
+		if hostCfg.Auth != nil {
+			authCfg := *hostCfg.Auth
+			if authCfg.Realm == "" {
+				authCfg.Realm = cfg.Auth.Realm
+			}
+			var hostSettings server.Settings
+			if err := configureAuth(&hostSettings, authCfg); err != nil {
+				return fmt.Errorf("failed to configure authentication of %s: %v", hostCfg.Names[0], err)
+			}
+			host.Auth = hostSettings.Auth
+		}
+		if hostCfg.AccessLog != "" {
+			if host.AccessLog = runningLogs[hostCfg.AccessLog]; host.AccessLog == nil {
+				logging := cfg.Logging
+				logging.AccessLog = hostCfg.AccessLog
+				if host.AccessLog, err = logging.OpenAccessLog(); err != nil {
+					return fmt.Errorf("failed to open access log of %s: %v", hostCfg.Names[0], err)
+				}
+				opened = append(opened, host.AccessLog)
+			}
+		}
+	}
+
+	vhosts, err := server.NewVirtualHosts(hosts, defaultHost, cfg.VHosts.UnknownStatus)
+	if err != nil {
+		return err
+	}
+	s.VirtualHosts = vhosts
+	return nil
+}
+
+// configureACL loads the access control list from aclFile, if set.
+func configureACL(s *server.Settings, aclFile string) error {
+	if aclFile == "" {
+		return nil
+	}
+
+	acl, err := server.LoadACL(aclFile)
+	if err != nil {
+		return err
+	}
+	s.ACL = acl
+	return nil
+}
+
+// configurePresign enables presigned URLs signed with the key in keyFile.
+func configurePresign(s *server.Settings, keyFile string) error {
+	presigner, err := server.LoadPresignKey(keyFile)
+	if err != nil {
+		return err
+	}
+	s.Presigner = presigner
+	return nil
+}
+
+// configureRateLimits enables the per client limits, if any.
+func configureRateLimits(s *server.Settings, limits []server.RateLimit) {
+	if len(limits) > 0 {
+		s.RateLimiter = server.NewRateLimiter(limits)
+	}
+}
+
+// configureHeaders sets up the CORS policies and the security headers,
+// using the default security headers unless they are configured.
+func configureHeaders(s *server.Settings, cfg config.Headers) {
+	if len(cfg.CORS) > 0 {
+		s.CORS = &server.CORS{Policies: cfg.CORS}
+	}
+
+	s.SecurityHeaders = cfg.Security
+	if s.SecurityHeaders == nil {
+		s.SecurityHeaders = server.DefaultSecurityHeaders()
+	}
+}
+
+// configureIPFilter sets up connection filtering from the allow and deny
+// lists and the filter file. A positive ban threshold bans clients making
+// that many client errors, responses with the ban statuses, within the ban
+// window for the ban duration.
+func configureIPFilter(s *server.Settings, cfg config.Access) error {
+	if len(cfg.IPAllow) == 0 && len(cfg.IPDeny) == 0 && cfg.IPFilterFile == "" && cfg.BanThreshold == 0 {
+		return nil
+	}
+
+	allow, err := server.ParseNetworks(cfg.IPAllow)
+	if err != nil {
+		return err
+	}
+	deny, err := server.ParseNetworks(cfg.IPDeny)
+	if err != nil {
+		return err
+	}
+
+	filter := server.NewIPFilter(allow, deny)
+	if cfg.IPFilterFile != "" {
+		if err := filter.LoadFile(cfg.IPFilterFile); err != nil {
+			return err
+		}
+	}
+
+	filter.BanThreshold = cfg.BanThreshold
+	filter.BanWindow = time.Duration(cfg.BanWindow)
+	filter.BanDuration = time.Duration(cfg.BanDuration)
+	filter.BanStatuses = cfg.BanStatuses
+
+	s.IPFilter = filter
+	return nil
+}
+
+// configureMetrics enables metrics when they can be scraped, either from the
+// main port at the metrics path or from the admin listener.
+func configureMetrics(s *server.Server, cfg *config.Config) {
+	if cfg.Observability.MetricsPath == "" && cfg.Admin.Addr == "" {
+		return
+	}
+	s.Metrics = server.NewMetrics()
+	s.MetricsPath = cfg.Observability.MetricsPath
+}
+
+// configureTracing exports spans to target, a file or the URL of a
+// collector accepting JSON lines.
+func configureTracing(s *server.Server, target string) error {
+	if target == "" {
+		return nil
+	}
+	tracer, err := server.OpenTracer(target)
+	if err != nil {
+		return err
+	}
+	s.Tracer = tracer
+	return nil
+}
+
+// startAdmin serves the admin endpoints in the background if an admin
+// address is configured. They are protected by the server's current
+// authentication and, when an admin ACL file is configured, by a separate ACL. The config
+// endpoint shows the running configuration, which refers to secrets by file
+// only.
+func startAdmin(s *server.Server, cfg *config.Config, level *slog.LevelVar, reloader *config.Reloader) (*server.Admin, error) {
+	if cfg.Admin.Addr == "" {
+		return nil, nil
+	}
+	admin := server.NewAdmin(cfg.Admin.Addr)
+	admin.AuthFunc = func() *server.Auth { return s.CurrentSettings().Auth }
+	if cfg.Admin.ACLFile != "" {
+		acl, err := server.LoadACL(cfg.Admin.ACLFile)
+		if err != nil {
+			return nil, err
+		}
+		admin.ACL = acl
+	}
+
+	timeout := time.Duration(cfg.Limits.ShutdownTimeout)
+	admin.AddCheck("listener", s.Ready)
+	admin.AddCheck("storage", s.CheckRoot)
+	admin.AddStats("server", s.Stats)
+	s.Conns = server.NewConnTracker()
+	admin.Handle("/connections", s.Conns.Handler())
+	admin.Handle("/config", server.JSONHandler(func() any { return reloader.Current() }))
+	admin.Handle("/reload", reloader.Handler())
+	admin.Handle("/drain", s.DrainHandler())
+	admin.Handle("/shutdown", server.TriggerHandler(func() { s.Shutdown(timeout) }))
+	admin.Handle("/upgrade", server.TriggerHandler(func() { upgrade(s, admin, timeout) }))
+	admin.Handle("/loglevel", server.LevelHandler(level))
+	if s.Metrics != nil {
+		admin.Handle("/metrics", s.Metrics.Handler())
+	}
+	admin.Handle("/bans", bansHandler(s))
+	admin.Handle("/rewrites", s.RewriteHandler())
+
+	if err := admin.Listen(); err != nil {
+		return nil, err
+	}
+	go admin.Serve()
+	return admin, nil
+}
+
+// upgrade hands the listeners over to a new process of the binary and, once
+// it listens, shuts down after the connections in flight finished.
+func upgrade(s *server.Server, admin *server.Admin, timeout time.Duration) {
+	if err := s.Upgrade(timeout, admin); err != nil {
+		slog.Error("Upgrade failed, continuing to serve", "err", err)
+		return
+	}
+	s.Shutdown(timeout)
+}
+
+// bansHandler serves the bans of the server's current IP filter.
+func bansHandler(s *server.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := s.CurrentSettings().IPFilter
+		if filter == nil {
+			http.Error(w, "IP filtering is not enabled", http.StatusNotFound)
+			return
+		}
+		filter.BansHandler().ServeHTTP(w, r)
+	})
+}
+
+// accessLogs returns the access logs of the server and its virtual hosts.
+func accessLogs(s server.Settings) []*server.AccessLog {
+	var logs []*server.AccessLog
+	if s.AccessLog != nil {
+		logs = append(logs, s.AccessLog)
+	}
+	if s.VirtualHosts != nil {
+		logs = append(logs, s.VirtualHosts.AccessLogs()...)
+	}
+	return logs
+}
+
+// closeAccessLogs closes the access logs in logs that are not in keep.
+func closeAccessLogs(logs, keep []*server.AccessLog) {
+	for _, accessLog := range logs {
+		if !slices.Contains(keep, accessLog) {
+			accessLog.Close()
+		}
+	}
 }
 
 func printUsage() {
-	fmt.Println("Usage: http_server <host> <port>")
+	fmt.Println("Usage: http_server [-config file] [-check-config] [-<key> value]... [<host> <port>]")
+	fmt.Println("       http_server token -key <file> -sub <subject> [-scope op,op:/prefix]... [-ttl duration]")
+	fmt.Println("       http_server presign -key <file> -method <method> -path <path> [-ttl duration] [-base url]")
 	os.Exit(1)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"lab1/config"
+	"lab1/server"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// authConfigs returns configurations that each enable authentication with
+// only one of the credential files.
+func authConfigs(t *testing.T) map[string]config.Auth {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	return map[string]config.Auth{
+		"users":     {Realm: "files", Users: write("htpasswd", "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")},
+		"digest":    {Realm: "files", Digest: write("htdigest", "alice:files:0123456789abcdef0123456789abcdef\n")},
+		"token key": {Realm: "files", TokenKey: write("token.key", "0123456789abcdef0123456789abcdef\n")},
+	}
+}
+
+func TestAdminRequiresAuth(t *testing.T) {
+	for name, auth := range authConfigs(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Admin.Addr = "127.0.0.1:0"
+			cfg.Auth = auth
+			s, err := server.CreateServer("127.0.0.1", 0, 10)
+			if err != nil {
+				t.Fatalf("failed to create server: %v", err)
+			}
+			s.Root = t.TempDir()
+			if err := configureAuth(&s.Settings, cfg.Auth); err != nil {
+				t.Fatalf("failed to configure auth: %v", err)
+			}
+			admin, err := startAdmin(s, cfg, new(slog.LevelVar), config.NewReloader(cfg, nil, nil))
+			if err != nil {
+				t.Fatalf("failed to start admin: %v", err)
+			}
+			defer admin.Close()
+
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, httptest.NewRequest("POST", "/shutdown", nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("got %d, want 401", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lab1/server"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// presignURL implements the presign subcommand, printing a presigned URL.
+func presignURL(args []string) {
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	keyFile := flags.String("key", os.Getenv("PRESIGN_KEY"), "HMAC secret `file` shared with the server")
+	method := flags.String("method", http.MethodGet, "method the link allows, GET or POST")
+	path := flags.String("path", "", "path the link allows")
+	ttl := flags.Duration("ttl", time.Hour, "how long the link is valid")
+	contentLength := flags.Int64("content-length", -1, "required upload size in bytes, -1 for any")
+	contentType := flags.String("content-type", "", "required upload content type")
+	base := flags.String("base", "", "server URL to prefix the link with, e.g. http://localhost:8080")
+	flags.Parse(args)
+
+	if *keyFile == "" || !strings.HasPrefix(*path, "/") {
+		fmt.Fprintln(os.Stderr, "presign: -key and an absolute -path are required")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	presigner, err := server.LoadPresignKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "presign: %v\n", err)
+		os.Exit(1)
+	}
+
+	link := presigner.Sign(strings.ToUpper(*method), *path, time.Now().Add(*ttl), *contentLength, *contentType)
+	fmt.Println(strings.TrimSuffix(*base, "/") + link)
+}
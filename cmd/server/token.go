@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lab1/server"
+	"os"
+	"time"
+)
+
+// scopeFlags collects repeated -scope flags.
+type scopeFlags []server.Scope
+
+func (f *scopeFlags) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *scopeFlags) Set(value string) error {
+	scope, err := server.ParseScope(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, scope)
+	return nil
+}
+
+// mintToken implements the token subcommand, printing a signed bearer token.
+func mintToken(args []string) {
+	var scopes scopeFlags
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	keyFile := flags.String("key", os.Getenv("AUTH_TOKEN_KEY"), "HMAC secret or Ed25519 private key `file`")
+	subject := flags.String("sub", "", "subject the token is issued to")
+	ttl := flags.Duration("ttl", time.Hour, "how long the token is valid, 0 for no expiry")
+	notBefore := flags.Duration("nbf", 0, "delay before the token becomes valid")
+	flags.Var(&scopes, "scope", "operations allowed below a path, e.g. read,list:/public (repeatable)")
+	flags.Parse(args)
+
+	if *keyFile == "" || *subject == "" || len(scopes) == 0 {
+		fmt.Fprintln(os.Stderr, "token: -key, -sub and at least one -scope are required")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	tokens, err := server.LoadTokenKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "token: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	claims := server.Claims{
+		Subject:  *subject,
+		IssuedAt: now.Unix(),
+		Scopes:   scopes,
+	}
+	if *notBefore > 0 {
+		claims.NotBefore = now.Add(*notBefore).Unix()
+	}
+	if *ttl > 0 {
+		claims.ExpiresAt = now.Add(*notBefore + *ttl).Unix()
+	}
+
+	token, err := tokens.Sign(claims)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
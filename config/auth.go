@@ -0,0 +1,59 @@
+package config
+
+import (
+	"lab1/server"
+	"strings"
+)
+
+// Enabled reports whether an htpasswd file, a digest file or a bearer token
+// key is configured.
+func (a Auth) Enabled() bool {
+	return a.Users != "" || a.Digest != "" || a.TokenKey != ""
+}
+
+// Authenticator returns the configured authentication, nil if it is not
+// enabled. The require rules list the protected paths as
+// "[METHOD,...:]/prefix".
+func (a Auth) Authenticator() (*server.Auth, error) {
+	if !a.Enabled() {
+		return nil, nil
+	}
+
+	var users *server.UserFile
+	var err error
+	if a.Users != "" {
+		users, err = server.LoadUserFile(a.Users)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	auth, err := server.NewAuth(a.Realm, users)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.TokenKey != "" {
+		auth.Tokens, err = server.LoadTokenKey(a.TokenKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if a.Digest != "" {
+		auth.Digest, err = server.LoadDigestFile(a.Digest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rule := range a.Require {
+		methods, prefix, found := strings.Cut(rule, ":")
+		if !found {
+			auth.Require(rule)
+			continue
+		}
+		auth.Require(prefix, strings.Split(strings.ToUpper(methods), ",")...)
+	}
+	return auth, nil
+}
@@ -0,0 +1,621 @@
+// Package config loads the configuration of the server and the proxy from
+// defaults, a JSON or TOML file, environment variables and command line
+// flags, in increasing order of precedence.
+//
+// Every setting has a key in the file, such as "listen.port" for the port
+// key of the [listen] table, a flag of the same name (-listen.port) and an
+// environment variable given by its env tag (PORT).
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lab1/parser"
+	"lab1/server"
+	"log/slog"
+	"mime"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the configuration of the server and the proxy. The proxy only
+// uses the listen, limits, errors, logging, observability, admin and proxy
+// sections.
+//
+// Settings tagged reload:"restart" only take effect when the process is
+// restarted; all others are applied by a reload.
+type Config struct {
+	Listen        Listen        `json:"listen" toml:"listen"`
+	Limits        Limits        `json:"limits" toml:"limits"`
+	Storage       Storage       `json:"storage" toml:"storage"`
+	Auth          Auth          `json:"auth" toml:"auth"`
+	Access        Access        `json:"access" toml:"access"`
+	Headers       Headers       `json:"headers" toml:"headers"`
+	Rewrite       Rewrite       `json:"rewrite" toml:"rewrite"`
+	Errors        Errors        `json:"errors" toml:"errors"`
+	Logging       Logging       `json:"logging" toml:"logging"`
+	Observability Observability `json:"observability" toml:"observability"`
+	MIME          MIME          `json:"mime" toml:"mime"`
+	VHosts        VHosts        `json:"vhosts" toml:"vhosts"`
+	Admin         Admin         `json:"admin" toml:"admin"`
+	Proxy         Proxy         `json:"proxy" toml:"proxy"`
+}
+
+// Listen configures the listeners. Listeners, when set, replace the one
+// given by host and port.
+type Listen struct {
+	Host      string     `json:"host" toml:"host" env:"HOST" help:"address to listen on"`
+	Port      int        `json:"port" toml:"port" env:"PORT" help:"port to listen on, 0 picks a free one"`
+	Listeners []Listener `json:"listeners" toml:"listeners" env:"LISTENERS" help:"listeners with their own TLS and limits, JSON in the environment"`
+}
+
+// Limits configures connection and request limits.
+type Limits struct {
+	MaxConnections  int                `json:"max_connections" toml:"max_connections" env:"MAX_CONNECTIONS" reload:"restart" help:"connections handled at once (1-10)"`
+	ShutdownTimeout Duration           `json:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long a shutdown waits for active connections"`
+	Rate            []server.RateLimit `json:"rate" toml:"rate" env:"RATE_LIMITS" help:"per client limits as space separated /prefix=requests,burst,bytes entries"`
+	Parsing         string             `json:"parsing" toml:"parsing" env:"PARSING" help:"request parsing: strict rejects ambiguous framing, lenient accepts legacy clients"`
+	MaxHeaderBytes  int                `json:"max_header_bytes" toml:"max_header_bytes" env:"MAX_HEADER_BYTES" help:"longest request line and header section accepted"`
+}
+
+// Storage configures where files are stored.
+type Storage struct {
+	Root    string `json:"root" toml:"root" env:"FS" reload:"restart" help:"directory files are served from and stored in"`
+	Index   string `json:"index" toml:"index" env:"INDEX" help:"file served for requests to a directory"`
+	Listing bool   `json:"listing" toml:"listing" env:"LISTING" help:"list the files of directories without an index"`
+	WebDAV  bool   `json:"webdav" toml:"webdav" env:"WEBDAV" help:"serve WebDAV on the storage root"`
+}
+
+// Auth configures authentication.
+type Auth struct {
+	Realm      string   `json:"realm" toml:"realm" env:"AUTH_REALM" help:"authentication realm"`
+	Users      string   `json:"users" toml:"users" env:"AUTH_USERS" help:"htpasswd file enabling Basic authentication"`
+	Digest     string   `json:"digest" toml:"digest" env:"AUTH_DIGEST" help:"htdigest file enabling Digest authentication"`
+	TokenKey   string   `json:"token_key" toml:"token_key" env:"AUTH_TOKEN_KEY" help:"key file enabling bearer tokens"`
+	Require    []string `json:"require" toml:"require" env:"AUTH_REQUIRE" help:"protected paths as [METHOD,...:]/prefix rules"`
+	PresignKey string   `json:"presign_key" toml:"presign_key" env:"PRESIGN_KEY" help:"key file enabling presigned URLs"`
+}
+
+// Access configures access control and connection filtering.
+type Access struct {
+	ACLFile      string   `json:"acl_file" toml:"acl_file" env:"ACL_FILE" help:"access control list file"`
+	IPAllow      []string `json:"ip_allow" toml:"ip_allow" env:"IP_ALLOW" sep:"," help:"networks allowed to connect"`
+	IPDeny       []string `json:"ip_deny" toml:"ip_deny" env:"IP_DENY" sep:"," help:"networks denied from connecting"`
+	IPFilterFile string   `json:"ip_filter_file" toml:"ip_filter_file" env:"IP_FILTER_FILE" help:"file of allow and deny rules, reloaded on change"`
+	BanThreshold int      `json:"ban_threshold" toml:"ban_threshold" env:"BAN_THRESHOLD" help:"client errors within the ban window that ban a client, 0 disables bans"`
+	BanWindow    Duration `json:"ban_window" toml:"ban_window" env:"BAN_WINDOW" help:"window in which client errors are counted"`
+	BanDuration  Duration `json:"ban_duration" toml:"ban_duration" env:"BAN_DURATION" help:"how long a client stays banned"`
+	BanStatuses  []string `json:"ban_statuses" toml:"ban_statuses" env:"BAN_STATUSES" sep:"," help:"status codes or classes like 4xx counted as client errors"`
+}
+
+// Headers configures CORS and the security headers.
+type Headers struct {
+	CORS     []server.CORSPolicy     `json:"cors" toml:"cors" env:"CORS_POLICIES" help:"CORS policies, JSON in the environment"`
+	Security *server.SecurityHeaders `json:"security" toml:"security" env:"SECURITY_HEADERS" help:"security headers, JSON in the environment"`
+}
+
+// Rewrite configures URL rewrites and redirects.
+type Rewrite struct {
+	Rules string `json:"rules" toml:"rules" env:"REWRITE_RULES" help:"rewrite and redirect rules file, reloaded on change"`
+}
+
+// Errors configures the documents sent with error responses.
+type Errors struct {
+	Pages []server.ErrorPage `json:"pages" toml:"pages" env:"ERROR_PAGES" help:"error documents by status and path prefix, JSON in the environment"`
+}
+
+// Logging configures the diagnostic and access logs.
+type Logging struct {
+	Level            string   `json:"level" toml:"level" env:"LOG_LEVEL" help:"minimum level: debug, info, warn or error"`
+	Format           string   `json:"format" toml:"format" env:"LOG_FORMAT" help:"diagnostic log format: text or json"`
+	AccessLog        string   `json:"access_log" toml:"access_log" env:"ACCESS_LOG" help:"access log file, - for standard output"`
+	AccessLogFormat  string   `json:"access_log_format" toml:"access_log_format" env:"ACCESS_LOG_FORMAT" help:"access log format: common, combined or json"`
+	AccessLogMaxSize int64    `json:"access_log_max_size" toml:"access_log_max_size" env:"ACCESS_LOG_MAX_SIZE" help:"bytes after which the access log is rotated"`
+	AccessLogRotate  Duration `json:"access_log_rotate" toml:"access_log_rotate" env:"ACCESS_LOG_ROTATE" help:"interval after which the access log is rotated"`
+	AccessLogBackups int      `json:"access_log_backups" toml:"access_log_backups" env:"ACCESS_LOG_BACKUPS" help:"rotated access logs to keep"`
+}
+
+// Observability configures metrics and tracing.
+type Observability struct {
+	MetricsPath string `json:"metrics_path" toml:"metrics_path" env:"METRICS_PATH" reload:"restart" help:"path serving metrics on the main port"`
+	TraceExport string `json:"trace_export" toml:"trace_export" env:"TRACE_EXPORT" reload:"restart" help:"file or collector URL receiving spans"`
+	AllowTrace  bool   `json:"allow_trace" toml:"allow_trace" env:"ALLOW_TRACE" help:"answer the TRACE method by echoing requests, for debugging"`
+}
+
+// MIME configures the content types of served and stored files.
+type MIME struct {
+	Types map[string]string `json:"types" toml:"types" env:"MIME_TYPES" help:"extra content types as space separated .ext=type entries"`
+}
+
+// Admin configures the admin listener.
+type Admin struct {
+	Addr    string `json:"addr" toml:"addr" env:"ADMIN_ADDR" reload:"restart" help:"host:port of the admin listener"`
+	ACLFile string `json:"acl_file" toml:"acl_file" env:"ADMIN_ACL_FILE" reload:"restart" help:"access control list for the admin endpoints"`
+}
+
+// Proxy configures the proxy.
+type Proxy struct {
+	Upstreams []string `json:"upstreams" toml:"upstreams" env:"PROXY_UPSTREAMS" sep:"," help:"host:port upstreams that must be reachable for readiness"`
+}
+
+// Default returns the default configuration of the server.
+func Default() *Config {
+	return &Config{
+		Listen:  Listen{Host: "0.0.0.0", Port: 8080},
+		Limits:  Limits{MaxConnections: 10, ShutdownTimeout: Duration(30 * time.Second), Parsing: "strict", MaxHeaderBytes: parser.DefaultMaxHeaderBytes},
+		Storage: Storage{Root: "fs"},
+		Auth:    Auth{Realm: "http_server", Require: []string{"POST:/"}},
+		Access:  Access{BanWindow: Duration(time.Minute), BanDuration: Duration(10 * time.Minute), BanStatuses: []string{"4xx"}},
+		Logging: Logging{Level: "info", Format: "text", AccessLogFormat: server.LogCommon},
+		VHosts:  VHosts{UnknownStatus: 421},
+		Admin:   Admin{Addr: "127.0.0.1:9090"},
+	}
+}
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and args. Positional arguments are assigned to
+// the keys in positional, keeping "http_server <host> <port>" working.
+// CheckOnly reports whether -check-config was given.
+func Load(name string, args []string, defaults *Config, positional ...string) (cfg *Config, checkOnly bool, err error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON or TOML configuration `file` (env CONFIG_FILE)")
+	fs.BoolVar(&checkOnly, "check-config", false, "validate the configuration, print it and exit")
+
+	var set []setting
+	for _, f := range fields(defaults) {
+		f := f
+		usage := f.help
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		fs.Func(f.key, usage, func(value string) error {
+			set = append(set, setting{f.key, value})
+			return nil
+		})
+	}
+	fs.Usage = func() {
+		usage := "Usage: " + name + " [flags]"
+		for _, key := range positional {
+			usage += " [<" + key + ">]"
+		}
+		fmt.Fprintf(fs.Output(), "%s\n\nFlags:\n", usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if fs.NArg() > len(positional) {
+		return nil, false, fmt.Errorf("unexpected arguments %q", fs.Args()[len(positional):])
+	}
+	for i, value := range fs.Args() {
+		set = append(set, setting{positional[i], value})
+	}
+
+	cfg = defaults
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, false, err
+	}
+	for _, s := range set {
+		if err := cfg.set(s.key, s.value); err != nil {
+			return nil, false, fmt.Errorf("flag -%s: %v", s.key, err)
+		}
+	}
+	return cfg, checkOnly, nil
+}
+
+type setting struct {
+	key, value string
+}
+
+// loadFile reads the configuration file at path, JSON or TOML depending on
+// its extension. Unknown keys are errors.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config file extension %q, use .json or .toml", path, ext)
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables that are set.
+func (c *Config) loadEnv() error {
+	for _, f := range fields(c) {
+		if f.env == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := c.set(f.key, value); err != nil {
+				return fmt.Errorf("%s: %v", f.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// set parses value into the setting with the given key.
+func (c *Config) set(key, value string) error {
+	for _, f := range fields(c) {
+		if f.key == key {
+			return parseValue(f, value)
+		}
+	}
+	return fmt.Errorf("unknown setting %q", key)
+}
+
+// field is a leaf setting of a Config.
+type field struct {
+	key     string
+	env     string
+	sep     string
+	help    string
+	restart bool
+	value   reflect.Value
+}
+
+// fields lists the settings of c in declaration order.
+func fields(c *Config) []field {
+	var out []field
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i)
+		values := sections.Field(i)
+		for j := 0; j < values.NumField(); j++ {
+			setting := values.Type().Field(j)
+			out = append(out, field{
+				key:     section.Tag.Get("toml") + "." + setting.Tag.Get("toml"),
+				env:     setting.Tag.Get("env"),
+				sep:     setting.Tag.Get("sep"),
+				help:    setting.Tag.Get("help"),
+				restart: setting.Tag.Get("reload") == "restart",
+				value:   values.Field(j),
+			})
+		}
+	}
+	return out
+}
+
+// parseValue parses the string form of a setting, as used by flags and
+// environment variables. Lists are separated by the field's sep tag, or by
+// white space if it has none.
+func parseValue(f field, s string) error {
+	switch target := f.value.Addr().Interface().(type) {
+	case *string:
+		*target = s
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		*target = b
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		*target = n
+	case *int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		*target = n
+	case *Duration:
+		return target.UnmarshalText([]byte(s))
+	case *[]string:
+		if f.sep == "" {
+			*target = strings.Fields(s)
+			break
+		}
+		*target = nil
+		for _, item := range strings.Split(s, f.sep) {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+	case *map[string]string:
+		*target = make(map[string]string)
+		for _, entry := range strings.Fields(s) {
+			key, value, found := strings.Cut(entry, "=")
+			if !found {
+				return fmt.Errorf("invalid entry %q, expected key=value", entry)
+			}
+			(*target)[key] = value
+		}
+	case *[]server.RateLimit:
+		limits, err := ParseRateLimits(s)
+		if err != nil {
+			return err
+		}
+		*target = limits
+	case *[]Listener, *[]VirtualHost, *[]server.CORSPolicy, *[]server.ErrorPage, **server.SecurityHeaders:
+		if err := json.Unmarshal([]byte(s), target); err != nil {
+			return fmt.Errorf("invalid JSON: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// ParseRateLimits parses a space separated list of "/prefix=requests,burst,bytes"
+// entries, e.g. "/=10,20,0 /uploads=1,2,65536".
+func ParseRateLimits(s string) ([]server.RateLimit, error) {
+	var limits []server.RateLimit
+	for _, entry := range strings.Fields(s) {
+		prefix, values, _ := strings.Cut(entry, "=")
+		fields := strings.Split(values, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid rate limit %q, expected /prefix=requests,burst,bytes", entry)
+		}
+
+		requests, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request rate in %q", entry)
+		}
+		burst, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid burst in %q", entry)
+		}
+		bandwidth, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth in %q", entry)
+		}
+		limits = append(limits, server.RateLimit{Prefix: prefix, Requests: requests, Burst: burst, Bandwidth: bandwidth})
+	}
+	return limits, nil
+}
+
+// Duration is a time.Duration written as a string such as "30s".
+type Duration time.Duration
+
+// UnmarshalText parses a duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText formats the duration.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Validate checks the configuration and returns all problems found, each
+// prefixed with the key of the offending setting.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+		}
+	}
+
+	if c.Listen.Port < 0 || c.Listen.Port > 65535 {
+		check("listen.port", fmt.Errorf("must be between 0 and 65535, got %d", c.Listen.Port))
+	}
+	c.validateListeners(check)
+	if c.Limits.MaxConnections < 1 || c.Limits.MaxConnections > 10 {
+		check("limits.max_connections", fmt.Errorf("must be between 1 and 10, got %d", c.Limits.MaxConnections))
+	}
+	if c.Limits.ShutdownTimeout < 0 {
+		check("limits.shutdown_timeout", errors.New("must not be negative"))
+	}
+	if _, err := parser.ParseMode(c.Limits.Parsing); err != nil {
+		check("limits.parsing", err)
+	}
+	if c.Limits.MaxHeaderBytes < 0 {
+		check("limits.max_header_bytes", errors.New("must not be negative"))
+	}
+	for _, limit := range c.Limits.Rate {
+		if !strings.HasPrefix(limit.Prefix, "/") {
+			check("limits.rate", fmt.Errorf("prefix %q must start with /", limit.Prefix))
+		}
+		if limit.Requests < 0 || limit.Burst < 0 || limit.Bandwidth < 0 {
+			check("limits.rate", fmt.Errorf("limits for %q must not be negative", limit.Prefix))
+		}
+	}
+
+	if c.Storage.Root == "" {
+		check("storage.root", errors.New("must be set"))
+	}
+	if strings.ContainsRune(c.Storage.Index, '/') {
+		check("storage.index", fmt.Errorf("%q must be a file name", c.Storage.Index))
+	}
+
+	check("auth.users", checkFile(c.Auth.Users))
+	check("auth.digest", checkFile(c.Auth.Digest))
+	check("auth.token_key", checkFile(c.Auth.TokenKey))
+	check("auth.presign_key", checkFile(c.Auth.PresignKey))
+	if c.Auth.Digest != "" && c.Auth.Users == "" && c.Auth.TokenKey == "" {
+		check("auth.digest", errors.New("requires auth.users or auth.token_key"))
+	}
+	for _, rule := range c.Auth.Require {
+		prefix := rule
+		if _, p, found := strings.Cut(rule, ":"); found {
+			prefix = p
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			check("auth.require", fmt.Errorf("invalid rule %q, expected [METHOD,...:]/prefix", rule))
+		}
+	}
+
+	check("access.acl_file", checkFile(c.Access.ACLFile))
+	check("access.ip_filter_file", checkFile(c.Access.IPFilterFile))
+	_, err := server.ParseNetworks(c.Access.IPAllow)
+	check("access.ip_allow", err)
+	_, err = server.ParseNetworks(c.Access.IPDeny)
+	check("access.ip_deny", err)
+	if c.Access.BanThreshold < 0 {
+		check("access.ban_threshold", errors.New("must not be negative"))
+	}
+	for _, status := range c.Access.BanStatuses {
+		check("access.ban_statuses", server.CheckBanStatus(status))
+	}
+
+	for _, policy := range c.Headers.CORS {
+		if !strings.HasPrefix(policy.Prefix, "/") {
+			check("headers.cors", fmt.Errorf("prefix %q must start with /", policy.Prefix))
+		}
+		if policy.AllowCredentials && slices.Contains(policy.AllowedOrigins, "*") {
+			check("headers.cors", fmt.Errorf("policy for %q allows credentials from any origin", policy.Prefix))
+		}
+	}
+
+	check("rewrite.rules", checkFile(c.Rewrite.Rules))
+	for i, page := range c.Errors.Pages {
+		key := fmt.Sprintf("errors.pages[%d]", i)
+		check(key, server.CheckErrorPage(page))
+		check(key, checkFile(page.File))
+	}
+
+	check("logging.level", new(slog.LevelVar).UnmarshalText([]byte(c.Logging.Level)))
+	if _, err := server.NewLogger(io.Discard, c.Logging.Format, nil); err != nil {
+		check("logging.format", err)
+	}
+	switch c.Logging.AccessLogFormat {
+	case server.LogCommon, server.LogCombined, server.LogJSON:
+	default:
+		check("logging.access_log_format", fmt.Errorf("must be common, combined or json, got %q", c.Logging.AccessLogFormat))
+	}
+	if c.Logging.AccessLogMaxSize < 0 || c.Logging.AccessLogRotate < 0 || c.Logging.AccessLogBackups < 0 {
+		check("logging", errors.New("access log rotation limits must not be negative"))
+	}
+
+	if path := c.Observability.MetricsPath; path != "" && !strings.HasPrefix(path, "/") {
+		check("observability.metrics_path", fmt.Errorf("%q must start with /", path))
+	}
+
+	for _, err := range checkMIMETypes(c.MIME.Types) {
+		check("mime.types", err)
+	}
+	c.validateVHosts(check)
+
+	if c.Admin.Addr != "" {
+		host, _, err := net.SplitHostPort(c.Admin.Addr)
+		if err == nil && !isLoopback(host) && !c.Auth.Enabled() {
+			err = errors.New("a non-loopback address requires authentication")
+		}
+		check("admin.addr", err)
+	}
+	check("admin.acl_file", checkFile(c.Admin.ACLFile))
+
+	for _, upstream := range c.Proxy.Upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			check("proxy.upstreams", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkMIMETypes verifies that types maps extensions to valid content types.
+func checkMIMETypes(types map[string]string) []error {
+	var errs []error
+	for ext, contentType := range types {
+		if !strings.HasPrefix(ext, ".") {
+			errs = append(errs, fmt.Errorf("extension %q must start with a dot", ext))
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			errs = append(errs, fmt.Errorf("invalid content type %q for %s", contentType, ext))
+		}
+	}
+	return errs
+}
+
+// checkFile verifies that the file at path, if set, can be read.
+func checkFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// isLoopback reports whether host only accepts connections from the local
+// machine.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// RestartRequired returns the keys of the settings that differ between c and
+// next but are only applied on restart.
+func (c *Config) RestartRequired(next *Config) []string {
+	var keys []string
+	nextFields := fields(next)
+	for i, f := range fields(c) {
+		if f.restart && !reflect.DeepEqual(f.value.Interface(), nextFields[i].value.Interface()) {
+			keys = append(keys, f.key)
+		}
+	}
+	return keys
+}
+
+// keepRestartSettings copies the settings that are only applied on restart
+// from running into c.
+func (c *Config) keepRestartSettings(running *Config) {
+	runningFields := fields(running)
+	for i, f := range fields(c) {
+		if f.restart {
+			f.value.Set(runningFields[i].value)
+		}
+	}
+}
+
+// Dump writes the configuration as indented JSON.
+func (c *Config) Dump(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// ParserOptions returns the options requests are parsed with.
+func (c *Config) ParserOptions() parser.Options {
+	mode, _ := parser.ParseMode(c.Limits.Parsing)
+	return parser.Options{Mode: mode, MaxHeaderBytes: c.Limits.MaxHeaderBytes}
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"lab1/server"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "http.toml")
+	os.WriteFile(file, []byte(`
+[listen]
+host = "127.0.0.1"
+port = 9000
+
+[limits]
+shutdown_timeout = "5s"
+rate = [{ prefix = "/", requests = 10, burst = 20 }]
+
+[storage]
+root = "/srv/files"
+`), 0600)
+
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("PORT", "9001")
+	t.Setenv("AUTH_REQUIRE", "POST,PUT:/ /private")
+
+	cfg, checkOnly, err := Load("test", []string{"-storage.root", "/data", "-check-config", "localhost"}, Default(), "listen.host", "listen.port")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !checkOnly {
+		t.Error("check-config not reported")
+	}
+	if cfg.Listen.Host != "localhost" || cfg.Listen.Port != 9001 {
+		t.Errorf("got listen %+v, want the positional host and the environment port", cfg.Listen)
+	}
+	if cfg.Storage.Root != "/data" {
+		t.Errorf("got root %q, want the flag", cfg.Storage.Root)
+	}
+	if time.Duration(cfg.Limits.ShutdownTimeout) != 5*time.Second || len(cfg.Limits.Rate) != 1 || cfg.Limits.Rate[0].Burst != 20 {
+		t.Errorf("got limits %+v from the file", cfg.Limits)
+	}
+	if strings.Join(cfg.Auth.Require, " ") != "POST,PUT:/ /private" {
+		t.Errorf("got require rules %q", cfg.Auth.Require)
+	}
+	if cfg.Limits.MaxConnections != 10 || cfg.Logging.AccessLogFormat != "common" {
+		t.Errorf("defaults were lost: %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "http.json")
+	os.WriteFile(unknown, []byte(`{"listen": {"adress": "0.0.0.0"}}`), 0600)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "Unknown file key", args: []string{"-config", unknown}, want: "adress"},
+		{name: "Unsupported extension", args: []string{"-config", "http.yaml"}, want: "failed to read"},
+		{name: "Invalid integer", args: []string{"-listen.port", "http"}, want: "invalid integer"},
+		{name: "Invalid duration", args: []string{"-limits.shutdown_timeout", "soon"}, want: "invalid duration"},
+		{name: "Invalid rate limit", args: []string{"-limits.rate", "/=1,2"}, want: "invalid rate limit"},
+		{name: "Too many arguments", args: []string{"0.0.0.0", "80", "extra"}, want: "unexpected arguments"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := Load("test", tt.args, Default(), "listen.host", "listen.port")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+
+	cfg := Default()
+	cfg.Listen.Port = 70000
+	cfg.Limits.MaxConnections = 11
+	cfg.Limits.Parsing = "loose"
+	cfg.Auth.Users = filepath.Join(t.TempDir(), "missing")
+	cfg.Access.IPDeny = []string{"10.0.0.0/33"}
+	cfg.Access.BanStatuses = []string{"4xx", "200"}
+	cfg.Logging.Level = "loud"
+	cfg.Admin.Addr = "9090"
+	cfg.Headers.CORS = []server.CORSPolicy{{Prefix: "/", AllowedOrigins: []string{"*"}, AllowCredentials: true}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration passed validation")
+	}
+	for _, key := range []string{"listen.port", "limits.max_connections", "limits.parsing", "auth.users", "access.ip_deny", "access.ban_statuses", "logging.level", "admin.addr", "headers.cors"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("error does not report %s:\n%v", key, err)
+		}
+	}
+}
+
+func TestValidateAdminAddr(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(users, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr, users string
+		valid       bool
+	}{
+		{"127.0.0.1:9090", "", true},
+		{"[::1]:9090", "", true},
+		{"localhost:9090", "", true},
+		{"", "", true},
+		{"0.0.0.0:9090", "", false},
+		{":9090", "", false},
+		{"10.0.0.1:9090", "", false},
+		{"0.0.0.0:9090", users, true},
+	}
+	for _, test := range tests {
+		cfg := Default()
+		cfg.Admin.Addr = test.addr
+		cfg.Auth.Users = test.users
+		if err := cfg.Validate(); (err == nil) != test.valid {
+			t.Errorf("%q with users %q: got %v, want valid %v", test.addr, test.users, err, test.valid)
+		}
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	cfg, _, err := Load("test", []string{"-config", "../http_server.example.toml"}, Default())
+	if err != nil {
+		t.Fatalf("failed to load the example: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("the example is invalid: %v", err)
+	}
+	if len(cfg.Limits.Rate) != 2 || len(cfg.Headers.CORS) != 1 || cfg.Admin.Addr != "127.0.0.1:9090" {
+		t.Errorf("got %+v", cfg)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	cfg, _, err := Load("test", []string{"-listen.listeners", `[{"name": "web", "network": "tcp6", "address": "[::1]:8080", "max_connections": 5, "timeout": "1m"}, {"network": "unix", "address": "/run/http.sock", "mode": "0660"}]`}, Default())
+	if err != nil {
+		t.Fatalf("failed to load listeners: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("listeners are invalid: %v", err)
+	}
+	listeners, err := cfg.Listeners()
+	if err != nil {
+		t.Fatalf("failed to build listeners: %v", err)
+	}
+	if len(listeners) != 2 || listeners[0].MaxConnections != 5 || listeners[0].Timeout != time.Minute || listeners[1].Mode != 0660 {
+		t.Errorf("got listeners %+v, %+v", listeners[0], listeners[1])
+	}
+
+	cfg.Listen.Listeners = []Listener{
+		{Name: "a", Network: "tcp", Address: "8080"},
+		{Name: "a", Network: "udp", Address: ":53"},
+		{Network: "tcp", Address: ":8443", Mode: "0600", TLSCert: "cert.pem", TLSMinVersion: "1.4"},
+	}
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid listeners passed validation")
+	}
+	for _, want := range []string{"[0]: address 8080", "[1]: duplicate name", "[1]: network must be", "[2]: mode must be", "[2]: tls_cert and tls_key", "[2]: tls_min_version"} {
+		if !strings.Contains(err.Error(), "listen.listeners"+want) {
+			t.Errorf("error does not report listen.listeners%s:\n%v", want, err)
+		}
+	}
+}
+
+func TestVHosts(t *testing.T) {
+	cfg, _, err := Load("test", []string{"-vhosts.hosts", `[{"names": ["example.com", "*.example.com"], "root": "/srv/example", "index": "index.html", "mime_types": {".js": "text/javascript"}}]`, "-vhosts.default", "example.com"}, Default())
+	if err != nil {
+		t.Fatalf("failed to load virtual hosts: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("virtual hosts are invalid: %v", err)
+	}
+
+	cfg.VHosts.Hosts = append(cfg.VHosts.Hosts,
+		VirtualHost{Names: []string{"Example.com", "a:80"}, Index: "a/index.html", Auth: &Auth{}},
+		VirtualHost{MIMETypes: map[string]string{"js": "text/javascript"}},
+	)
+	cfg.VHosts.Default = "example.org"
+	cfg.VHosts.UnknownStatus = 400
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid virtual hosts passed validation")
+	}
+	for _, want := range []string{"hosts[1]: duplicate name", "hosts[1]: invalid name", "hosts[1]: root must be set", "hosts[1]: index", "hosts[1]: auth requires", "hosts[2]: names must be set", "hosts[2]: extension", "default:", "unknown_status:"} {
+		if !strings.Contains(err.Error(), "vhosts."+want) {
+			t.Errorf("error does not report vhosts.%s:\n%v", want, err)
+		}
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "404.html")
+	if err := os.WriteFile(page, []byte("not found"), 0600); err != nil {
+		t.Fatalf("failed to write error page: %v", err)
+	}
+	t.Setenv("ERROR_PAGES", `[{"status": "404", "prefix": "/docs", "file": "`+page+`"}]`)
+	cfg, _, err := Load("test", nil, Default())
+	if err != nil {
+		t.Fatalf("failed to load error pages: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("error pages are invalid: %v", err)
+	}
+	want := []server.ErrorPage{{Status: "404", Prefix: "/docs", File: page}}
+	if !reflect.DeepEqual(cfg.Errors.Pages, want) {
+		t.Errorf("got %+v, want %+v", cfg.Errors.Pages, want)
+	}
+
+	cfg.Errors.Pages = append(cfg.Errors.Pages, server.ErrorPage{Status: "200", File: page}, server.ErrorPage{Status: "5xx", File: page + ".missing"})
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "errors.pages[1]: invalid error page status") || !strings.Contains(err.Error(), "errors.pages[2]: open") {
+		t.Errorf("got %v, want errors for pages 1 and 2", err)
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"lab1/server"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Listener configures one of the addresses the server or the proxy accepts
+// connections on.
+type Listener struct {
+	// Name identifies the listener in logs.
+	Name string `json:"name,omitempty" toml:"name"`
+	// Network is "tcp", "tcp4", "tcp6", "unix" or "systemd".
+	Network string `json:"network" toml:"network"`
+	// Address is host:port, a socket path or a systemd socket name.
+	Address string `json:"address" toml:"address"`
+	// Mode is the octal file mode of a Unix domain socket, e.g. "0660".
+	Mode string `json:"mode,omitempty" toml:"mode"`
+	// TLSCert and TLSKey are PEM files that enable TLS.
+	TLSCert string `json:"tls_cert,omitempty" toml:"tls_cert"`
+	TLSKey  string `json:"tls_key,omitempty" toml:"tls_key"`
+	// TLSMinVersion is the lowest TLS version accepted: 1.0 to 1.3.
+	TLSMinVersion string `json:"tls_min_version,omitempty" toml:"tls_min_version"`
+	// MaxConnections limits the open connections from this listener.
+	MaxConnections int `json:"max_connections,omitempty" toml:"max_connections"`
+	// Timeout is how long a connection from this listener may stay open.
+	Timeout Duration `json:"timeout,omitempty" toml:"timeout"`
+}
+
+// tlsVersions maps the accepted TLS version names to their values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Listeners returns the listeners to serve on: the configured listeners, or
+// a single TCP listener on host and port. Certificates are loaded from disk.
+func (c *Config) Listeners() ([]*server.Listener, error) {
+	if len(c.Listen.Listeners) == 0 {
+		address := net.JoinHostPort(c.Listen.Host, strconv.Itoa(c.Listen.Port))
+		return []*server.Listener{{Network: "tcp", Address: address}}, nil
+	}
+
+	var listeners []*server.Listener
+	for i, l := range c.Listen.Listeners {
+		listener, err := l.build()
+		if err != nil {
+			return nil, fmt.Errorf("listen.listeners[%d]: %v", i, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// build creates the server listener, loading its certificate.
+func (l Listener) build() (*server.Listener, error) {
+	listener := &server.Listener{
+		Name:           l.Name,
+		Network:        l.Network,
+		Address:        l.Address,
+		MaxConnections: l.MaxConnections,
+		Timeout:        time.Duration(l.Timeout),
+	}
+	if l.Mode != "" {
+		mode, err := strconv.ParseUint(l.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode %q", l.Mode)
+		}
+		listener.Mode = os.FileMode(mode)
+	}
+	if l.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(l.TLSCert, l.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %v", err)
+		}
+		listener.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tlsVersions[l.TLSMinVersion]}
+	}
+	return listener, nil
+}
+
+// validateListeners checks the configured listeners.
+func (c *Config) validateListeners(check func(string, error)) {
+	names := make(map[string]bool)
+	for i, l := range c.Listen.Listeners {
+		key := fmt.Sprintf("listen.listeners[%d]", i)
+		if l.Name != "" {
+			if names[l.Name] {
+				check(key, fmt.Errorf("duplicate name %q", l.Name))
+			}
+			names[l.Name] = true
+		}
+
+		switch l.Network {
+		case "tcp", "tcp4", "tcp6":
+			if _, _, err := net.SplitHostPort(l.Address); err != nil {
+				check(key, err)
+			}
+		case "unix", "systemd":
+			if l.Address == "" {
+				check(key, errors.New("address must be set"))
+			}
+		default:
+			check(key, fmt.Errorf("network must be tcp, tcp4, tcp6, unix or systemd, got %q", l.Network))
+		}
+		if l.Mode != "" {
+			if _, err := strconv.ParseUint(l.Mode, 8, 32); err != nil || l.Network != "unix" {
+				check(key, fmt.Errorf("mode must be an octal file mode of a unix listener, got %q", l.Mode))
+			}
+		}
+
+		if (l.TLSCert == "") != (l.TLSKey == "") {
+			check(key, errors.New("tls_cert and tls_key must be set together"))
+		}
+		check(key, checkFile(l.TLSCert))
+		check(key, checkFile(l.TLSKey))
+		if _, ok := tlsVersions[l.TLSMinVersion]; l.TLSMinVersion != "" && !ok {
+			check(key, fmt.Errorf("tls_min_version must be 1.0, 1.1, 1.2 or 1.3, got %q", l.TLSMinVersion))
+		}
+		if l.MaxConnections < 0 || l.Timeout < 0 {
+			check(key, errors.New("max_connections and timeout must not be negative"))
+		}
+	}
+}
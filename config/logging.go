@@ -0,0 +1,54 @@
+package config
+
+import (
+	"lab1/server"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Logger creates a logger writing to standard error in the configured
+// format, discarding records below logLevel, and returns it along with the
+// configured level.
+func (l Logging) Logger(component string, logLevel *slog.LevelVar) (*slog.Logger, slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return nil, level, err
+	}
+
+	logger, err := server.NewLogger(os.Stderr, l.Format, logLevel)
+	if err != nil {
+		return nil, level, err
+	}
+	return logger.With("component", component), level, nil
+}
+
+// AccessLogChanged reports whether the access log settings differ from
+// those in next.
+func (l Logging) AccessLogChanged(next Logging) bool {
+	l.Level, l.Format = "", ""
+	next.Level, next.Format = "", ""
+	return l != next
+}
+
+// OpenAccessLog opens the configured access log, "-" for standard output.
+// A file is rotated by size or age, keeping the configured number of old
+// files.
+func (l Logging) OpenAccessLog() (*server.AccessLog, error) {
+	if l.AccessLog == "" {
+		return nil, nil
+	}
+	if l.AccessLog == "-" {
+		return server.NewAccessLog(os.Stdout, l.AccessLogFormat)
+	}
+
+	file, err := server.OpenRotatingFile(l.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	file.MaxSize = l.AccessLogMaxSize
+	file.RotateEvery = time.Duration(l.AccessLogRotate)
+	file.MaxBackups = l.AccessLogBackups
+
+	return server.NewAccessLog(file, l.AccessLogFormat)
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"lab1/server"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var level slog.LevelVar
+	if _, got, err := (Logging{Level: "warn", Format: "json"}).Logger("test", &level); err != nil || got != slog.LevelWarn {
+		t.Errorf("got level %v, %v", got, err)
+	}
+	if _, _, err := (Logging{Level: "loud", Format: "text"}).Logger("test", &level); err == nil {
+		t.Error("invalid level was accepted")
+	}
+}
+
+func TestAccessLogChanged(t *testing.T) {
+	old := Logging{Level: "info", Format: "text", AccessLog: "-"}
+	if old.AccessLogChanged(Logging{Level: "debug", Format: "json", AccessLog: "-"}) {
+		t.Error("diagnostic log settings were taken for access log changes")
+	}
+	if !old.AccessLogChanged(Logging{Level: "info", Format: "text", AccessLog: "access.log"}) {
+		t.Error("a new access log file was not noticed")
+	}
+}
+
+func TestOpenAccessLog(t *testing.T) {
+	if accessLog, err := (Logging{}).OpenAccessLog(); accessLog != nil || err != nil {
+		t.Errorf("got %v, %v without an access log", accessLog, err)
+	}
+	path := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := (Logging{AccessLog: path, AccessLogFormat: server.LogCommon}).OpenAccessLog()
+	if err != nil {
+		t.Fatalf("failed to open access log: %v", err)
+	}
+	accessLog.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("access log file was not created: %v", err)
+	}
+	if _, err := (Logging{AccessLog: path, AccessLogFormat: "fancy"}).OpenAccessLog(); err == nil {
+		t.Error("unknown format was accepted")
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// Reloader replaces the running configuration with a freshly loaded one.
+// A configuration that fails to load, validate or apply is rejected and the
+// running one is kept.
+type Reloader struct {
+	mu      sync.Mutex
+	current *Config
+	load    func() (*Config, error)
+	apply   func(old, next *Config) error
+}
+
+// NewReloader creates a reloader for the running configuration current. Load
+// reads the new configuration and apply switches the process over to it.
+func NewReloader(current *Config, load func() (*Config, error), apply func(old, next *Config) error) *Reloader {
+	return &Reloader{current: current, load: load, apply: apply}
+}
+
+// Current returns the running configuration.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads, validates and applies a new configuration. Changes to
+// settings that require a restart are logged and keep their running values.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err == nil {
+		err = next.Validate()
+	}
+	var restart []string
+	if err == nil {
+		restart = r.current.RestartRequired(next)
+		next.keepRestartSettings(r.current)
+		err = r.apply(r.current, next)
+	}
+	if err != nil {
+		slog.Error("Rejected configuration, keeping the running one", "err", err)
+		return err
+	}
+
+	if len(restart) > 0 {
+		slog.Warn("Configuration changes take effect after a restart", "keys", restart)
+	}
+	r.current = next
+	slog.Info("Reloaded configuration")
+	return nil
+}
+
+// Handler reloads the configuration on POST, answering 204 on success and
+// 422 with the errors if the configuration was rejected.
+func (r *Reloader) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
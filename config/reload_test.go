@@ -0,0 +1,49 @@
+package config
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReloader(t *testing.T) {
+	running := Default()
+	next := Default()
+	var applied *Config
+	load := func() (*Config, error) { return next, nil }
+	apply := func(old, cfg *Config) error {
+		if cfg.Listen.Port == 1 {
+			return errors.New("port 1 is in use")
+		}
+		applied = cfg
+		return nil
+	}
+	r := NewReloader(running, load, apply)
+
+	next.Listen.Port = 0
+	next.Limits.MaxConnections = 5
+	if err := r.Reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if r.Current() != next || applied != next {
+		t.Fatal("valid configuration was not applied")
+	}
+	if next.Listen.Port != 0 || next.Limits.MaxConnections != 10 {
+		t.Errorf("got port %d and max connections %d, want the reloaded port and the running limit", next.Listen.Port, next.Limits.MaxConnections)
+	}
+
+	next = Default()
+	next.Listen.Port = -1
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity || r.Current() == next {
+		t.Errorf("invalid configuration got status %d", rec.Code)
+	}
+
+	next = Default()
+	next.Listen.Port = 1
+	if err := r.Reload(); err == nil || r.Current() == next {
+		t.Error("configuration that failed to apply replaced the running one")
+	}
+}
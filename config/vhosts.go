@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// VHosts configures name-based virtual hosting.
+type VHosts struct {
+	Hosts         []VirtualHost `json:"hosts" toml:"hosts" env:"VHOSTS" help:"virtual hosts with their own roots, JSON in the environment"`
+	Default       string        `json:"default" toml:"default" env:"VHOST_DEFAULT" help:"name of the virtual host serving unknown hosts"`
+	UnknownStatus int           `json:"unknown_status" toml:"unknown_status" env:"VHOST_UNKNOWN_STATUS" help:"status for unknown hosts without a default: 421 or 404"`
+}
+
+// VirtualHost configures a virtual host. Settings left unset fall back to
+// the server's.
+type VirtualHost struct {
+	// Names are the host names served, "*.example.com" matching subdomains.
+	Names []string `json:"names" toml:"names"`
+	// Root is the directory the host's files are served from and stored in.
+	Root string `json:"root" toml:"root"`
+	// Index is the file served for requests to a directory.
+	Index string `json:"index,omitempty" toml:"index"`
+	// Listing lists the files of directories without an index.
+	Listing bool `json:"listing,omitempty" toml:"listing"`
+	// MIMETypes are content types added to the server's.
+	MIMETypes map[string]string `json:"mime_types,omitempty" toml:"mime_types"`
+	// Auth replaces the server's authentication. Its realm defaults to the
+	// server's.
+	Auth *Auth `json:"auth,omitempty" toml:"auth"`
+	// AccessLog is the host's access log, written in the server's format.
+	AccessLog string `json:"access_log,omitempty" toml:"access_log"`
+}
+
+// validateVHosts checks the virtual hosts.
+func (c *Config) validateVHosts(check func(string, error)) {
+	names := make(map[string]bool)
+	for i, host := range c.VHosts.Hosts {
+		key := fmt.Sprintf("vhosts.hosts[%d]", i)
+		if len(host.Names) == 0 {
+			check(key, errors.New("names must be set"))
+		}
+		for _, name := range host.Names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if name == "" || strings.ContainsAny(name, ":/ ") || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+				check(key, fmt.Errorf("invalid name %q", name))
+			}
+			if names[name] {
+				check(key, fmt.Errorf("duplicate name %q", name))
+			}
+			names[name] = true
+		}
+		if host.Root == "" {
+			check(key, errors.New("root must be set"))
+		}
+		if strings.ContainsRune(host.Index, '/') {
+			check(key, fmt.Errorf("index %q must be a file name", host.Index))
+		}
+		for _, err := range checkMIMETypes(host.MIMETypes) {
+			check(key, err)
+		}
+		if host.Auth != nil {
+			check(key, checkFile(host.Auth.Users))
+			check(key, checkFile(host.Auth.Digest))
+			check(key, checkFile(host.Auth.TokenKey))
+			if host.Auth.Users == "" && host.Auth.TokenKey == "" {
+				check(key, errors.New("auth requires users or token_key"))
+			}
+		}
+	}
+
+	if d := strings.ToLower(c.VHosts.Default); d != "" && !names[d] {
+		check("vhosts.default", fmt.Errorf("%q is not the name of a virtual host", c.VHosts.Default))
+	}
+	if c.VHosts.UnknownStatus != 421 && c.VHosts.UnknownStatus != 404 {
+		check("vhosts.unknown_status", fmt.Errorf("must be 421 or 404, got %d", c.VHosts.UnknownStatus))
+	}
+}
@@ -2,4 +2,7 @@ module lab1
 
 go 1.21.0
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/BurntSushi/toml v1.3.2
+	golang.org/x/crypto v0.17.0
+)
@@ -0,0 +1,576 @@
+// Package parser parses HTTP/1.x messages incrementally.
+//
+// In strict mode, the default, messages that could be framed differently by
+// another implementation are rejected: bare LF line endings, obsolete line
+// folding, conflicting Content-Length and Transfer-Encoding headers and
+// Transfer-Encoding in HTTP/1.0 requests. Lenient mode accepts them the way
+// RFC 9112 allows recipients to, for legacy clients.
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// DefaultMaxHeaderBytes is the default limit on the size of a message head.
+const DefaultMaxHeaderBytes = 1 << 20
+
+// Mode selects how strictly messages are parsed.
+type Mode int
+
+const (
+	// Strict rejects ambiguous and obsolete syntax.
+	Strict Mode = iota
+	// Lenient accepts the obsolete syntax of legacy clients.
+	Lenient
+)
+
+// ParseMode parses "strict" or "lenient".
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "strict", "":
+		return Strict, nil
+	case "lenient":
+		return Lenient, nil
+	}
+	return Strict, fmt.Errorf("invalid parsing mode %q, expected strict or lenient", s)
+}
+
+func (m Mode) String() string {
+	if m == Lenient {
+		return "lenient"
+	}
+	return "strict"
+}
+
+// Options configure parsing.
+type Options struct {
+	// Mode is Strict or Lenient.
+	Mode Mode
+	// MaxHeaderBytes limits the size of a message head. Defaults to
+	// DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+}
+
+// Kind is the kind of message head a Parser parses.
+type Kind int
+
+const (
+	// Request heads start with a request line.
+	Request Kind = iota
+	// Response heads start with a status line.
+	Response
+	// Trailer sections only hold fields.
+	Trailer
+)
+
+// Error is a malformed message. Reason is the precise cause, for logs, and
+// Status the response status a malformed request calls for.
+type Error struct {
+	Status int
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "malformed HTTP message: " + e.Reason
+}
+
+func errorf(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Field is a header field. Its name and value point into the parser's
+// buffer.
+type Field struct {
+	Name, Value []byte
+}
+
+// Head is a parsed message head. Its byte slices point into the parser's
+// buffer and are only valid until the parser is reset.
+type Head struct {
+	// Method and Target are the parts of a request line.
+	Method, Target []byte
+	// StatusCode and Reason are the parts of a status line.
+	StatusCode int
+	Reason     []byte
+	// Version is the HTTP version, e.g. "HTTP/1.1", with its numbers in
+	// Major and Minor.
+	Version      []byte
+	Major, Minor int
+	// Fields are the header fields in the order received.
+	Fields []Field
+	// ContentLength is the length of the body given by Content-Length, -1
+	// if it is not given.
+	ContentLength int64
+	// Chunked reports whether the body is sent with the chunked transfer
+	// coding.
+	Chunked bool
+}
+
+// Get returns the value of the first field named name, nil if there is none.
+func (h *Head) Get(name string) []byte {
+	for _, f := range h.Fields {
+		if equalFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// span is a range of the parser's buffer. Offsets are used while parsing
+// because the buffer may move as it grows.
+type span struct{ start, end int }
+
+func (s span) in(buf []byte) []byte { return buf[s.start:s.end:s.end] }
+
+// Parser parses a message head fed to it in pieces as they arrive. Its
+// buffers are reused after Reset, so parsing does not allocate once they
+// have grown to fit the messages.
+type Parser struct {
+	opts Options
+	kind Kind
+
+	buf    []byte
+	line   int // start of the current line in buf
+	state  int
+	fields []struct{ name, value span }
+	start  [3]span // method, target, version or version, status, reason
+	head   Head
+}
+
+// Parser states.
+const (
+	stateStart = iota
+	stateFields
+	stateDone
+)
+
+// NewParser returns a parser for heads of kind.
+func NewParser(kind Kind, opts Options) *Parser {
+	p := &Parser{}
+	p.Reset(kind, opts)
+	return p
+}
+
+// Reset prepares the parser for the next head, invalidating the last one.
+func (p *Parser) Reset(kind Kind, opts Options) {
+	if opts.MaxHeaderBytes <= 0 {
+		opts.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	p.opts, p.kind = opts, kind
+	p.buf, p.line, p.fields = p.buf[:0], 0, p.fields[:0]
+	p.state = stateStart
+	if kind == Trailer {
+		p.state = stateFields
+	}
+	p.head = Head{Fields: p.head.Fields[:0], ContentLength: -1}
+}
+
+// Done reports whether the head is complete.
+func (p *Parser) Done() bool {
+	return p.state == stateDone
+}
+
+// Head returns the parsed head, once Done.
+func (p *Parser) Head() *Head {
+	return &p.head
+}
+
+// Feed parses data, returning how many bytes of it belong to the head. Once
+// the head is complete, the bytes after it, the body, are not consumed.
+func (p *Parser) Feed(data []byte) (int, error) {
+	n := 0
+	for n < len(data) && p.state != stateDone {
+		end := len(data)
+		if i := bytes.IndexByte(data[n:], '\n'); i >= 0 {
+			end = n + i + 1
+		}
+		if len(p.buf)+end-n > p.opts.MaxHeaderBytes {
+			if p.state == stateStart && p.kind == Request {
+				return n, errorf(http.StatusRequestURITooLong, "request line longer than %d bytes", p.opts.MaxHeaderBytes)
+			}
+			return n, errorf(http.StatusRequestHeaderFieldsTooLarge, "head longer than %d bytes", p.opts.MaxHeaderBytes)
+		}
+		p.buf = append(p.buf, data[n:end]...)
+		n = end
+		if p.buf[len(p.buf)-1] != '\n' {
+			break
+		}
+		if err := p.parseLine(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// parseLine parses the complete line at the end of the buffer.
+func (p *Parser) parseLine() error {
+	start, end := p.line, len(p.buf)-1
+	p.line = len(p.buf)
+	if end > start && p.buf[end-1] == '\r' {
+		end--
+	} else if p.opts.Mode == Strict {
+		return errorf(http.StatusBadRequest, "bare LF line ending")
+	}
+	if i := bytes.IndexByte(p.buf[start:end], '\r'); i >= 0 {
+		return errorf(http.StatusBadRequest, "bare CR in line")
+	}
+
+	switch p.state {
+	case stateStart:
+		if start == end {
+			// Empty lines before the start line are ignored, RFC 9112
+			// section 2.2.
+			return nil
+		}
+		p.state = stateFields
+		if p.kind == Response {
+			return p.parseStatusLine(start, end)
+		}
+		return p.parseRequestLine(start, end)
+	case stateFields:
+		if start == end {
+			return p.finish()
+		}
+		if isSpace(p.buf[start]) {
+			return p.parseFold(start, end)
+		}
+		return p.parseField(start, end)
+	}
+	return nil
+}
+
+// parseRequestLine parses "method SP target SP version". Lenient parsing
+// accepts runs of spaces and tabs around the parts.
+func (p *Parser) parseRequestLine(start, end int) error {
+	i := p.skipSpace(start, end)
+	var sep [3]bool
+	for part := range p.start {
+		p.start[part], i, sep[part] = p.cut(i, end)
+		if p.start[part].start == p.start[part].end {
+			return errorf(http.StatusBadRequest, "request line %q lacks a method, target or version", p.buf[start:end])
+		}
+	}
+	if i < end || sep[2] && p.opts.Mode == Strict {
+		return errorf(http.StatusBadRequest, "request line %q has too many parts", p.buf[start:end])
+	}
+	method, target := p.start[0].in(p.buf), p.start[1].in(p.buf)
+	if !isToken(method) {
+		return errorf(http.StatusBadRequest, "invalid method %q", method)
+	}
+	for _, c := range target {
+		if c <= ' ' || c == 0x7f || c >= 0x80 && p.opts.Mode == Strict {
+			return errorf(http.StatusBadRequest, "invalid character %q in request target", c)
+		}
+	}
+	return p.parseVersion(p.start[2].in(p.buf))
+}
+
+// parseStatusLine parses "version SP status SP [reason]". Lenient parsing
+// accepts runs of spaces and tabs between the parts and a missing space
+// after the status.
+func (p *Parser) parseStatusLine(start, end int) error {
+	var sep bool
+	i := p.skipSpace(start, end)
+	p.start[0], i, _ = p.cut(i, end)
+	p.start[1], i, sep = p.cut(i, end)
+	p.start[2] = span{i, end}
+	if !sep && p.opts.Mode == Strict {
+		return errorf(http.StatusBadGateway, "status line %q lacks the space after the status", p.buf[start:end])
+	}
+	if err := p.parseVersion(p.start[0].in(p.buf)); err != nil {
+		return err
+	}
+	status := p.start[1].in(p.buf)
+	if len(status) != 3 || !isDigit(status[0]) || !isDigit(status[1]) || !isDigit(status[2]) {
+		return errorf(http.StatusBadGateway, "invalid status code %q", status)
+	}
+	p.head.StatusCode = int(status[0]-'0')*100 + int(status[1]-'0')*10 + int(status[2]-'0')
+	for _, c := range p.start[2].in(p.buf) {
+		if isCTL(c) && c != '\t' {
+			return errorf(http.StatusBadGateway, "invalid character %q in reason phrase", c)
+		}
+	}
+	return nil
+}
+
+// cut returns the start line part at i, up to a space or the end, and where
+// the next part starts. Lenient parsing also ends parts at tabs and skips
+// runs of them. sep reports whether the part was followed by a separator.
+func (p *Parser) cut(i, end int) (part span, next int, sep bool) {
+	j := i
+	for j < end && p.buf[j] != ' ' && (p.opts.Mode == Strict || p.buf[j] != '\t') {
+		j++
+	}
+	if j == end {
+		return span{i, j}, j, false
+	}
+	return span{i, j}, p.skipSpace(j+1, end), true
+}
+
+// skipSpace skips the spaces and tabs at i in lenient mode.
+func (p *Parser) skipSpace(i, end int) int {
+	for p.opts.Mode == Lenient && i < end && isSpace(p.buf[i]) {
+		i++
+	}
+	return i
+}
+
+// parseVersion parses "HTTP/" DIGIT "." DIGIT.
+func (p *Parser) parseVersion(v []byte) error {
+	if len(v) != 8 || string(v[:5]) != "HTTP/" || !isDigit(v[5]) || v[6] != '.' || !isDigit(v[7]) {
+		status := http.StatusBadRequest
+		if p.kind == Response {
+			status = http.StatusBadGateway
+		}
+		return errorf(status, "invalid HTTP version %q", v)
+	}
+	p.head.Major, p.head.Minor = int(v[5]-'0'), int(v[7]-'0')
+	return nil
+}
+
+// parseField parses "name: value".
+func (p *Parser) parseField(start, end int) error {
+	colon := bytes.IndexByte(p.buf[start:end], ':')
+	if colon < 0 {
+		return errorf(http.StatusBadRequest, "header line %q has no colon", p.buf[start:end])
+	}
+	name := p.buf[start : start+colon]
+	if len(name) == 0 {
+		return errorf(http.StatusBadRequest, "empty header name")
+	}
+	if isSpace(name[len(name)-1]) {
+		// RFC 9112 section 5.1 requires rejecting these in any mode.
+		return errorf(http.StatusBadRequest, "whitespace between header name %q and colon", bytes.TrimRight(name, " \t"))
+	}
+	if !isToken(name) {
+		return errorf(http.StatusBadRequest, "invalid header name %q", name)
+	}
+	value, err := p.trimValue(start+colon+1, end)
+	if err != nil {
+		return err
+	}
+	p.fields = append(p.fields, struct{ name, value span }{span{start, start + colon}, value})
+	return nil
+}
+
+// parseFold appends an obsolete folded line to the previous field value,
+// replacing the fold with spaces. Only lenient parsing accepts folding.
+func (p *Parser) parseFold(start, end int) error {
+	if p.opts.Mode == Strict {
+		return errorf(http.StatusBadRequest, "obsolete line folding")
+	}
+	if len(p.fields) == 0 {
+		return errorf(http.StatusBadRequest, "whitespace before the first header line")
+	}
+	value, err := p.trimValue(start, end)
+	if err != nil {
+		return err
+	}
+	last := &p.fields[len(p.fields)-1]
+	if value.start == value.end {
+		return nil
+	}
+	if last.value.start == last.value.end {
+		last.value = value
+		return nil
+	}
+	for i := last.value.end; i < value.start; i++ {
+		p.buf[i] = ' '
+	}
+	last.value.end = value.end
+	return nil
+}
+
+// trimValue returns the field value between start and end without the
+// surrounding whitespace, checking its characters. Strict parsing rejects
+// all control characters but tab, lenient parsing only NUL.
+func (p *Parser) trimValue(start, end int) (span, error) {
+	for start < end && isSpace(p.buf[start]) {
+		start++
+	}
+	for end > start && isSpace(p.buf[end-1]) {
+		end--
+	}
+	for _, c := range p.buf[start:end] {
+		if c == 0 || isCTL(c) && c != '\t' && p.opts.Mode == Strict {
+			return span{}, errorf(http.StatusBadRequest, "invalid character %q in header value", c)
+		}
+	}
+	return span{start, end}, nil
+}
+
+// finish completes the head and determines how its body is framed.
+func (p *Parser) finish() error {
+	p.state = stateDone
+	h := &p.head
+	if p.kind == Request || p.kind == Response {
+		h.Method, h.Target, h.Version = nil, nil, nil
+		if p.kind == Request {
+			h.Method, h.Target, h.Version = p.start[0].in(p.buf), p.start[1].in(p.buf), p.start[2].in(p.buf)
+		} else {
+			h.Version, h.Reason = p.start[0].in(p.buf), p.start[2].in(p.buf)
+		}
+	}
+	for _, f := range p.fields {
+		h.Fields = append(h.Fields, Field{Name: f.name.in(p.buf), Value: f.value.in(p.buf)})
+	}
+	if p.kind == Trailer {
+		return nil
+	}
+	return p.frame()
+}
+
+// frame determines the body framing from Content-Length and
+// Transfer-Encoding, RFC 9112 section 6.
+func (p *Parser) frame() error {
+	h := &p.head
+	status := http.StatusBadRequest
+	if p.kind == Response {
+		status = http.StatusBadGateway
+	}
+	var hosts, lengths int
+	var transferCodings int
+	for _, f := range h.Fields {
+		switch {
+		case equalFold(f.Name, "Host"):
+			hosts++
+		case equalFold(f.Name, "Content-Length"):
+			lengths++
+			if lengths > 1 && p.opts.Mode == Strict {
+				return errorf(status, "multiple Content-Length headers")
+			}
+			if err := p.parseContentLength(f.Value, status); err != nil {
+				return err
+			}
+		case equalFold(f.Name, "Transfer-Encoding"):
+			for list := f.Value; len(list) > 0; {
+				var coding []byte
+				if coding, list = cutItem(list); len(coding) == 0 {
+					continue
+				}
+				if h.Chunked {
+					return errorf(status, "chunked is not the final transfer coding")
+				}
+				transferCodings++
+				if equalFold(coding, "chunked") {
+					h.Chunked = true
+				} else if p.kind == Request {
+					return errorf(http.StatusNotImplemented, "unsupported transfer coding %q", coding)
+				}
+			}
+		}
+	}
+
+	if p.kind == Request && hosts > 1 {
+		return errorf(http.StatusBadRequest, "multiple Host headers")
+	}
+	if transferCodings == 0 {
+		return nil
+	}
+	if p.kind == Request && !h.Chunked {
+		return errorf(http.StatusBadRequest, "chunked is not the final transfer coding")
+	}
+	if h.Major == 1 && h.Minor == 0 && p.opts.Mode == Strict {
+		return errorf(status, "Transfer-Encoding in an HTTP/1.0 message")
+	}
+	if lengths > 0 {
+		if p.opts.Mode == Strict {
+			return errorf(status, "both Content-Length and Transfer-Encoding")
+		}
+		// Transfer-Encoding overrides Content-Length, RFC 9112 section 6.3.
+	}
+	h.ContentLength = -1
+	return nil
+}
+
+// parseContentLength parses a Content-Length value. Lenient parsing accepts
+// a list of identical lengths.
+func (p *Parser) parseContentLength(value []byte, status int) error {
+	h := &p.head
+	for list := value; len(list) > 0; {
+		var item []byte
+		item, list = cutItem(list)
+		if len(item) == 0 || len(item) > 18 {
+			return errorf(status, "invalid Content-Length %q", value)
+		}
+		n := int64(0)
+		for _, c := range item {
+			if !isDigit(c) {
+				return errorf(status, "invalid Content-Length %q", value)
+			}
+			n = n*10 + int64(c-'0')
+		}
+		if h.ContentLength >= 0 && n != h.ContentLength {
+			return errorf(status, "conflicting Content-Length values %d and %d", h.ContentLength, n)
+		}
+		if h.ContentLength >= 0 && p.opts.Mode == Strict {
+			return errorf(status, "Content-Length list %q", value)
+		}
+		h.ContentLength = n
+	}
+	return nil
+}
+
+// cutItem returns the first item of a comma separated list, without the
+// surrounding whitespace, and the rest of the list.
+func cutItem(list []byte) (item, rest []byte) {
+	item = list
+	if i := bytes.IndexByte(list, ','); i >= 0 {
+		item, rest = list[:i], list[i+1:]
+	}
+	return bytes.Trim(item, " \t"), rest
+}
+
+// equalFold reports whether b and s are equal, ignoring ASCII case.
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := range b {
+		if lower(b[i]) != lower(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' }
+
+func isCTL(c byte) bool { return c < ' ' || c == 0x7f }
+
+// isToken reports whether b is a token, RFC 9110 section 5.6.2.
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c >= 0x80 || !tokenChars[c] {
+			return false
+		}
+	}
+	return true
+}
+
+var tokenChars = func() (t [128]bool) {
+	for c := '0'; c <= '9'; c++ {
+		t[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		t[c], t[c-'a'+'A'] = true, true
+	}
+	for _, c := range "!#$%&'*+-.^_`|~" {
+		t[c] = true
+	}
+	return t
+}()
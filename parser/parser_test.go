@@ -0,0 +1,284 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name, raw string
+		mode      Mode
+		status    int    // of the error, 0 if the request is valid
+		reason    string // part of the error reason
+		body      string
+	}{
+		{"simple", "GET /a.txt HTTP/1.0\r\n\r\n", Strict, 0, "", ""},
+		{"leading empty line", "\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n", Strict, 0, "", ""},
+		{"content length", "POST /a HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello", Strict, 0, "", "hello"},
+		{"chunked", "POST /a HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n1\r\n!\r\n0\r\n\r\n", Strict, 0, "", "hello!"},
+		{"bare LF", "GET / HTTP/1.1\nHost: a\n\n", Strict, 400, "bare LF", ""},
+		{"bare LF lenient", "GET / HTTP/1.1\nHost: a\n\n", Lenient, 0, "", ""},
+		{"bare CR", "GET / HTTP/1.1\r\nHost: a\rb\r\n\r\n", Lenient, 400, "bare CR", ""},
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: a\r\nX-A: 1\r\n 2\r\n\r\n", Strict, 400, "obsolete line folding", ""},
+		{"obs-fold lenient", "GET / HTTP/1.1\r\nHost: a\r\nX-A: 1\r\n 2\r\n\r\n", Lenient, 0, "", ""},
+		{"space before colon", "GET / HTTP/1.1\r\nHost : a\r\n\r\n", Lenient, 400, "whitespace between header name", ""},
+		{"CL and TE", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", Strict, 400, "both Content-Length and Transfer-Encoding", ""},
+		{"CL and TE lenient", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n", Lenient, 0, "", "hi"},
+		{"conflicting CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3, 4\r\n\r\nabcd", Lenient, 400, "conflicting Content-Length", ""},
+		{"duplicate CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc", Strict, 400, "multiple Content-Length", ""},
+		{"duplicate CL lenient", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc", Lenient, 0, "", "abc"},
+		{"signed CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +3\r\n\r\nabc", Lenient, 400, "invalid Content-Length", ""},
+		{"TE in HTTP/1.0", "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", Strict, 400, "HTTP/1.0", ""},
+		{"TE not ending in chunked", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n", Strict, 400, "final transfer coding", ""},
+		{"unsupported TE", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", Strict, 501, "unsupported transfer coding", ""},
+		{"multiple Host", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", Lenient, 400, "multiple Host", ""},
+		{"double space", "GET  / HTTP/1.1\r\nHost: a\r\n\r\n", Strict, 400, "lacks a method, target or version", ""},
+		{"double space lenient", "GET  /\tHTTP/1.1\r\nHost: a\r\n\r\n", Lenient, 0, "", ""},
+		{"HTTP/0.9", "GET /\r\n\r\n", Lenient, 400, "lacks a method, target or version", ""},
+		{"invalid version", "GET / HTTP/1.10\r\n\r\n", Strict, 400, "invalid HTTP version", ""},
+		{"invalid method", "G(T / HTTP/1.1\r\n\r\n", Strict, 400, "invalid method", ""},
+		{"control in value", "GET / HTTP/1.1\r\nHost: a\r\nX-A: a\x01b\r\n\r\n", Strict, 400, "invalid character", ""},
+		{"invalid chunk size", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n", Strict, 400, "invalid chunk size", ""},
+		{"chunk longer than size", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhello\r\n0\r\n\r\n", Strict, 400, "chunk data longer", ""},
+		{"bare LF in chunk", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n2\nhi\r\n0\r\n\r\n", Strict, 400, "bare LF", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := ReadRequest(bufio.NewReader(strings.NewReader(test.raw)), Options{Mode: test.mode})
+			var body []byte
+			if err == nil {
+				body, err = io.ReadAll(req.Body)
+			}
+			var perr *Error
+			switch {
+			case test.status == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.status != 0 && !errors.As(err, &perr):
+				t.Fatalf("got %v, want a %d error", err, test.status)
+			case test.status != 0 && (perr.Status != test.status || !strings.Contains(perr.Reason, test.reason)):
+				t.Fatalf("got %d %q, want %d %q", perr.Status, perr.Reason, test.status, test.reason)
+			case test.status == 0 && string(body) != test.body:
+				t.Fatalf("got body %q, want %q", body, test.body)
+			}
+		})
+	}
+}
+
+func TestReadRequestFields(t *testing.T) {
+	raw := "POST http://example.com/a?b=c HTTP/1.1\r\nhost: ignored\r\nx-list: 1\r\nX-List: 2\r\nX-Fold: a\r\n\t b\r\nTrailer: X-Sum\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\nX-Sum: 6\r\nX-Other: 1\r\n\r\n"
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader(raw)), Options{Mode: Lenient})
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+	if req.Host != "example.com" || req.URL.Path != "/a" || req.URL.RawQuery != "b=c" || req.RequestURI != "http://example.com/a?b=c" {
+		t.Errorf("got host %q, URL %v, request URI %q", req.Host, req.URL, req.RequestURI)
+	}
+	want := http.Header{"X-List": {"1", "2"}, "X-Fold": {"a    b"}}
+	if !reflect.DeepEqual(req.Header, want) {
+		t.Errorf("got header %v, want %v", req.Header, want)
+	}
+	if req.ContentLength != -1 || !reflect.DeepEqual(req.TransferEncoding, []string{"chunked"}) || req.Close {
+		t.Errorf("got length %d, encoding %v, close %v", req.ContentLength, req.TransferEncoding, req.Close)
+	}
+	io.ReadAll(req.Body)
+	if !reflect.DeepEqual(req.Trailer, http.Header{"X-Sum": {"6"}}) {
+		t.Errorf("got trailer %v", req.Trailer)
+	}
+}
+
+func TestReadRequestLimits(t *testing.T) {
+	opts := Options{MaxHeaderBytes: 64}
+	var perr *Error
+	_, err := ReadRequest(bufio.NewReader(strings.NewReader("GET /"+strings.Repeat("a", 64)+" HTTP/1.1\r\n\r\n")), opts)
+	if !errors.As(err, &perr) || perr.Status != http.StatusRequestURITooLong {
+		t.Errorf("got %v, want 414 for a long request line", err)
+	}
+	_, err = ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nX-A: "+strings.Repeat("a", 64)+"\r\n\r\n")), opts)
+	if !errors.As(err, &perr) || perr.Status != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("got %v, want 431 for a long head", err)
+	}
+	if _, err := ReadRequest(bufio.NewReader(strings.NewReader("")), opts); err != io.EOF {
+		t.Errorf("got %v, want io.EOF for no request", err)
+	}
+	if _, err := ReadRequest(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n")), opts); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF for a truncated head", err)
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	tests := []struct {
+		name, raw, method string
+		mode              Mode
+		status            string
+		body              string
+		close             bool
+	}{
+		{"content length", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi", "GET", Strict, "200 OK", "hi", false},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n", "GET", Strict, "200 OK", "hi", false},
+		{"until close", "HTTP/1.0 404 Not Found\r\n\r\ngone", "GET", Strict, "404 Not Found", "gone", true},
+		{"HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n", "HEAD", Strict, "200 OK", "", false},
+		{"no content", "HTTP/1.1 204 No Content\r\n\r\n", "GET", Strict, "204 No Content", "", false},
+		{"empty reason", "HTTP/1.1 200 \r\nContent-Length: 0\r\n\r\n", "GET", Strict, "200", "", false},
+		{"missing space lenient", "HTTP/1.1 200\r\nContent-Length: 0\r\n\r\n", "GET", Lenient, "200", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ReadResponse(bufio.NewReader(strings.NewReader(test.raw)), &http.Request{Method: test.method}, Options{Mode: test.mode})
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			body, err := io.ReadAll(res.Body)
+			if err != nil || res.Status != test.status || string(body) != test.body || res.Close != test.close {
+				t.Errorf("got %q, body %q, close %v, %v", res.Status, body, res.Close, err)
+			}
+		})
+	}
+
+	_, err := ReadResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 200\r\n\r\n")), nil, Options{})
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Status != http.StatusBadGateway {
+		t.Errorf("got %v, want 502 for a status line without the space after the status", err)
+	}
+}
+
+func TestFeedIncrementally(t *testing.T) {
+	raw := []byte("GET /a HTTP/1.1\r\nHost: a\r\nX-A:  b \r\n\r\nbody")
+	whole := NewParser(Request, Options{})
+	n, err := whole.Feed(raw)
+	if err != nil || n != len(raw)-len("body") || !whole.Done() {
+		t.Fatalf("got %d, %v, done %v", n, err, whole.Done())
+	}
+
+	split := NewParser(Request, Options{})
+	consumed := 0
+	for i := range raw {
+		if split.Done() {
+			break
+		}
+		n, err := split.Feed(raw[i : i+1])
+		if err != nil {
+			t.Fatalf("failed at byte %d: %v", i, err)
+		}
+		consumed += n
+	}
+	if consumed != len(raw)-len("body") || !reflect.DeepEqual(split.Head(), whole.Head()) {
+		t.Errorf("byte by byte parsed %+v, want %+v", split.Head(), whole.Head())
+	}
+	if got := string(split.Head().Get("x-a")); got != "b" {
+		t.Errorf("got X-A %q, want %q", got, "b")
+	}
+}
+
+func TestParserAllocations(t *testing.T) {
+	raw := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\nAccept: */*\r\nContent-Length: 0\r\n\r\n")
+	p := NewParser(Request, Options{})
+	p.Feed(raw)
+	allocs := testing.AllocsPerRun(100, func() {
+		p.Reset(Request, Options{})
+		if _, err := p.Feed(raw); err != nil || !p.Done() {
+			t.Fatalf("failed to parse: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per head, want 0", allocs)
+	}
+}
+
+func BenchmarkParser(b *testing.B) {
+	raw := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: bench\r\nAccept: */*\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+	p := NewParser(Request, Options{})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		p.Reset(Request, Options{})
+		p.Feed(raw)
+	}
+}
+
+var fuzzSeeds = []string{
+	"GET / HTTP/1.1\r\nHost: a\r\n\r\n",
+	"GET / HTTP/1.1\nHost: a\n\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;a=b\r\nhello\r\n0\r\nX: y\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: a\r\nX: 1\r\n 2\r\n\r\n",
+	"POST / HTTP/1.0\r\nContent-Length: 1, 1\r\n\r\na",
+	"GET http://a/b HTTP/1.1\r\n\r\n",
+	"CONNECT a:443 HTTP/1.1\r\nHost: a:443\r\n\r\n",
+}
+
+// FuzzReadRequest checks that any input is either rejected or parsed the
+// same whether it arrives at once or byte by byte, and that whatever strict
+// parsing accepts, lenient parsing accepts the same way.
+func FuzzReadRequest(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		strict, strictErr := readAll(bufio.NewReader(bytes.NewReader(raw)), Strict)
+		lenient, lenientErr := readAll(bufio.NewReader(bytes.NewReader(raw)), Lenient)
+		if strictErr == nil && (lenientErr != nil || !reflect.DeepEqual(strict, lenient)) {
+			t.Errorf("strict parsing accepted %q as %q, lenient parsing as %q, %v", raw, strict, lenient, lenientErr)
+		}
+		slow, slowErr := readAll(bufio.NewReaderSize(iotestOneByte{bytes.NewReader(raw)}, 16), Strict)
+		if (strictErr == nil) != (slowErr == nil) || !reflect.DeepEqual(strict, slow) {
+			t.Errorf("%q parsed as %q, %v at once, as %q, %v byte by byte", raw, strict, strictErr, slow, slowErr)
+		}
+	})
+}
+
+// FuzzFeed checks that the parser does not panic and consumes no more than
+// it is fed.
+func FuzzFeed(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed), uint8(0))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte, kind uint8) {
+		p := NewParser(Kind(kind%3), Options{Mode: Mode(kind / 3 % 2), MaxHeaderBytes: 512})
+		n, err := p.Feed(raw)
+		if n < 0 || n > len(raw) {
+			t.Fatalf("consumed %d of %d bytes", n, len(raw))
+		}
+		if err == nil && p.Done() {
+			for _, field := range p.Head().Fields {
+				if !isToken(field.Name) {
+					t.Errorf("accepted field name %q", field.Name)
+				}
+			}
+		}
+	})
+}
+
+// readAll reads a request and its body, describing them as a string.
+func readAll(r *bufio.Reader, mode Mode) (string, error) {
+	req, err := ReadRequest(r, Options{Mode: mode})
+	if err != nil {
+		return "", err
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	buf.WriteString(req.Method + " " + req.RequestURI + " " + req.Proto + " " + req.Host + "\n")
+	req.Header.Write(&buf)
+	buf.WriteString("\n")
+	buf.Write(body)
+	return buf.String(), nil
+}
+
+// iotestOneByte reads one byte at a time.
+type iotestOneByte struct{ r io.Reader }
+
+func (o iotestOneByte) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
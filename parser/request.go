@@ -0,0 +1,369 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parsers are reused between messages so that reading their heads does not
+// allocate buffers.
+var parsers = sync.Pool{New: func() any { return new(Parser) }}
+
+// ReadHead feeds p from r until its head is complete, leaving the body in r.
+// It returns io.EOF if r ends before the head starts and
+// io.ErrUnexpectedEOF if it ends within it.
+func ReadHead(r *bufio.Reader, p *Parser) error {
+	started := false
+	for !p.Done() {
+		if r.Buffered() == 0 {
+			if _, err := r.Peek(1); err != nil {
+				if err == io.EOF && started {
+					return io.ErrUnexpectedEOF
+				}
+				return err
+			}
+		}
+		data, _ := r.Peek(r.Buffered())
+		n, err := p.Feed(data)
+		r.Discard(n)
+		started = true
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRequest reads a request from r. Unlike http.ReadRequest it reports
+// malformed requests as an *Error and rejects ambiguous framing in strict
+// mode.
+func ReadRequest(r *bufio.Reader, opts Options) (*http.Request, error) {
+	p := parsers.Get().(*Parser)
+	defer parsers.Put(p)
+	p.Reset(Request, opts)
+	if err := ReadHead(r, p); err != nil {
+		return nil, err
+	}
+	h := p.Head()
+
+	req := &http.Request{
+		Method:     string(h.Method),
+		RequestURI: string(h.Target),
+		Proto:      string(h.Version),
+		ProtoMajor: h.Major,
+		ProtoMinor: h.Minor,
+		Header:     makeHeader(h),
+	}
+	var err error
+	if req.Method == http.MethodConnect && !strings.HasPrefix(req.RequestURI, "/") {
+		// The authority form of CONNECT targets.
+		if req.URL, err = url.ParseRequestURI("http://" + req.RequestURI); err == nil {
+			req.URL.Scheme = ""
+		}
+	} else {
+		req.URL, err = url.ParseRequestURI(req.RequestURI)
+	}
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid request target %q", req.RequestURI)
+	}
+	req.Host = req.URL.Host
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	delete(req.Header, "Host")
+	req.Close = shouldClose(h.Major, h.Minor, req.Header)
+
+	req.ContentLength, req.Body = h.ContentLength, http.NoBody
+	if h.Chunked {
+		req.TransferEncoding = []string{"chunked"}
+		delete(req.Header, "Transfer-Encoding")
+		delete(req.Header, "Content-Length")
+		req.Trailer = declaredTrailer(req.Header)
+		req.Body = &chunkedReader{r: r, opts: opts, trailer: req.Trailer}
+	} else if h.ContentLength > 0 {
+		req.Body = &lengthReader{r: r, n: h.ContentLength}
+	} else {
+		req.ContentLength = 0
+	}
+	return req, nil
+}
+
+// ReadResponse reads a response to req from r. A body delimited by the end of
+// the connection is read until r ends.
+func ReadResponse(r *bufio.Reader, req *http.Request, opts Options) (*http.Response, error) {
+	p := parsers.Get().(*Parser)
+	defer parsers.Put(p)
+	p.Reset(Response, opts)
+	if err := ReadHead(r, p); err != nil {
+		return nil, err
+	}
+	h := p.Head()
+
+	res := &http.Response{
+		Status:     strconv.Itoa(h.StatusCode) + " " + string(h.Reason),
+		StatusCode: h.StatusCode,
+		Proto:      string(h.Version),
+		ProtoMajor: h.Major,
+		ProtoMinor: h.Minor,
+		Header:     makeHeader(h),
+		Request:    req,
+	}
+	res.Status = strings.TrimSuffix(res.Status, " ")
+	res.Close = shouldClose(h.Major, h.Minor, res.Header)
+
+	res.ContentLength, res.Body = h.ContentLength, http.NoBody
+	switch {
+	case req != nil && req.Method == http.MethodHead, h.StatusCode < 200, h.StatusCode == http.StatusNoContent, h.StatusCode == http.StatusNotModified:
+		// The head is the whole response, RFC 9112 section 6.3.
+	case h.Chunked:
+		res.TransferEncoding = []string{"chunked"}
+		delete(res.Header, "Transfer-Encoding")
+		delete(res.Header, "Content-Length")
+		res.Trailer = declaredTrailer(res.Header)
+		res.Body = &chunkedReader{r: r, opts: opts, trailer: res.Trailer}
+	case h.ContentLength >= 0:
+		if h.ContentLength > 0 {
+			res.Body = &lengthReader{r: r, n: h.ContentLength}
+		}
+	default:
+		res.Close = true
+		res.Body = io.NopCloser(r)
+	}
+	return res, nil
+}
+
+// makeHeader copies the fields of h into a header with canonical keys.
+func makeHeader(h *Head) http.Header {
+	header := make(http.Header, len(h.Fields))
+	for _, f := range h.Fields {
+		key := textproto.CanonicalMIMEHeaderKey(string(f.Name))
+		header[key] = append(header[key], string(f.Value))
+	}
+	return header
+}
+
+// declaredTrailer returns the trailer announced in the Trailer header, nil
+// if there is none.
+func declaredTrailer(header http.Header) http.Header {
+	var trailer http.Header
+	for _, value := range header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if trailer == nil {
+					trailer = make(http.Header)
+				}
+				trailer[textproto.CanonicalMIMEHeaderKey(name)] = nil
+			}
+		}
+	}
+	delete(header, "Trailer")
+	return trailer
+}
+
+// shouldClose reports whether the connection ends after a message of the
+// given version and header, RFC 9112 section 9.3.
+func shouldClose(major, minor int, header http.Header) bool {
+	for _, value := range header.Values("Connection") {
+		for _, option := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(option)) {
+			case "close":
+				return true
+			case "keep-alive":
+				if major == 1 && minor == 0 {
+					return false
+				}
+			}
+		}
+	}
+	return major < 1 || major == 1 && minor == 0
+}
+
+// lengthReader reads a body of n bytes.
+type lengthReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (l *lengthReader) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > l.n {
+		b = b[:l.n]
+	}
+	n, err := l.r.Read(b)
+	l.n -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (l *lengthReader) Close() error { return nil }
+
+// maxChunkLine limits the chunk size lines, including their extensions.
+const maxChunkLine = 4096
+
+// chunkedReader decodes a body sent with the chunked transfer coding, RFC
+// 9112 section 7.1. Trailer fields that were declared are added to trailer.
+type chunkedReader struct {
+	r       *bufio.Reader
+	opts    Options
+	trailer http.Header
+	n       int64 // bytes left in the current chunk
+	started bool
+	err     error
+}
+
+func (c *chunkedReader) Read(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.n == 0 {
+		if c.started {
+			if c.err = c.readLineEnd(); c.err != nil {
+				return 0, c.err
+			}
+		}
+		c.started = true
+		if c.n, c.err = c.readSize(); c.err != nil {
+			return 0, c.err
+		}
+		if c.n == 0 {
+			c.err = c.readTrailer()
+			if c.err == nil {
+				c.err = io.EOF
+			}
+			return 0, c.err
+		}
+	}
+	if int64(len(b)) > c.n {
+		b = b[:c.n]
+	}
+	n, err := c.r.Read(b)
+	c.n -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+func (c *chunkedReader) Close() error { return nil }
+
+// readLine reads a line of the chunked coding without its line ending.
+func (c *chunkedReader) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxChunkLine {
+		return nil, errorf(http.StatusBadRequest, "chunk line longer than %d bytes", maxChunkLine)
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	} else if c.opts.Mode == Strict {
+		return nil, errorf(http.StatusBadRequest, "bare LF line ending in chunked body")
+	}
+	return line, nil
+}
+
+// readLineEnd reads the line ending after chunk data.
+func (c *chunkedReader) readLineEnd() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) > 0 {
+		return errorf(http.StatusBadRequest, "chunk data longer than its size")
+	}
+	return nil
+}
+
+// readSize reads a chunk size line: hexadecimal digits optionally followed
+// by extensions, which are ignored.
+func (c *chunkedReader) readSize() (int64, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+	size, ext := line, []byte(nil)
+	for i, ch := range line {
+		if ch == ';' || isSpace(ch) {
+			size, ext = line[:i], line[i:]
+			break
+		}
+	}
+	if len(size) == 0 || len(size) > 15 {
+		return 0, errorf(http.StatusBadRequest, "invalid chunk size %q", size)
+	}
+	n := int64(0)
+	for _, ch := range size {
+		d, ok := hexDigit(ch)
+		if !ok {
+			return 0, errorf(http.StatusBadRequest, "invalid chunk size %q", size)
+		}
+		n = n<<4 | int64(d)
+	}
+	// Whitespace may only precede an extension, RFC 9112 section 7.1.1.
+	ext = trimLeftSpace(ext)
+	if len(ext) > 0 && ext[0] != ';' {
+		return 0, errorf(http.StatusBadRequest, "invalid chunk extension %q", ext)
+	}
+	for _, ch := range ext {
+		if isCTL(ch) && ch != '\t' {
+			return 0, errorf(http.StatusBadRequest, "invalid character %q in chunk extension", ch)
+		}
+	}
+	return n, nil
+}
+
+// readTrailer reads the trailer section after the last chunk.
+func (c *chunkedReader) readTrailer() error {
+	p := parsers.Get().(*Parser)
+	defer parsers.Put(p)
+	p.Reset(Trailer, c.opts)
+	if err := ReadHead(c.r, p); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	for _, f := range p.Head().Fields {
+		key := textproto.CanonicalMIMEHeaderKey(string(f.Name))
+		if _, declared := c.trailer[key]; declared {
+			c.trailer[key] = append(c.trailer[key], string(f.Value))
+		}
+	}
+	return nil
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func trimLeftSpace(b []byte) []byte {
+	for len(b) > 0 && isSpace(b[0]) {
+		b = b[1:]
+	}
+	return b
+}
@@ -2,19 +2,49 @@ package proxy
 
 import (
 	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"lab1/parser"
 	"lab1/server"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Software is sent in the Server header of the proxy's own responses and
+// names the proxy in the Via header of forwarded ones.
+const Software = "http_proxy"
+
 // Proxy is a wrapper for a regular server with additional
-//   - restraints - only GET:s are allowed,
+//   - restraints - only GET:s and HEAD:s are allowed,
 //   - functionality - acts on behalf of the client by making the
 //     requests and passing back the response.
 type Proxy struct {
 	proxyServer *server.Server
+
+	// AccessLog, when set, receives an entry for every handled request.
+	AccessLog *server.AccessLog
+	// Logger receives the proxy's diagnostic output. Defaults to slog.Default().
+	Logger *slog.Logger
+	// ErrorPages, when set, are the custom documents sent with the proxy's
+	// own error responses. Error bodies are negotiated either way.
+	ErrorPages *server.ErrorPages
+	// Metrics, when set, records request, connection and upstream metrics.
+	Metrics *server.Metrics
+	// Tracer, when set, records a span for every request and a child span
+	// for every upstream call. Trace context is propagated either way.
+	Tracer *server.Tracer
+	// Conns, when set, tracks the open connections.
+	Conns *server.ConnTracker
+	// Parsing configures how strictly client requests are parsed.
+	Parsing parser.Options
+
+	mu *sync.RWMutex
 }
 
 // Creates a proxy on the given port, listening on any address.
@@ -27,16 +57,25 @@ func CreateProxy(port int) (*Proxy, error) {
 
 	return &Proxy{
 		proxyServer: server,
+		Logger:      slog.Default(),
+		mu:          &sync.RWMutex{},
 	}, nil
 }
 
 // Wrapper for server implementation.
 // See server/server.go.
-func (p *Proxy) Listen() error {
+func (p *Proxy) Listen(listeners ...*server.Listener) error {
+	p.proxyServer.Logger = p.logger()
+	if len(listeners) > 0 {
+		p.proxyServer.Listeners = listeners
+	}
 	err := p.proxyServer.Listen()
 	if err != nil {
 		return err
 	}
+	if p.Metrics != nil {
+		p.Metrics.TrackSemaphore(p.proxyServer.Sem)
+	}
 
 	return nil
 }
@@ -47,16 +86,24 @@ func (p *Proxy) Serve() error {
 	for {
 		select {
 		case <-p.proxyServer.Sem:
-			conn, err := p.proxyServer.Listener.Accept()
+			conn, err := p.proxyServer.Accept()
 			if err != nil {
-				log.Printf("Failed to accept connection: %v\n", err)
+				p.proxyServer.Sem <- true
+				if errors.Is(err, net.ErrClosed) {
+					return err
+				}
+				p.logger().Error("Failed to accept connection", "err", err)
 				continue
 			}
 
+			if p.Conns != nil {
+				conn = p.Conns.Track(conn)
+			}
+
 			go func() {
 				err := p.HandleConnection(conn)
 				if err != nil {
-					log.Printf("Error handling connection: %v", err)
+					p.logger().Debug("Error handling connection", "err", err)
 				}
 				p.proxyServer.Sem <- true
 			}()
@@ -67,67 +114,181 @@ func (p *Proxy) Serve() error {
 // Manages incoming HTTP requests from a proxy client and acts on their
 // behalf to communicate with the server.
 func (p *Proxy) HandleConnection(conn net.Conn) error {
+	p = p.snapshot()
 	defer conn.Close()
-	log.Printf("Handling connection via proxy from %s\n", conn.RemoteAddr().String())
+	remoteAddr := conn.RemoteAddr().String()
+	logger := p.logger().With("remote_addr", remoteAddr)
+	logger.Debug("Handling connection via proxy")
 
-	req, err := http.ReadRequest(bufio.NewReader(conn))
+	start := time.Now()
+	req, err := parser.ReadRequest(bufio.NewReader(conn), p.Parsing)
 	if err != nil {
-		if err != io.EOF {
-			log.Printf("Error reading request from %s: %v\n", req.RemoteAddr, err)
+		var perr *parser.Error
+		if errors.As(err, &perr) {
+			logger.Info("Rejected malformed request", "status", perr.Status, "reason", perr.Reason)
+			server.WriteReadError(conn, err, Software)
+		} else if err != io.EOF {
+			logger.Warn("Error reading request", "err", err)
+			server.WriteReadError(conn, err, Software)
 		} else {
-			log.Printf("Connection closed by client %s\n", req.RemoteAddr)
+			logger.Debug("Connection closed by client")
 		}
 		return err
 	}
+	req.RemoteAddr = remoteAddr
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = server.NewRequestID()
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	span := p.Tracer.Start(server.TraceContextFromHeader(req.Header), "proxy "+req.Method, server.SpanServer)
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.RequestURI)
+	span.SetAttr("request_id", requestID)
+	defer span.Finish()
 
-	// Only allow HTTP GET.
-	if req.Method != http.MethodGet {
-		p.SendNotImplemented(conn)
-		log.Printf("Received forbidden HTTP method from %s: %s\n", req.RemoteAddr, req.Method)
+	logger = logger.With("request_id", requestID, "trace_id", hex.EncodeToString(span.Context.TraceID[:]), "path", req.URL.Path)
+	req = server.WithSpan(server.WithLogger(req, logger), span)
+	server.SetConnState(conn, server.ConnHandling, req)
+
+	// Only allow HTTP GET, and HEAD which is GET without the body.
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		p.SendNotImplemented(conn, req)
+		span.SetAttr("http.status_code", http.StatusNotImplemented)
+		p.record(req, start, http.StatusNotImplemented, 0)
+		logger.Info("Received forbidden HTTP method", "method", req.Method)
 		return err
 	}
 
 	// Act on behalf of the client (proxy user).
 	res, err := p.SendRequestToServer(req)
 	if err != nil {
-		log.Printf("Error sending request to server: %v\n", err)
-		p.proxyServer.Sem <- true
+		logger.Warn("Error sending request to server", "err", err)
+		p.SendBadGateway(conn, req)
+		span.SetAttr("http.status_code", http.StatusBadGateway)
+		p.record(req, start, http.StatusBadGateway, 0)
 		return err
 	}
 
 	// Send back the response to the proxy user.
+	span.SetAttr("http.status_code", res.StatusCode)
+	removeHopHeaders(res.Header)
+	res.Header.Add("Via", fmt.Sprintf("%d.%d %s", res.ProtoMajor, res.ProtoMinor, Software))
+	server.FrameResponse(req, res, "")
+	body := &server.CountingReader{ReadCloser: res.Body}
+	res.Body = body
+	server.SetConnState(conn, server.ConnWriting, nil)
 	err = p.SendResponseToClient(conn, res)
 	if err != nil {
-		log.Printf("Error sending response to client: %v\n", err)
+		logger.Warn("Error sending response to client", "err", err)
 	}
+	logger.Debug("Sent response to client", "status", res.StatusCode)
+	p.record(req, start, res.StatusCode, body.N)
 	return nil
 }
 
-// Sends a HTTP GET request to the server and returns it and any
-// errors that occured.
+// record updates the metrics and writes an access log entry for req, if
+// enabled.
+func (p *Proxy) record(req *http.Request, start time.Time, status int, bytes int64) {
+	if p.Metrics != nil {
+		p.Metrics.ObserveRequest(req.Method, status, time.Since(start), 0, bytes)
+	}
+	if p.AccessLog == nil {
+		return
+	}
+	entry := server.NewAccessEntry(req, start)
+	entry.Status, entry.Bytes, entry.Duration = status, bytes, time.Since(start)
+	p.AccessLog.Log(entry)
+}
+
+// Sends a HTTP GET or HEAD request to the server and returns it and any
+// errors that occured. The request ID and trace context of req are passed
+// on, with the upstream call recorded as a child span.
 func (p *Proxy) SendRequestToServer(req *http.Request) (*http.Response, error) {
-	res, err := http.Get(req.RequestURI)
+	parent := server.TraceContextFromHeader(req.Header)
+	if span := server.SpanFromRequest(req); span != nil {
+		parent = span.Context
+	}
+	span := p.Tracer.Start(parent, "upstream "+req.Method, server.SpanClient)
+	span.SetAttr("http.url", req.RequestURI)
+	defer span.Finish()
+
+	upstream, err := http.NewRequest(req.Method, req.RequestURI, nil)
 	if err != nil {
-		log.Printf("Error sending GET request %s: %v\n", req.RequestURI, err)
 		return nil, err
 	}
+	upstream.Header.Set("X-Request-ID", req.Header.Get("X-Request-ID"))
+	span.Context.Inject(upstream.Header)
+
+	start := time.Now()
+	res, err := http.DefaultClient.Do(upstream)
+	if p.Metrics != nil {
+		if err != nil {
+			p.Metrics.UpstreamErrors.Inc()
+		} else {
+			p.Metrics.UpstreamLatency.ObserveDuration(time.Since(start))
+		}
+	}
+	if err != nil {
+		server.LoggerFromRequest(req).Debug("Error sending request", "method", req.Method, "uri", req.RequestURI, "err", err)
+		span.SetAttr("error", err.Error())
+		return nil, err
+	}
+	span.SetAttr("http.status_code", res.StatusCode)
 
 	return res, nil
 }
 
 // Sends a 501 - Not Implemented to the client.
-func (p *Proxy) SendNotImplemented(conn net.Conn) {
+func (p *Proxy) SendNotImplemented(conn net.Conn, req *http.Request) {
+	p.sendError(conn, req, "501 Not Implemented", http.StatusNotImplemented)
+}
+
+// Sends a 502 - Bad Gateway to the client.
+func (p *Proxy) SendBadGateway(conn net.Conn, req *http.Request) {
+	p.sendError(conn, req, "502 Bad Gateway", http.StatusBadGateway)
+}
+
+// sendError sends an error response with a body negotiated with req.
+func (p *Proxy) sendError(conn net.Conn, req *http.Request, status string, code int) {
 	res := &http.Response{
-		Status:     "501 Not Implemented",
-		StatusCode: 501,
+		Status:     status,
+		StatusCode: code,
 		Proto:      "HTTP/1.0",
 		ProtoMajor: 1,
 		ProtoMinor: 0,
+		Header:     make(http.Header),
+		Body:       server.CreateBody(status),
 	}
+	if code == http.StatusNotImplemented {
+		res.Header.Set("Allow", "GET, HEAD")
+	}
+	p.ErrorPages.Render(req, res)
+	res.Header.Set("X-Request-ID", req.Header.Get("X-Request-ID"))
+	server.FrameResponse(req, res, Software)
 
 	err := res.Write(conn)
 	if err != nil {
-		log.Printf("Error sending 501 to client: %v\n", err)
+		p.logger().Warn("Error sending error to client", "status", code, "err", err)
+	}
+}
+
+// hopHeaders are the hop-by-hop headers, which apply to a single connection
+// and are not forwarded, RFC 9110 section 7.6.1.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "TE", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// removeHopHeaders removes the hop-by-hop headers from h, including the ones
+// listed in its Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
 	}
 }
 
@@ -139,10 +300,93 @@ func (p *Proxy) SendResponseToClient(conn net.Conn, res *http.Response) error {
 		return err
 	}
 
-	log.Printf("Sending response with status %d to client\n", res.StatusCode)
 	return nil
 }
 
+// logger returns the proxy's logger, falling back to the default logger.
+func (p *Proxy) logger() *slog.Logger {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+// Reconfigure replaces the access log, logger, error pages and parsing
+// options. Connections being handled keep the ones they started with.
+func (p *Proxy) Reconfigure(accessLog *server.AccessLog, logger *slog.Logger, errorPages *server.ErrorPages, parsing parser.Options) {
+	p.mu.Lock()
+	p.AccessLog, p.Logger, p.ErrorPages, p.Parsing = accessLog, logger, errorPages, parsing
+	p.mu.Unlock()
+
+	settings := p.proxyServer.CurrentSettings()
+	settings.Logger = logger
+	p.proxyServer.Reconfigure(settings)
+}
+
+// CurrentAccessLog returns the access log new connections are recorded in.
+func (p *Proxy) CurrentAccessLog() *server.AccessLog {
+	return p.snapshot().AccessLog
+}
+
+// snapshot returns a copy of the proxy that is not affected by later calls
+// to Reconfigure.
+func (p *Proxy) snapshot() *Proxy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c := *p
+	return &c
+}
+
+// Wrapper for server implementation.
+// See server/server.go.
+func (p *Proxy) SetListeners(listeners []*server.Listener) error {
+	return p.proxyServer.SetListeners(listeners)
+}
+
+// Wrapper for server implementation.
+// See server/upgrade.go.
+func (p *Proxy) Upgrade(timeout time.Duration, admin *server.Admin) error {
+	return p.proxyServer.Upgrade(timeout, admin)
+}
+
+// Wrapper for server implementation.
+// See server/server.go.
+func (p *Proxy) Shutdown(timeout time.Duration) error {
+	return p.proxyServer.Shutdown(timeout)
+}
+
+// Wrapper for server implementation.
+// See server/server.go.
+func (p *Proxy) Ready() error {
+	return p.proxyServer.Ready()
+}
+
+// Wrapper for server implementation.
+// See server/server.go.
+func (p *Proxy) Stats() any {
+	return p.proxyServer.Stats()
+}
+
+// Wrapper for server implementation.
+// See server/server.go.
+func (p *Proxy) DrainHandler() http.Handler {
+	return p.proxyServer.DrainHandler()
+}
+
+// CheckUpstream returns a readiness check that fails while no TCP connection
+// can be made to the upstream at addr.
+func CheckUpstream(addr string) func() error {
+	return func() error {
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			return fmt.Errorf("upstream %s is unreachable: %v", addr, err)
+		}
+		return conn.Close()
+	}
+}
+
 // Wrapper for closing the server.
 func (p *Proxy) Close() {
 	p.proxyServer.Close()
@@ -1,17 +1,20 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"lab1/server"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
-
-	"github.com/joho/godotenv"
 )
 
 type testReq struct {
@@ -28,14 +31,20 @@ func TestMain(m *testing.M) {
 	cleanup()
 }
 
+// testRoot is the root of the server started by setup. It is created by
+// the first upload, inside a temporary directory.
+var testRoot string
+
 func setup() {
-	err := godotenv.Load("../.env")
+	dir, err := os.MkdirTemp("", "fs")
 	if err != nil {
 		panic(err)
 	}
+	testRoot = filepath.Join(dir, "fs")
 
 	log.Println("Setup: creating server and proxy")
 	server, _ := server.CreateServer("0.0.0.0", 6060, 10)
+	server.Root = testRoot
 	proxy, err := CreateProxy(6061)
 	if err != nil {
 		panic(err)
@@ -49,7 +58,7 @@ func setup() {
 }
 
 func cleanup() {
-	os.RemoveAll(os.Getenv("FS"))
+	os.RemoveAll(filepath.Dir(testRoot))
 }
 
 func TestGetNotFound(t *testing.T) {
@@ -80,7 +89,7 @@ func TestPostForbidden(t *testing.T) {
 
 func TestGetExistingFile(t *testing.T) {
 
-	path := os.Getenv("FS")
+	path := testRoot
 
 	//Create files in FS directory
 	server.WriteFile(path+"/test.txt", []byte("Hello world"))
@@ -174,3 +183,84 @@ func sendPostReq(t *testing.T, tr testReq) *http.Response {
 	}
 	return nil
 }
+
+// spanRecorder collects exported spans.
+type spanRecorder chan *server.Span
+
+func (r spanRecorder) Export(span *server.Span) { r <- span }
+
+func TestTracePropagation(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+	}))
+	defer upstream.Close()
+
+	spans := make(spanRecorder, 2)
+	p, err := CreateProxy(0)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	p.Tracer = &server.Tracer{Exporter: spans}
+	p.Listen()
+	go p.Serve()
+
+	proxyURL, _ := url.Parse("http://" + p.proxyServer.Listener.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	req, _ := http.NewRequest("GET", upstream.URL+"/a.txt", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+
+	got := <-headers
+	tc, err := server.ParseTraceparent(got.Get("traceparent"))
+	if err != nil {
+		t.Fatalf("upstream got invalid traceparent %q: %v", got.Get("traceparent"), err)
+	}
+	if fmt.Sprintf("%x", tc.TraceID) != "4bf92f3577b34da6a3ce929d0e0e4736" || fmt.Sprintf("%x", tc.SpanID) == "00f067aa0ba902b7" {
+		t.Errorf("upstream got traceparent %q, want a child of the client span", got.Get("traceparent"))
+	}
+	if got.Get("tracestate") != "vendor=value" {
+		t.Errorf("upstream got tracestate %q", got.Get("tracestate"))
+	}
+	if id := got.Get("X-Request-ID"); id == "" || res.Header.Get("X-Request-ID") != id {
+		t.Errorf("request ID %q was not propagated, response has %q", id, res.Header.Get("X-Request-ID"))
+	}
+
+	upstreamSpan, proxySpan := <-spans, <-spans
+	if upstreamSpan.ParentID != proxySpan.Context.SpanID || upstreamSpan.Context.SpanID != tc.SpanID {
+		t.Errorf("upstream span is not a child of the proxy span")
+	}
+}
+
+func TestMalformedRequest(t *testing.T) {
+	p, err := CreateProxy(0)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	if err := p.Listen(); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go p.Serve()
+	t.Cleanup(p.Close)
+
+	conn, err := net.Dial("tcp", p.proxyServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET http://0.0.0.0:6060/ HTTP/1.1\r\nHost: 0.0.0.0:6060\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.StatusCode != http.StatusBadRequest || res.Header.Get("Server") != Software {
+		t.Errorf("got %d from %q, want 400 from the proxy", res.StatusCode, res.Header.Get("Server"))
+	}
+}
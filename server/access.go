@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// admit runs the request through the access checks and limits and reports
+// whether it may be passed on to the handlers. Rejected requests have their
+// response built in res.
+func (s *Server) admit(req *http.Request, res *http.Response) (*http.Request, bool) {
+	req, ok := s.authorize(req, res)
+	if !ok {
+		return req, false
+	}
+
+	if s.RateLimiter != nil {
+		if allowed, wait := s.RateLimiter.Allow(req); !allowed {
+			LoggerFromRequest(req).Warn("Rate limited request", "method", req.Method)
+			s.HandleTooManyRequests(res, wait)
+			return req, false
+		}
+		req.Body = s.RateLimiter.Reader(req, req.Body)
+	}
+
+	return req, true
+}
+
+// authorize checks presigned links, credentials and ACLs.
+func (s *Server) authorize(req *http.Request, res *http.Response) (*http.Request, bool) {
+	// A valid presigned link stands in for both credentials and ACLs.
+	if s.Presigner != nil && IsPresigned(req) {
+		if err := s.Presigner.Verify(req); err != nil {
+			LoggerFromRequest(req).Warn("Rejected presigned request", "method", req.Method, "err", err)
+			s.HandleForbidden(res)
+			return req, false
+		}
+		return req, true
+	}
+
+	s.directoryPath(req)
+	req, ok := s.authenticate(req, res)
+	if !ok {
+		return req, false
+	}
+
+	// OPTIONS is answered with the methods the ACL allows instead.
+	if req.Method != http.MethodOptions && !s.checkACL(req, res) {
+		return req, false
+	}
+
+	return req, true
+}
+
+// directoryPath adds the trailing slash to the path of a GET, HEAD or
+// PROPFIND request for a directory, so that it is authorized as the listing
+// it is answered with however its path is spelled.
+func (s *Server) directoryPath(req *http.Request) {
+	switch {
+	case req.Method != http.MethodGet && req.Method != http.MethodHead && req.Method != "PROPFIND":
+		return
+	case strings.HasSuffix(req.URL.Path, "/"):
+		return
+	}
+	if info, err := os.Stat(s.localPath(req.URL.Path)); err == nil && info.IsDir() {
+		req.URL.Path += "/"
+	}
+}
+
+// checkACL reports whether the ACL, if any, admits the request, answering
+// 401 Unauthorized or 403 Forbidden if it does not.
+func (s *Server) checkACL(req *http.Request, res *http.Response) bool {
+	if s.ACL == nil {
+		return true
+	}
+	principal := PrincipalFromRequest(req)
+	decision := s.ACL.Check(req, remoteIP(req), principal)
+	if decision.Allowed {
+		return true
+	}
+	s.refuse(res, decision.NeedsAuth && principal == nil)
+	return false
+}
+
+// refuse answers a request the ACL does not admit, with a challenge if
+// credentials might change that.
+func (s *Server) refuse(res *http.Response, needsAuth bool) {
+	if needsAuth {
+		s.HandleUnauthorized(res)
+		if s.Auth != nil {
+			s.Auth.Challenge(res, false)
+		}
+	} else {
+		s.HandleForbidden(res)
+	}
+}
+
+// authenticate verifies the request's credentials, if required or present,
+// and returns the request carrying its principal.
+func (s *Server) authenticate(req *http.Request, res *http.Response) (*http.Request, bool) {
+	if s.Auth == nil {
+		return req, true
+	}
+
+	// Credentials are checked whenever they are sent, even where not required.
+	if !s.Auth.Required(req) && req.Header.Get("Authorization") == "" {
+		return req, true
+	}
+
+	principal, err := s.Auth.Authenticate(req)
+	if err != nil {
+		LoggerFromRequest(req).Warn("Authentication failed", "method", req.Method, "err", err)
+		s.HandleUnauthorized(res)
+		s.Auth.Challenge(res, errors.Is(err, errStaleNonce))
+		return req, false
+	}
+
+	if op := Operation(req); !principal.Allows(op, req.URL.Path) {
+		LoggerFromRequest(req).Warn("Principal is not allowed to access path", "principal", principal.Name, "op", op)
+		s.HandleForbidden(res)
+		return req, false
+	}
+
+	return WithPrincipal(req, principal), true
+}
+
+// remoteIP returns the IP address of the client that sent req.
+func remoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// connIP returns the IP address of the client at the other end of conn.
+func connIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// isUnix reports whether conn was accepted on a Unix domain socket.
+func isUnix(conn net.Conn) bool {
+	return conn.LocalAddr().Network() == "unix"
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Access log formats.
+const (
+	LogCommon   = "common"
+	LogCombined = "combined"
+	LogJSON     = "json"
+)
+
+// AccessEntry describes a handled request.
+type AccessEntry struct {
+	Time       time.Time
+	RemoteAddr string
+	User       string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Referer    string
+	UserAgent  string
+	RequestID  string
+}
+
+// NewAccessEntry starts an entry for req received at start. The status,
+// byte count and duration are filled in once the response is written.
+func NewAccessEntry(req *http.Request, start time.Time) *AccessEntry {
+	entry := &AccessEntry{
+		Time:       start,
+		RemoteAddr: req.RemoteAddr,
+		Method:     req.Method,
+		URI:        req.RequestURI,
+		Proto:      req.Proto,
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+		RequestID:  req.Header.Get("X-Request-ID"),
+	}
+	if p := PrincipalFromRequest(req); p != nil {
+		entry.User = p.Name
+	}
+	return entry
+}
+
+// AccessLog writes one line per request in Common Log Format, Combined Log
+// Format or JSON. The common and combined formats are followed by the quoted
+// request ID and the request duration in seconds.
+type AccessLog struct {
+	Format string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAccessLog creates an access log writing entries in format to w.
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	switch format {
+	case LogCommon, LogCombined, LogJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	return &AccessLog{Format: format, w: w}, nil
+}
+
+// Log writes the entry.
+func (l *AccessLog) Log(e *AccessEntry) {
+	var line string
+	switch l.Format {
+	case LogJSON:
+		data, _ := json.Marshal(map[string]any{
+			"time":        e.Time.Format(time.RFC3339Nano),
+			"remote_addr": e.RemoteAddr,
+			"user":        e.User,
+			"method":      e.Method,
+			"path":        e.URI,
+			"proto":       e.Proto,
+			"status":      e.Status,
+			"bytes":       e.Bytes,
+			"duration_ms": float64(e.Duration.Microseconds()) / 1000,
+			"referer":     e.Referer,
+			"user_agent":  e.UserAgent,
+			"request_id":  e.RequestID,
+		})
+		line = string(data)
+	default:
+		host := e.RemoteAddr
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		line = fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+			host, orDash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method, e.URI, e.Proto, e.Status, bytesField(e.Bytes))
+		if l.Format == LogCombined {
+			line += fmt.Sprintf(" %q %q", orDash(e.Referer), orDash(e.UserAgent))
+		}
+		line += fmt.Sprintf(" %q %.3f", orDash(e.RequestID), e.Duration.Seconds())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line+"\n")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesField(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+// NewRequestID returns a random request identifier.
+func NewRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Reopen reopens the log's file, if it writes to a RotatingFile.
+func (l *AccessLog) Reopen() error {
+	if f, ok := l.w.(*RotatingFile); ok {
+		return f.Reopen()
+	}
+	return nil
+}
+
+// Close closes the log's file, if it writes to a RotatingFile.
+func (l *AccessLog) Close() error {
+	if f, ok := l.w.(*RotatingFile); ok {
+		return f.Close()
+	}
+	return nil
+}
+
+// CountingReader counts the bytes read through it.
+type CountingReader struct {
+	io.ReadCloser
+	N int64
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// RotatingFile is an append-only log file that is rotated once it exceeds
+// MaxSize bytes or has been open for RotateEvery. Rotated files are renamed
+// with a timestamp suffix and only the newest MaxBackups are kept. Zero
+// values disable the respective limit.
+type RotatingFile struct {
+	Path        string
+	MaxSize     int64
+	RotateEvery time.Duration
+	MaxBackups  int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenRotatingFile opens, or creates, the log file at path.
+func OpenRotatingFile(path string) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file, rotating it first if a limit was reached.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	expired := f.RotateEvery > 0 && time.Since(f.opened) >= f.RotateEvery
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	if expired || full {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the file, picking up a file that was moved away
+// by an external tool such as logrotate. If the file cannot be opened, writes
+// continue to go to the old one.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// open opens the file for appending, replacing f.file only if it succeeds.
+// Callers other than OpenRotatingFile must hold f.mu.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// rotate renames the current file and opens a new one. Callers must hold f.mu.
+func (f *RotatingFile) rotate() error {
+	old := f.file
+	rotated := f.Path + "." + time.Now().Format("20060102-150405.000000")
+	if err := os.Rename(f.Path, rotated); err != nil {
+		slog.Warn("Error rotating log file, continuing with the current file", "path", f.Path, "err", err)
+		f.opened = time.Now()
+		return nil
+	}
+	if err := f.open(); err != nil {
+		slog.Warn("Error opening new log file, continuing with the rotated file", "path", f.Path, "err", err)
+		f.opened = time.Now()
+		return nil
+	}
+	old.Close()
+
+	if f.MaxBackups > 0 {
+		backups, _ := filepath.Glob(f.Path + ".*")
+		sort.Strings(backups)
+		for len(backups) > f.MaxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   *regexp.Regexp
+	}{
+		{LogCommon, regexp.MustCompile(`^127\.0\.0\.1 - - \[[^\]]+\] "GET /missing\.txt HTTP/1\.1" 404 13 "abc123" \d+\.\d{3}$`)},
+		{LogCombined, regexp.MustCompile(`^127\.0\.0\.1 - - \[[^\]]+\] "GET /missing\.txt HTTP/1\.1" 404 13 "-" "test-agent" "abc123" \d+\.\d{3}$`)},
+	}
+
+	for _, tt := range tests {
+		if line := sendLoggedRequest(t, tt.format); !tt.want.MatchString(line) {
+			t.Errorf("%s: got %q", tt.format, line)
+		}
+	}
+
+	line := sendLoggedRequest(t, LogJSON)
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("json: invalid entry %q: %v", line, err)
+	}
+	want := map[string]any{"method": "GET", "path": "/missing.txt", "status": 404.0, "bytes": 13.0, "user_agent": "test-agent", "request_id": "abc123"}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("json: got %s %v, want %v", key, entry[key], value)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := OpenRotatingFile(path)
+	if err != nil {
+		t.Fatalf("failed to open log file: %v", err)
+	}
+	defer file.Close()
+	file.MaxSize = 10
+	file.MaxBackups = 2
+
+	for i := 0; i < 5; i++ {
+		file.Write([]byte("12345678\n"))
+		time.Sleep(time.Millisecond)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("got %d backups, want 2", len(backups))
+	}
+
+	// A file moved away by an external tool is recreated on reopen.
+	os.Rename(path, path+".moved")
+	if err := file.Reopen(); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	file.Write([]byte("after\n"))
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("got %q after reopen", data)
+	}
+
+	// If the new file cannot be opened, writes go on to the old one.
+	file.MaxSize = 0
+	os.Rename(path, path+".kept")
+	os.Mkdir(path, 0o755)
+	if err := file.Reopen(); err == nil {
+		t.Fatal("reopening a directory succeeded")
+	}
+	if _, err := file.Write([]byte("kept\n")); err != nil {
+		t.Fatalf("write after failed reopen: %v", err)
+	}
+	if data, _ := os.ReadFile(path + ".kept"); string(data) != "after\nkept\n" {
+		t.Errorf("got %q in the old file after a failed reopen", data)
+	}
+}
+
+// sendLoggedRequest sends a request to a server logging in format and returns
+// the logged line.
+func sendLoggedRequest(t *testing.T, format string) string {
+	var buf lockedBuffer
+	accessLog, err := NewAccessLog(&buf, format)
+	if err != nil {
+		t.Fatalf("failed to create access log: %v", err)
+	}
+	addr := startTestServer(t, func(s *Server) { s.AccessLog = accessLog })
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/missing.txt", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Request-ID", "abc123")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+
+	// The entry is written after the response, so poll briefly.
+	for i := 0; i < 50 && len(buf.Bytes()) == 0; i++ {
+		time.Sleep(time.Duration(i) * time.Millisecond)
+	}
+	return strings.TrimSpace(string(buf.Bytes()))
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ACLRule allows or denies access to the paths matching Pattern.
+type ACLRule struct {
+	Allow      bool
+	Pattern    string
+	Methods    []string
+	Principals []string
+	Networks   []*net.IPNet
+	Line       int
+}
+
+// ACL is an ordered list of access rules read from a file. Each request is
+// decided by the first rule whose pattern, method, principal and network all
+// match; requests matching no rule are denied. The file is reloaded when it
+// changes.
+//
+// The file has one directive per line:
+//
+//	group <name> <user>...
+//	allow|deny <pattern> <methods> <principals> <networks>
+//
+// Patterns are path globs where "**" matches any number of path segments.
+// Methods, principals and networks are comma separated lists or "*" for any.
+// Principals are user names, "@group" or "authenticated". Networks are CIDR
+// ranges or bare IP addresses.
+type ACL struct {
+	// Audit receives an entry for every denied request. Defaults to the
+	// request-scoped logger.
+	Audit *slog.Logger
+
+	file *watchedFile
+
+	mu     sync.RWMutex
+	rules  []ACLRule
+	groups map[string][]string
+}
+
+// LoadACL reads an ACL file from path and returns any errors that occured.
+func LoadACL(path string) (*ACL, error) {
+	acl := &ACL{}
+	file, err := newWatchedFile(path, acl.parse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ACL: %v", err)
+	}
+	acl.file = file
+	return acl, nil
+}
+
+// ACLDecision is the outcome of evaluating a request against an ACL.
+type ACLDecision struct {
+	Allowed bool
+	// Rule is the rule that decided the request, nil if none matched.
+	Rule *ACLRule
+	// NeedsAuth is set when the request was denied but a rule would have
+	// allowed it for some authenticated principal.
+	NeedsAuth bool
+}
+
+// Evaluate decides whether the request from the client at ip may proceed.
+// principal is nil for anonymous requests.
+func (a *ACL) Evaluate(req *http.Request, ip net.IP, principal *Principal) ACLDecision {
+	a.file.refresh()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var decision ACLDecision
+	for i := range a.rules {
+		rule := &a.rules[i]
+		if !matchGlob(rule.Pattern, req.URL.Path) || !matchMethod(rule.Methods, req.Method) || !matchNetwork(rule.Networks, ip) {
+			continue
+		}
+		if !a.matchPrincipal(rule.Principals, principal) {
+			if rule.Allow && principal == nil {
+				decision.NeedsAuth = true
+			}
+			continue
+		}
+
+		decision.Allowed = rule.Allow
+		decision.Rule = rule
+		return decision
+	}
+	return decision
+}
+
+// Check evaluates the request and writes an audit entry if it is denied.
+func (a *ACL) Check(req *http.Request, ip net.IP, principal *Principal) ACLDecision {
+	decision := a.Evaluate(req, ip, principal)
+	if decision.Allowed {
+		return decision
+	}
+
+	name := "-"
+	if principal != nil {
+		name = principal.Name
+	}
+	rule := "default"
+	if decision.Rule != nil {
+		rule = fmt.Sprintf("line %d", decision.Rule.Line)
+	}
+
+	audit := a.Audit
+	if audit == nil {
+		audit = LoggerFromRequest(req)
+	}
+	audit.Warn("ACL denied request", "method", req.Method, "path", req.URL.Path, "principal", name, "ip", ip, "rule", rule)
+	return decision
+}
+
+func (a *ACL) matchPrincipal(principals []string, principal *Principal) bool {
+	for _, p := range principals {
+		switch {
+		case p == "*":
+			return true
+		case principal == nil:
+			continue
+		case p == "authenticated" || p == principal.Name:
+			return true
+		case strings.HasPrefix(p, "@"):
+			for _, member := range a.groups[p[1:]] {
+				if member == principal.Name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (a *ACL) parse(data []byte) error {
+	var rules []ACLRule
+	groups := make(map[string][]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "group":
+			if len(fields) < 2 {
+				return fmt.Errorf("line %d: group needs a name", line)
+			}
+			groups[fields[1]] = append(groups[fields[1]], fields[2:]...)
+		case "allow", "deny":
+			rule, err := parseACLRule(fields)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			rule.Line = line
+			rules = append(rules, rule)
+		default:
+			return fmt.Errorf("line %d: unknown directive %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		for _, p := range rule.Principals {
+			if strings.HasPrefix(p, "@") && groups[p[1:]] == nil {
+				return fmt.Errorf("line %d: unknown group %q", rule.Line, p[1:])
+			}
+		}
+	}
+
+	a.mu.Lock()
+	a.rules = rules
+	a.groups = groups
+	a.mu.Unlock()
+	return nil
+}
+
+func parseACLRule(fields []string) (ACLRule, error) {
+	if len(fields) != 5 {
+		return ACLRule{}, fmt.Errorf("expected %q, got %d fields", "allow|deny <pattern> <methods> <principals> <networks>", len(fields))
+	}
+
+	rule := ACLRule{Allow: fields[0] == "allow", Pattern: fields[1]}
+	if !strings.HasPrefix(rule.Pattern, "/") {
+		return ACLRule{}, fmt.Errorf("pattern %q must start with /", rule.Pattern)
+	}
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return ACLRule{}, fmt.Errorf("invalid pattern %q: %v", rule.Pattern, err)
+	}
+
+	if fields[2] != "*" {
+		rule.Methods = strings.Split(strings.ToUpper(fields[2]), ",")
+	}
+	rule.Principals = strings.Split(fields[3], ",")
+	if fields[4] != "*" {
+		networks, err := ParseNetworks(strings.Split(fields[4], ","))
+		if err != nil {
+			return ACLRule{}, err
+		}
+		rule.Networks = networks
+	}
+	return rule, nil
+}
+
+// ParseNetworks parses CIDR ranges, treating bare IP addresses as single hosts.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func matchMethod(methods []string, method string) bool {
+	if methods == nil {
+		return true
+	}
+	for _, m := range methods {
+		// HEAD reveals what GET would, so GET rules cover it.
+		if m == method || method == http.MethodHead && m == http.MethodGet {
+			return true
+		}
+	}
+	return false
+}
+
+func matchNetwork(networks []*net.IPNet, ip net.IP) bool {
+	return networks == nil || (ip != nil && containsIP(networks, ip))
+}
+
+// matchGlob reports whether name matches the slash separated glob pattern.
+// "**" matches zero or more whole path segments, other segments are matched
+// with path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(splitPath(pattern), splitPath(name))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"/public/**", "/public", true},
+		{"/public/**", "/public/a/b/c.txt", true},
+		{"/public/**", "/publicity.txt", false},
+		{"/public/*.txt", "/public/a.txt", true},
+		{"/public/*.txt", "/public/a/b.txt", false},
+		{"/**/secret.txt", "/a/b/secret.txt", true},
+		{"/**/secret.txt", "/secret.txt", true},
+		{"/**", "/", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestACL(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl")
+	writeTestFile(t, aclFile, `
+group uploaders alice
+
+allow /public/**  GET           *           *
+allow /uploads/** POST          @uploaders  *
+allow /admin/**   *             *           10.0.0.0/8
+allow /local/**   GET           *           127.0.0.1,::1
+`)
+
+	acl, err := LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("failed to load ACL: %v", err)
+	}
+	acl.file.interval = 0
+
+	addr := startTestServer(t, func(s *Server) {
+		s.Auth = newTestAuth(t)
+		s.ACL = acl
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		user   string
+		want   int
+	}{
+		{name: "Public read", method: "GET", path: "/public/a.txt", want: 404},
+		{name: "Public write", method: "POST", path: "/public/a.txt", want: 403},
+		{name: "Anonymous upload", method: "POST", path: "/uploads/a.txt", want: 401},
+		{name: "Group member upload", method: "POST", path: "/uploads/a.txt", user: "alice", want: 200},
+		{name: "Admin from wrong network", method: "GET", path: "/admin/a.txt", user: "alice", want: 403},
+		{name: "Local read", method: "GET", path: "/local/a.txt", want: 404},
+		{name: "No matching rule", method: "GET", path: "/other.txt", want: 403},
+	}
+
+	for _, tt := range tests {
+		if got := sendACLRequest(t, addr, tt.method, tt.path, tt.user); got != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	writeTestFile(t, aclFile, "allow /** * * *\n")
+	if got := sendACLRequest(t, addr, "GET", "/other.txt", ""); got != 404 {
+		t.Errorf("after reload: got status %d, want 404", got)
+	}
+}
+
+func TestACLPathTraversal(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl")
+	writeTestFile(t, aclFile, "allow /public/** GET * *\n")
+	acl, err := LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("failed to load ACL: %v", err)
+	}
+
+	outside := t.TempDir()
+	root := filepath.Join(outside, "root")
+	os.MkdirAll(filepath.Join(root, "public"), 0o755)
+	writeTestFile(t, filepath.Join(root, "secret.txt"), "secret")
+	writeTestFile(t, filepath.Join(root, "public", "a.txt"), "public")
+	writeTestFile(t, filepath.Join(outside, "outside.txt"), "outside")
+	addr := startTestServer(t, func(s *Server) {
+		s.Root = root
+		s.ACL = acl
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/public/a.txt", 200},
+		{"/public//a.txt", 200},
+		{"/public/./a.txt", 200},
+		{"/secret.txt", 403},
+		{"/public/../secret.txt", 403},
+		{"/public/%2e%2e/secret.txt", 403},
+		{"//secret.txt", 403},
+		{"/public/../../outside.txt", 400},
+		{"/public/../../../../etc/hostname", 400},
+	}
+	for _, test := range tests {
+		res, body := rawExchange(t, addr, "GET "+test.path+" HTTP/1.0\r\n\r\n")
+		if res.StatusCode != test.want {
+			t.Errorf("%s: got %d %q, want %d", test.path, res.StatusCode, body, test.want)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+		ok         bool
+	}{
+		{"/a/b.txt", "/a/b.txt", true},
+		{"/a//b/./c/", "/a/b/c/", true},
+		{"/a/../b", "/b", true},
+		{"", "/", true},
+		{"/..", "", false},
+		{"/a/../../b", "", false},
+	}
+	for _, test := range tests {
+		if got, ok := cleanPath(test.path); got != test.want || ok != test.ok {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q, %v", test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLoadACLErrors(t *testing.T) {
+	tests := map[string]string{
+		"Unknown directive": "permit /** * * *",
+		"Missing fields":    "allow /** GET",
+		"Relative pattern":  "allow public/** * * *",
+		"Invalid network":   "allow /** * * 10.0.0.0/33",
+		"Unknown group":     "allow /** * @nobody *",
+	}
+
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "acl")
+		writeTestFile(t, path, content)
+		if _, err := LoadACL(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func sendACLRequest(t *testing.T, addr, method, path, user string) int {
+	req, _ := http.NewRequest(method, "http://"+addr+path, strings.NewReader("data"))
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sync"
+	"time"
+)
+
+// Admin serves operational endpoints, such as health checks and the list of
+// banned clients, on a listener separate from the file server.
+//
+// Every admin request is authenticated when Auth is set, except for the
+// paths in Public, and is then checked against ACL when it is set.
+type Admin struct {
+	Address  string
+	Listener net.Listener
+
+	Auth *Auth
+	// AuthFunc, when set, returns the Auth for each request in place of
+	// Auth, so that the credentials follow reloads.
+	AuthFunc func() *Auth
+	ACL      *ACL
+	// Public lists the paths that may be requested without credentials.
+	// Defaults to the liveness and readiness probes.
+	Public []string
+
+	mux     *http.ServeMux
+	server  *http.Server
+	started time.Time
+
+	mu     sync.Mutex
+	checks map[string]func() error
+	stats  map[string]func() any
+}
+
+// NewAdmin creates an admin server that will listen on address, serving
+// /healthz, /readyz, /stats, /goroutines and the net/http/pprof profiles
+// under /debug/pprof/.
+func NewAdmin(address string) *Admin {
+	a := &Admin{
+		Address: address,
+		Public:  []string{"/healthz", "/readyz"},
+		mux:     http.NewServeMux(),
+		started: time.Now(),
+		checks:  make(map[string]func() error),
+		stats:   make(map[string]func() any),
+	}
+	a.server = &http.Server{Handler: a}
+	a.mux.HandleFunc("/healthz", a.handleHealth)
+	a.mux.HandleFunc("/readyz", a.handleReady)
+	a.mux.Handle("/stats", JSONHandler(a.Stats))
+	a.mux.HandleFunc("/goroutines", handleGoroutines)
+	a.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return a
+}
+
+// Handle registers the handler for the given pattern.
+func (a *Admin) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// AddCheck adds a readiness check. /readyz fails while any check returns an error.
+func (a *Admin) AddCheck(name string, check func() error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.checks[name] = check
+}
+
+// RemoveCheck removes the readiness check with the given name.
+func (a *Admin) RemoveCheck(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.checks, name)
+}
+
+// AddStats adds a section to the runtime stats served by /stats.
+func (a *Admin) AddStats(name string, stats func() any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats[name] = stats
+}
+
+// Ready runs the readiness checks and returns the failures by check name.
+func (a *Admin) Ready() map[string]string {
+	a.mu.Lock()
+	checks := make(map[string]func() error, len(a.checks))
+	for name, check := range a.checks {
+		checks[name] = check
+	}
+	a.mu.Unlock()
+
+	failures := make(map[string]string)
+	for name, check := range checks {
+		if err := check(); err != nil {
+			failures[name] = err.Error()
+		}
+	}
+	return failures
+}
+
+// Stats returns the process's runtime stats along with the added sections.
+func (a *Admin) Stats() any {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := map[string]any{
+		"uptime_seconds": time.Since(a.started).Seconds(),
+		"goroutines":     runtime.NumGoroutine(),
+		"memory": map[string]any{
+			"alloc_bytes":  mem.Alloc,
+			"sys_bytes":    mem.Sys,
+			"heap_objects": mem.HeapObjects,
+			"num_gc":       mem.NumGC,
+		},
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, section := range a.stats {
+		stats[name] = section()
+	}
+	return stats
+}
+
+// ServeHTTP authorizes the request and passes it on to the admin endpoints.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := a.Auth
+	if a.AuthFunc != nil {
+		auth = a.AuthFunc()
+	}
+	principal, ok := a.authenticate(w, r, auth)
+	if !ok {
+		return
+	}
+	if principal != nil {
+		r = WithPrincipal(r, principal)
+	}
+
+	if a.ACL != nil {
+		decision := a.ACL.Check(r, remoteIP(r), principal)
+		if !decision.Allowed {
+			if decision.NeedsAuth && principal == nil && auth != nil {
+				challenge(w, auth, false)
+				return
+			}
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+// authenticate checks the request's credentials, which are required for all
+// but the public paths. The principal is nil for anonymous requests.
+func (a *Admin) authenticate(w http.ResponseWriter, r *http.Request, auth *Auth) (*Principal, bool) {
+	if auth == nil {
+		return nil, true
+	}
+	if r.Header.Get("Authorization") == "" && a.isPublic(r.URL.Path) {
+		return nil, true
+	}
+
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		slog.Warn("Admin authentication failed", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "err", err)
+		challenge(w, auth, errors.Is(err, errStaleNonce))
+		return nil, false
+	}
+	if op := Operation(r); !principal.Allows(op, r.URL.Path) {
+		slog.Warn("Principal is not allowed to access admin path", "principal", principal.Name, "op", op, "path", r.URL.Path)
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return principal, true
+}
+
+func (a *Admin) isPublic(path string) bool {
+	for _, p := range a.Public {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// challenge answers with 401 and the authentication challenges.
+func challenge(w http.ResponseWriter, auth *Auth, stale bool) {
+	auth.Challenge(&http.Response{Header: w.Header()}, stale)
+	http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+}
+
+func (a *Admin) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+func (a *Admin) handleReady(w http.ResponseWriter, r *http.Request) {
+	failures := a.Ready()
+	w.Header().Set("Content-Type", "application/json")
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]any{"ready": false, "failures": failures})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ready": true})
+}
+
+// handleGoroutines writes the stacks of all goroutines as plain text.
+func handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// JSONHandler serves the value returned by fn as JSON to GET requests.
+func JSONHandler(fn func() any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(fn())
+	})
+}
+
+// TriggerHandler calls fn in the background for POST requests and answers
+// 202 Accepted, for actions such as shutting down that outlive the request.
+func TriggerHandler(fn func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		go fn()
+	})
+}
+
+// Listen establishes the admin listener, taking over the socket handed over
+// by an upgrade if there is one.
+func (a *Admin) Listen() error {
+	var err error
+	a.Listener, err = inheritedListener(a.endpoint())
+	if err == nil && a.Listener == nil {
+		a.Listener, err = net.Listen("tcp", a.Address)
+	}
+	if err != nil {
+		slog.Error("Error starting admin server", "addr", a.Address, "err", err)
+		return err
+	}
+	slog.Info("Admin endpoints listening", "addr", a.Listener.Addr().String())
+	return nil
+}
+
+// endpoint identifies the admin listener's socket.
+func (a *Admin) endpoint() string {
+	return "tcp " + a.Address
+}
+
+// Serve handles admin requests until the admin server is closed.
+func (a *Admin) Serve() error {
+	err := a.server.Serve(a.Listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the admin server.
+func (a *Admin) Close() {
+	if err := a.server.Close(); err != nil {
+		slog.Error("Error closing admin server", "err", err)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdminProbes(t *testing.T) {
+	s, err := CreateServer("127.0.0.1", 0, 10)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	s.Root = t.TempDir()
+
+	admin := NewAdmin("")
+	admin.AddCheck("listener", s.Ready)
+	admin.AddCheck("storage", s.CheckRoot)
+	admin.Handle("/drain", s.DrainHandler())
+
+	if code := serveAdmin(admin, "GET", "/healthz").Code; code != http.StatusOK {
+		t.Errorf("healthz got %d", code)
+	}
+
+	rec := serveAdmin(admin, "GET", "/readyz")
+	var ready struct {
+		Ready    bool
+		Failures map[string]string
+	}
+	json.Unmarshal(rec.Body.Bytes(), &ready)
+	if rec.Code != http.StatusServiceUnavailable || ready.Failures["listener"] == "" || ready.Failures["storage"] != "" {
+		t.Errorf("readyz before listening got %d %s", rec.Code, rec.Body)
+	}
+
+	if err := s.Listen(); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+
+	if rec := serveAdmin(admin, "GET", "/readyz"); rec.Code != http.StatusOK {
+		t.Errorf("readyz while listening got %d %s", rec.Code, rec.Body)
+	}
+
+	serveAdmin(admin, "POST", "/drain")
+	if rec := serveAdmin(admin, "GET", "/readyz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz while draining got %d %s", rec.Code, rec.Body)
+	}
+
+	if err := s.Shutdown(time.Second); err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Serve did not return after shutdown")
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	admin := NewAdmin("")
+	admin.Auth = newTestAuth(t)
+	path := filepath.Join(t.TempDir(), "acl")
+	writeTestFile(t, path, "allow /healthz GET * *\nallow /stats GET @ops *\ngroup ops alice\n")
+	acl, err := LoadACL(path)
+	if err != nil {
+		t.Fatalf("failed to load ACL: %v", err)
+	}
+	admin.ACL = acl
+
+	if code := serveAdmin(admin, "GET", "/healthz").Code; code != http.StatusOK {
+		t.Errorf("healthz got %d", code)
+	}
+	if code := serveAdmin(admin, "GET", "/readyz").Code; code != http.StatusForbidden {
+		t.Errorf("readyz got %d, want 403 from the ACL", code)
+	}
+
+	rec := serveAdmin(admin, "GET", "/stats")
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("anonymous stats got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.SetBasicAuth("alice", "secret")
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	var stats map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); rec.Code != http.StatusOK || err != nil || stats["goroutines"] == nil {
+		t.Errorf("stats got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdminAuthFunc(t *testing.T) {
+	auth := newTestAuth(t)
+	admin := NewAdmin("")
+	admin.AuthFunc = func() *Auth { return auth }
+
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.SetBasicAuth("alice", "secret")
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+
+	// A reload that removes alice revokes her access.
+	var err error
+	if auth, err = NewAuth("files", nil); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got %d after the reload, want 401", rec.Code)
+	}
+}
+
+func serveAdmin(admin *Admin, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a Digest nonce stays valid before clients are asked to retry with a fresh one.
+const nonceLifetime = 5 * time.Minute
+
+// Principal is an authenticated client.
+type Principal struct {
+	Name   string
+	Scheme string
+
+	// Scopes restricts bearer token principals. Nil means unrestricted.
+	Scopes []Scope
+}
+
+// Allows reports whether the principal may perform op on path.
+func (p *Principal) Allows(op, path string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, scope := range p.Scopes {
+		if !hasPathPrefix(path, scope.Prefix) {
+			continue
+		}
+		for _, allowed := range scope.Ops {
+			if allowed == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of req carrying the authenticated principal.
+func WithPrincipal(req *http.Request, p *Principal) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+// PrincipalFromRequest returns the principal that authenticated req, or nil.
+func PrincipalFromRequest(req *http.Request) *Principal {
+	p, _ := req.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// errStaleNonce is returned for Digest credentials with a valid but expired nonce.
+var errStaleNonce = errors.New("stale nonce")
+
+// AuthRule requires authentication for the given methods on paths below
+// Prefix. An empty Methods list matches every method.
+type AuthRule struct {
+	Prefix  string
+	Methods []string
+}
+
+// Auth authenticates requests using HTTP Basic authentication against an
+// htpasswd user file, HTTP Digest authentication (RFC 7616) when a digest file
+// is configured and Bearer tokens when a token key is configured. Any of the
+// three may be left unset.
+type Auth struct {
+	Realm  string
+	Users  *UserFile
+	Digest *DigestFile
+	Tokens *TokenAuth
+	Rules  []AuthRule
+
+	secret []byte
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// NewAuth creates an authenticator for realm backed by the given user file,
+// which may be nil when only Digest or Bearer authentication is used.
+func NewAuth(realm string, users *UserFile) (*Auth, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce secret: %v", err)
+	}
+
+	return &Auth{
+		Realm:  realm,
+		Users:  users,
+		secret: secret,
+		counts: make(map[string]uint64),
+	}, nil
+}
+
+// Require adds a rule requiring authentication for methods below prefix.
+func (a *Auth) Require(prefix string, methods ...string) {
+	a.Rules = append(a.Rules, AuthRule{Prefix: prefix, Methods: methods})
+}
+
+// Required reports whether any rule matches the request.
+func (a *Auth) Required(req *http.Request) bool {
+	for _, rule := range a.Rules {
+		if !hasPathPrefix(req.URL.Path, rule.Prefix) {
+			continue
+		}
+		if len(rule.Methods) == 0 {
+			return true
+		}
+		for _, m := range rule.Methods {
+			// HEAD reveals what GET would, so GET rules cover it.
+			if strings.EqualFold(m, req.Method) || req.Method == http.MethodHead && strings.EqualFold(m, http.MethodGet) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate verifies the credentials in the Authorization header and
+// returns the principal they belong to.
+func (a *Auth) Authenticate(req *http.Request) (*Principal, error) {
+	header := req.Header.Get("Authorization")
+	if header == "" {
+		return nil, errors.New("missing credentials")
+	}
+
+	scheme, params, _ := strings.Cut(header, " ")
+	switch {
+	case strings.EqualFold(scheme, "Basic") && a.Users != nil:
+		return a.authenticateBasic(params)
+	case strings.EqualFold(scheme, "Digest") && a.Digest != nil:
+		return a.authenticateDigest(req, params)
+	case strings.EqualFold(scheme, "Bearer") && a.Tokens != nil:
+		return a.authenticateBearer(params)
+	default:
+		return nil, fmt.Errorf("unsupported authorization scheme %q", scheme)
+	}
+}
+
+// Challenge adds the WWW-Authenticate headers for the supported schemes to
+// res. stale should be set when the client's Digest nonce expired.
+func (a *Auth) Challenge(res *http.Response, stale bool) {
+	if a.Digest != nil {
+		for _, algorithm := range []string{"SHA-256", "MD5"} {
+			challenge := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=%s, nonce=%q`, a.Realm, algorithm, a.newNonce())
+			if stale {
+				challenge += ", stale=true"
+			}
+			res.Header.Add("WWW-Authenticate", challenge)
+		}
+	}
+	if a.Users != nil {
+		res.Header.Add("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.Realm))
+	}
+	if a.Tokens != nil {
+		res.Header.Add("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q`, a.Realm))
+	}
+}
+
+func (a *Auth) authenticateBasic(params string) (*Principal, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(params))
+	if err != nil {
+		return nil, fmt.Errorf("malformed basic credentials: %v", err)
+	}
+
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, errors.New("malformed basic credentials")
+	}
+	if !a.Users.Verify(user, password) {
+		return nil, fmt.Errorf("invalid password for user %q", user)
+	}
+
+	return &Principal{Name: user, Scheme: "basic"}, nil
+}
+
+func (a *Auth) authenticateDigest(req *http.Request, params string) (*Principal, error) {
+	fields, err := parseAuthParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	user := fields["username"]
+	if fields["realm"] != a.Realm {
+		return nil, fmt.Errorf("wrong realm %q", fields["realm"])
+	}
+	if fields["uri"] != req.RequestURI && fields["uri"] != req.URL.RequestURI() {
+		return nil, fmt.Errorf("digest uri %q does not match request", fields["uri"])
+	}
+	if fields["qop"] != "auth" {
+		return nil, fmt.Errorf("unsupported qop %q", fields["qop"])
+	}
+
+	algorithm := fields["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	sess := strings.HasSuffix(algorithm, "-sess")
+	base := strings.TrimSuffix(algorithm, "-sess")
+
+	var newHash func() hash.Hash
+	switch base {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	digest := func(parts ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	ha1, ok := a.Digest.HA1(user, a.Realm, base)
+	if !ok {
+		return nil, fmt.Errorf("unknown user %q", user)
+	}
+	nonce, nc, cnonce := fields["nonce"], fields["nc"], fields["cnonce"]
+	if sess {
+		ha1 = digest(ha1, nonce, cnonce)
+	}
+	ha2 := digest(req.Method, fields["uri"])
+	expected := digest(ha1, nonce, nc, cnonce, "auth", ha2)
+	if !constantTimeEqual(expected, strings.ToLower(fields["response"])) {
+		return nil, fmt.Errorf("invalid digest response for user %q", user)
+	}
+
+	if err := a.checkNonce(nonce, nc); err != nil {
+		return nil, err
+	}
+
+	return &Principal{Name: user, Scheme: "digest"}, nil
+}
+
+func (a *Auth) authenticateBearer(token string) (*Principal, error) {
+	claims, err := a.Tokens.Verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := claims.Scopes
+	if scopes == nil {
+		scopes = []Scope{}
+	}
+	return &Principal{Name: claims.Subject, Scheme: "bearer", Scopes: scopes}, nil
+}
+
+// newNonce returns a nonce holding its creation time, signed with the server secret.
+func (a *Auth) newNonce() string {
+	buf := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(buf))
+}
+
+// checkNonce verifies the nonce signature and age and that the nonce count
+// increases, which prevents replaying a captured request.
+func (a *Auth) checkNonce(nonce, nc string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != 8+sha256.Size {
+		return errors.New("malformed nonce")
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(raw[:8])
+	if !hmac.Equal(mac.Sum(nil), raw[8:]) {
+		return errors.New("invalid nonce signature")
+	}
+
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil {
+		return fmt.Errorf("malformed nonce count %q", nc)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pruneNonces()
+
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(raw[:8])))
+	if time.Since(issued) > nonceLifetime {
+		return errStaleNonce
+	}
+	if count <= a.counts[nonce] {
+		return fmt.Errorf("replayed nonce count %s", nc)
+	}
+	a.counts[nonce] = count
+	return nil
+}
+
+// pruneNonces forgets the counts of expired nonces. Callers must hold a.mu.
+func (a *Auth) pruneNonces() {
+	for nonce := range a.counts {
+		raw, _ := base64.RawURLEncoding.DecodeString(nonce)
+		issued := time.Unix(0, int64(binary.BigEndian.Uint64(raw[:8])))
+		if time.Since(issued) > nonceLifetime {
+			delete(a.counts, nonce)
+		}
+	}
+}
+
+// parseAuthParams parses a comma separated list of key=value pairs where
+// values may be quoted strings, as used by the Digest scheme.
+func parseAuthParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, nil
+		}
+
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed auth parameter %q", s)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return nil, fmt.Errorf("unterminated quoted value for %q", key)
+			}
+			s = rest[i+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
+
+// hasPathPrefix reports whether path is prefix or lies below it.
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("Hello world!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to generate bcrypt hash: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		hashed string
+	}{
+		{name: "bcrypt", hashed: string(bcryptHash)},
+		{name: "SHA-256 crypt", hashed: "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{name: "SHA-256 crypt with rounds", hashed: "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{name: "SHA-512 crypt", hashed: "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{name: "Apache MD5", hashed: "$apr1$abcdefgh$Unf1zc.jsgCbBQDCL104q."},
+		{name: "SHA1", hashed: "{SHA}00hq6RNueFa8QiEjhep5cJRHWAI="},
+	}
+
+	for _, tt := range tests {
+		if !checkPassword(tt.hashed, "Hello world!") {
+			t.Errorf("%s: correct password rejected", tt.name)
+		}
+		if checkPassword(tt.hashed, "Hello world") {
+			t.Errorf("%s: wrong password accepted", tt.name)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.Auth = newTestAuth(t)
+		s.Auth.Require("/private")
+		s.Auth.Require("/", http.MethodPost)
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		user     string
+		password string
+		want     int
+	}{
+		{name: "Public GET", method: "GET", path: "/auth.txt", want: 404},
+		{name: "POST without credentials", method: "POST", path: "/auth.txt", want: 401},
+		{name: "POST with wrong password", method: "POST", path: "/auth.txt", user: "alice", password: "wrong", want: 401},
+		{name: "POST with credentials", method: "POST", path: "/auth.txt", user: "alice", password: "secret", want: 200},
+		{name: "Private GET without credentials", method: "GET", path: "/private/a.txt", want: 401},
+		{name: "Private GET with credentials", method: "GET", path: "/private/a.txt", user: "alice", password: "secret", want: 404},
+		{name: "Prefix does not match sibling", method: "GET", path: "/privateer.txt", want: 404},
+		{name: "Private GET through dot segments", method: "GET", path: "/a/../private/a.txt", want: 401},
+		{name: "Private GET with double slash", method: "GET", path: "//private/a.txt", want: 401},
+		{name: "Private GET with dot segment", method: "GET", path: "/./private/a.txt", want: 401},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "http://"+addr+tt.path, strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.password)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.want)
+		}
+		if res.StatusCode == 401 && !strings.Contains(res.Header.Get("WWW-Authenticate"), `realm="files"`) {
+			t.Errorf("%s: missing challenge, got %q", tt.name, res.Header.Values("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	auth := newTestAuth(t)
+	auth.Require("/")
+	addr := startTestServer(t, func(s *Server) { s.Auth = auth })
+
+	res, err := http.Get("http://" + addr + "/digest.txt")
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+
+	var challenge map[string]string
+	for _, h := range res.Header.Values("WWW-Authenticate") {
+		if strings.HasPrefix(h, "Digest ") && strings.Contains(h, "algorithm=MD5") {
+			challenge, err = parseAuthParams(strings.TrimPrefix(h, "Digest "))
+			if err != nil {
+				t.Fatalf("failed to parse challenge: %v", err)
+			}
+		}
+	}
+	if challenge == nil {
+		t.Fatalf("no MD5 digest challenge in %q", res.Header.Values("WWW-Authenticate"))
+	}
+
+	send := func(nc, password string) int {
+		h := func(s string) string {
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}
+		ha1 := h("alice:files:" + password)
+		ha2 := h("GET:/digest.txt")
+		response := h(strings.Join([]string{ha1, challenge["nonce"], nc, "abc", "auth", ha2}, ":"))
+
+		req, _ := http.NewRequest("GET", "http://"+addr+"/digest.txt", nil)
+		req.Header.Set("Authorization", fmt.Sprintf(
+			`Digest username="alice", realm="files", nonce=%q, uri="/digest.txt", algorithm=MD5, qop=auth, nc=%s, cnonce="abc", response=%q`,
+			challenge["nonce"], nc, response))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if got := send("00000001", "secret"); got != 404 {
+		t.Errorf("valid digest: got status %d, want 404", got)
+	}
+	if got := send("00000001", "secret"); got != 401 {
+		t.Errorf("replayed nonce count: got status %d, want 401", got)
+	}
+	if got := send("00000002", "wrong"); got != 401 {
+		t.Errorf("wrong password: got status %d, want 401", got)
+	}
+	if got := send("00000003", "secret"); got != 404 {
+		t.Errorf("next nonce count: got status %d, want 404", got)
+	}
+}
+
+func TestUserFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeTestFile(t, path, "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+
+	users, err := LoadUserFile(path)
+	if err != nil {
+		t.Fatalf("failed to load user file: %v", err)
+	}
+	users.file.interval = 0
+
+	if !users.Verify("alice", "secret") {
+		t.Fatalf("alice rejected before reload")
+	}
+
+	writeTestFile(t, path, "bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	if users.Verify("alice", "secret") || !users.Verify("bob", "secret") {
+		t.Errorf("user file was not reloaded")
+	}
+
+	writeTestFile(t, path, "not a valid line\n")
+	if !users.Verify("bob", "secret") {
+		t.Errorf("invalid user file replaced the previous version")
+	}
+}
+
+// newTestAuth creates an authenticator for the realm "files" where alice has
+// the password "secret".
+func newTestAuth(t *testing.T) *Auth {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "htpasswd"), "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	writeTestFile(t, filepath.Join(dir, "htdigest"), "alice:files:5e93c589edbe9d5867a3bce43a1066c1\n")
+
+	users, err := LoadUserFile(filepath.Join(dir, "htpasswd"))
+	if err != nil {
+		t.Fatalf("failed to load user file: %v", err)
+	}
+	auth, err := NewAuth("files", users)
+	if err != nil {
+		t.Fatalf("failed to create auth: %v", err)
+	}
+	auth.Digest, err = LoadDigestFile(filepath.Join(dir, "htdigest"))
+	if err != nil {
+		t.Fatalf("failed to load digest file: %v", err)
+	}
+	return auth
+}
+
+// startTestServer starts a server on a random port, configured by configure,
+// and returns its address.
+func startTestServer(t *testing.T, configure func(s *Server)) string {
+	s, err := CreateServer("127.0.0.1", 0, 10)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	s.Root = t.TempDir()
+	configure(s)
+	if err := s.Listen(); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go s.Serve()
+	t.Cleanup(s.Close)
+
+	return s.Listener.Addr().String()
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
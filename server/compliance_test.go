@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"lab1/parser"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawExchange sends raw to addr and reads the response, checking the framing
+// every response must have: a Date and Server header, and a Content-Length
+// matching the body unless the status or method forbids a body.
+func rawExchange(t *testing.T, addr, raw string) (*http.Response, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+
+	method, _, _ := strings.Cut(raw, " ")
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if rest, _ := io.ReadAll(r); len(rest) > 0 {
+		t.Errorf("%d bytes after the response: %q", len(rest), rest)
+	}
+
+	if _, err := http.ParseTime(res.Header.Get("Date")); err != nil {
+		t.Errorf("invalid Date header %q", res.Header.Get("Date"))
+	}
+	if res.Header.Get("Server") != Software {
+		t.Errorf("got Server %q, want %q", res.Header.Get("Server"), Software)
+	}
+	length := res.Header.Get("Content-Length")
+	switch {
+	case res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified:
+		if length != "" || len(body) > 0 {
+			t.Errorf("%d response with Content-Length %q and body %q", res.StatusCode, length, body)
+		}
+	case method == http.MethodHead:
+		if len(body) > 0 {
+			t.Errorf("HEAD response with body %q", body)
+		}
+	case length != strconv.Itoa(len(body)):
+		t.Errorf("got Content-Length %q for a body of %d bytes", length, len(body))
+	}
+	return res, string(body)
+}
+
+func TestCompliance(t *testing.T) {
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.Auth = newTestAuth(t)
+		s.Auth.Require("/private", http.MethodGet)
+	})
+	writeTestFile(t, filepath.Join(root, "hello.txt"), "hello world")
+	writeTestFile(t, filepath.Join(root, "file.exe"), "binary")
+
+	tests := []struct {
+		name, raw string
+		proto     string
+		status    int
+		header    map[string]string
+		body      string
+	}{
+		{"HTTP/1.0 GET", "GET /hello.txt HTTP/1.0\r\n\r\n", "HTTP/1.0", 200, map[string]string{"Content-Length": "11", "Content-Type": "text/plain"}, "hello world"},
+		{"HTTP/1.1 GET", "GET /hello.txt HTTP/1.1\r\nHost: test\r\n\r\n", "HTTP/1.1", 200, nil, "hello world"},
+		{"higher minor version", "GET /hello.txt HTTP/1.7\r\nHost: test\r\n\r\n", "HTTP/1.1", 200, nil, "hello world"},
+		{"HEAD", "HEAD /hello.txt HTTP/1.0\r\n\r\n", "HTTP/1.0", 200, map[string]string{"Content-Length": "11"}, ""},
+		{"HEAD not found", "HEAD /missing.txt HTTP/1.1\r\nHost: test\r\n\r\n", "HTTP/1.1", 404, map[string]string{"Content-Length": "13"}, ""},
+		{"POST", "POST /new.txt HTTP/1.0\r\nContent-Length: 3\r\n\r\nnew", "HTTP/1.0", 200, nil, "200 OK"},
+		{"unauthorized", "GET /private/a.txt HTTP/1.0\r\n\r\n", "HTTP/1.0", 401, nil, "401 Unauthorized"},
+		{"HEAD covered by GET rule", "HEAD /private/a.txt HTTP/1.0\r\n\r\n", "HTTP/1.0", 401, nil, ""},
+		{"unsupported type", "GET /file.exe HTTP/1.0\r\n\r\n", "HTTP/1.0", 403, nil, "403 Forbidden"},
+		{"not found", "GET /missing.exe HTTP/1.0\r\n\r\n", "HTTP/1.0", 404, nil, "404 Not Found"},
+		{"POST to directory", "POST / HTTP/1.0\r\nContent-Length: 1\r\n\r\nx", "HTTP/1.0", 405, map[string]string{"Allow": "GET, HEAD, OPTIONS"}, "405 Method Not Allowed"},
+		{"unsupported upload", "POST /a.exe HTTP/1.0\r\nContent-Length: 1\r\n\r\nx", "HTTP/1.0", 415, nil, "415 Unsupported Media Type"},
+		{"unknown expectation", "POST /a.txt HTTP/1.1\r\nHost: test\r\nExpect: 200-ok\r\nContent-Length: 1\r\n\r\nx", "HTTP/1.1", 417, nil, "417 Expectation Failed"},
+		{"PUT", "PUT /hello.txt HTTP/1.0\r\nContent-Length: 1\r\n\r\nx", "HTTP/1.0", 501, map[string]string{"Allow": "GET, HEAD, POST, PATCH, OPTIONS"}, "501 Not Implemented"},
+		{"unknown method", "BREW /pot HTTP/1.0\r\n\r\n", "HTTP/1.0", 501, nil, "501 Not Implemented"},
+		{"HTTP/1.1 without Host", "GET /hello.txt HTTP/1.1\r\n\r\n", "HTTP/1.1", 400, nil, "400 Bad Request"},
+		{"duplicate Host", "GET /hello.txt HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", "HTTP/1.0", 400, nil, "400 Bad Request\n"},
+		{"malformed request line", "GET\r\n\r\n", "HTTP/1.0", 400, nil, "400 Bad Request\n"},
+		{"HTTP/2.0", "GET /hello.txt HTTP/2.0\r\nHost: test\r\n\r\n", "HTTP/1.1", 505, nil, "505 HTTP Version Not Supported"},
+		{"Content-Length and Transfer-Encoding", "POST /a.txt HTTP/1.1\r\nHost: test\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", "HTTP/1.0", 400, nil, "400 Bad Request\n"},
+		{"Transfer-Encoding in HTTP/1.0", "POST /a.txt HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", "HTTP/1.0", 400, nil, "400 Bad Request\n"},
+		{"unsupported transfer coding", "POST /a.txt HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", "HTTP/1.0", 501, nil, "501 Not Implemented\n"},
+		{"obsolete line folding", "GET /hello.txt HTTP/1.1\r\nHost: test\r\nX-A: 1\r\n 2\r\n\r\n", "HTTP/1.0", 400, nil, "400 Bad Request\n"},
+		{"chunked upload", "POST /chunked.txt HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nnew\r\n0\r\n\r\n", "HTTP/1.1", 200, nil, "200 OK"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, body := rawExchange(t, addr, test.raw)
+			if res.Proto != test.proto || res.StatusCode != test.status || body != test.body {
+				t.Errorf("got %s %d %q, want %s %d %q", res.Proto, res.StatusCode, body, test.proto, test.status, test.body)
+			}
+			if res.ProtoAtLeast(1, 1) && !res.Close {
+				t.Error("HTTP/1.1 response without Connection: close")
+			}
+			for name, want := range test.header {
+				if got := res.Header.Get(name); got != want {
+					t.Errorf("got %s %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParsingModes(t *testing.T) {
+	raw := "GET /hello.txt HTTP/1.1\nHost: test\nX-A: 1\n 2\n\n"
+	for _, test := range []struct {
+		mode   parser.Mode
+		status int
+	}{{parser.Strict, 400}, {parser.Lenient, 200}} {
+		var root string
+		addr := startTestServer(t, func(s *Server) {
+			root = s.Root
+			s.Parsing = parser.Options{Mode: test.mode}
+		})
+		writeTestFile(t, filepath.Join(root, "hello.txt"), "hello world")
+		if res, _ := rawExchange(t, addr, raw); res.StatusCode != test.status {
+			t.Errorf("%v parsing: got %d, want %d", test.mode, res.StatusCode, test.status)
+		}
+	}
+
+	addr := startTestServer(t, func(s *Server) { s.Parsing = parser.Options{MaxHeaderBytes: 64} })
+	if res, _ := rawExchange(t, addr, "GET / HTTP/1.1\r\nHost: test\r\nX-A: "+strings.Repeat("a", 64)+"\r\n\r\n"); res.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("got %d, want 431 for a long header section", res.StatusCode)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "POST /upload.txt HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	interim, err := http.ReadResponse(r, nil)
+	if err != nil || interim.StatusCode != http.StatusContinue {
+		t.Fatalf("got %v, %v, want 100 Continue before the body is sent", interim, err)
+	}
+	io.WriteString(conn, "data")
+	res, err := http.ReadResponse(r, nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("got %v, %v, want 200 OK", res, err)
+	}
+}
+
+func TestFrameResponse(t *testing.T) {
+	tests := []struct {
+		name           string
+		req            *http.Request
+		status         int
+		length         int64
+		wantLength     int64
+		wantEncoding   []string
+		wantBodyRemove bool
+	}{
+		{"no content", &http.Request{Method: "GET", ProtoMajor: 1, ProtoMinor: 1}, 204, 4, -1, nil, true},
+		{"no content to PUT", &http.Request{Method: "PUT", ProtoMajor: 1, ProtoMinor: 1}, 204, 0, -1, nil, true},
+		{"not modified", &http.Request{Method: "GET", ProtoMajor: 1}, 304, 4, -1, nil, true},
+		{"unknown length HTTP/1.1", &http.Request{Method: "GET", ProtoMajor: 1, ProtoMinor: 1}, 200, -1, -1, []string{"chunked"}, false},
+		{"unknown length HTTP/1.0", &http.Request{Method: "GET", ProtoMajor: 1}, 200, -1, -1, nil, false},
+	}
+	for _, test := range tests {
+		res := &http.Response{StatusCode: test.status, ContentLength: test.length, Body: CreateBody("body"), TransferEncoding: []string{"chunked"}}
+		FrameResponse(test.req, res, Software)
+		if res.ContentLength != test.wantLength || strings.Join(res.TransferEncoding, ",") != strings.Join(test.wantEncoding, ",") || (res.Body == http.NoBody) != test.wantBodyRemove {
+			t.Errorf("%s: got length %d, encoding %v, body %v", test.name, res.ContentLength, res.TransferEncoding, res.Body)
+		}
+	}
+}
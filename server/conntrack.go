@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection states.
+const (
+	ConnReading  = "reading"
+	ConnHandling = "handling"
+	ConnWriting  = "writing"
+)
+
+// ConnTracker keeps track of the open connections so they can be listed and
+// closed individually.
+type ConnTracker struct {
+	mu    sync.Mutex
+	next  uint64
+	conns map[uint64]*TrackedConn
+}
+
+// NewConnTracker creates an empty connection tracker.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{conns: make(map[uint64]*TrackedConn)}
+}
+
+// TrackedConn is a connection registered with a ConnTracker. It counts the
+// bytes transferred and is removed from the tracker when closed.
+type TrackedConn struct {
+	net.Conn
+	ID      uint64
+	Started time.Time
+
+	tracker *ConnTracker
+	state   atomic.Value
+	request atomic.Value
+	read    atomic.Int64
+	written atomic.Int64
+	once    sync.Once
+}
+
+// Track registers conn and returns the wrapped connection.
+func (t *ConnTracker) Track(conn net.Conn) *TrackedConn {
+	tc := &TrackedConn{Conn: conn, Started: time.Now(), tracker: t}
+	tc.state.Store(ConnReading)
+	tc.request.Store("")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	tc.ID = t.next
+	t.conns[tc.ID] = tc
+	return tc
+}
+
+func (c *TrackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *TrackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Close closes the connection and removes it from the tracker.
+func (c *TrackedConn) Close() error {
+	c.once.Do(func() {
+		c.tracker.mu.Lock()
+		delete(c.tracker.conns, c.ID)
+		c.tracker.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// SetState records what the connection is doing and, once read, the request
+// it carries.
+func (c *TrackedConn) SetState(state string, req *http.Request) {
+	c.state.Store(state)
+	if req != nil {
+		c.request.Store(req.Method + " " + req.RequestURI)
+	}
+}
+
+// ConnInfo describes an open connection.
+type ConnInfo struct {
+	ID           uint64  `json:"id"`
+	RemoteAddr   string  `json:"remote_addr"`
+	AgeSeconds   float64 `json:"age_seconds"`
+	State        string  `json:"state"`
+	Request      string  `json:"request,omitempty"`
+	BytesRead    int64   `json:"bytes_read"`
+	BytesWritten int64   `json:"bytes_written"`
+}
+
+// List returns the open connections, oldest first.
+func (t *ConnTracker) List() []ConnInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		infos = append(infos, ConnInfo{
+			ID:           c.ID,
+			RemoteAddr:   c.RemoteAddr().String(),
+			AgeSeconds:   time.Since(c.Started).Seconds(),
+			State:        c.state.Load().(string),
+			Request:      c.request.Load().(string),
+			BytesRead:    c.read.Load(),
+			BytesWritten: c.written.Load(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// CloseConn force-closes the connection with the given ID and reports
+// whether it was open.
+func (t *ConnTracker) CloseConn(id uint64) bool {
+	t.mu.Lock()
+	c, ok := t.conns[id]
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// Handler serves the open connections as JSON to GET requests and closes
+// the connection given by the id query parameter on DELETE.
+func (t *ConnTracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(t.List())
+		case http.MethodDelete:
+			id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid connection id", http.StatusBadRequest)
+				return
+			}
+			if !t.CloseConn(id) {
+				http.Error(w, fmt.Sprintf("connection %d is not open", id), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// SetConnState updates the state of conn if it is tracked.
+func SetConnState(conn net.Conn, state string, req *http.Request) {
+	if tc, ok := conn.(*TrackedConn); ok {
+		tc.SetState(state, req)
+	}
+}
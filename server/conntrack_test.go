@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnTracker(t *testing.T) {
+	tracker := NewConnTracker()
+	addr := startTestServer(t, func(s *Server) { s.Conns = tracker })
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	// An incomplete request keeps the connection in the reading state.
+	fmt.Fprint(conn, "GET /a.txt HTTP/1.0\r\n")
+
+	var conns []ConnInfo
+	for i := 0; i < 50 && (len(conns) == 0 || conns[0].BytesRead == 0); i++ {
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		conns = tracker.List()
+	}
+	if len(conns) != 1 || conns[0].State != ConnReading || conns[0].BytesRead == 0 {
+		t.Fatalf("got connections %+v", conns)
+	}
+
+	rec := httptest.NewRecorder()
+	tracker.Handler().ServeHTTP(rec, httptest.NewRequest("DELETE", fmt.Sprintf("/connections?id=%d", conns[0].ID), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("close got status %d", rec.Code)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after close got %v, want EOF", err)
+	}
+	if conns := tracker.List(); len(conns) != 0 {
+		t.Errorf("closed connection still listed: %+v", conns)
+	}
+}
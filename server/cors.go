@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORSPolicy describes which cross-origin requests are allowed below Prefix.
+type CORSPolicy struct {
+	Prefix string `json:"prefix" toml:"prefix"`
+	// AllowedOrigins lists origins such as "https://example.com". "*" allows
+	// any origin and "https://*.example.com" any subdomain.
+	AllowedOrigins []string `json:"allowed_origins" toml:"allowed_origins"`
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string `json:"allowed_methods" toml:"allowed_methods"`
+	// AllowedHeaders lists request headers allowed in preflights, "*" for any.
+	AllowedHeaders   []string `json:"allowed_headers" toml:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers" toml:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials" toml:"allow_credentials"`
+	// MaxAge is how many seconds browsers may cache a preflight result.
+	MaxAge int `json:"max_age" toml:"max_age"`
+}
+
+// CORS applies the policy with the longest prefix matching each request.
+type CORS struct {
+	Policies []CORSPolicy
+}
+
+// IsPreflight reports whether req is a CORS preflight request.
+func IsPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Preflight adds the headers answering a preflight request to res and
+// reports whether the requested method and headers are allowed.
+func (c *CORS) Preflight(req *http.Request, res *http.Response) bool {
+	policy := c.policyFor(req)
+	if policy == nil {
+		return false
+	}
+	res.Header.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	origin := req.Header.Get("Origin")
+	if !policy.allowsOrigin(origin) || !policy.allowsMethod(req.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+
+	requested := splitHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+	for _, header := range requested {
+		if !policy.allowsHeader(header) {
+			return false
+		}
+	}
+
+	policy.setOrigin(res, origin)
+	res.Header.Set("Access-Control-Allow-Methods", strings.Join(policy.methods(), ", "))
+	if len(requested) > 0 {
+		res.Header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if policy.MaxAge > 0 {
+		res.Header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+	return true
+}
+
+// Apply adds the CORS headers for an actual (non-preflight) request to res.
+func (c *CORS) Apply(req *http.Request, res *http.Response) {
+	origin := req.Header.Get("Origin")
+	policy := c.policyFor(req)
+	if origin == "" || policy == nil {
+		return
+	}
+
+	res.Header.Add("Vary", "Origin")
+	if !policy.allowsOrigin(origin) {
+		return
+	}
+
+	policy.setOrigin(res, origin)
+	if len(policy.ExposedHeaders) > 0 {
+		res.Header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+	}
+}
+
+// policyFor returns the policy with the longest prefix matching req, or nil.
+func (c *CORS) policyFor(req *http.Request) *CORSPolicy {
+	var match *CORSPolicy
+	for i := range c.Policies {
+		policy := &c.Policies[i]
+		if hasPathPrefix(req.URL.Path, policy.Prefix) && (match == nil || len(policy.Prefix) > len(match.Prefix)) {
+			match = policy
+		}
+	}
+	return match
+}
+
+// setOrigin sets the allowed origin, echoing it back when credentials are
+// allowed since browsers reject "*" for credentialed requests. Origins only
+// admitted by "*" never get credentials, which would let any site read them.
+func (p *CORSPolicy) setOrigin(res *http.Response, origin string) {
+	if p.AllowCredentials && p.listsOrigin(origin) {
+		res.Header.Set("Access-Control-Allow-Origin", origin)
+		res.Header.Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+
+	if slices.Contains(p.AllowedOrigins, "*") {
+		res.Header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	res.Header.Set("Access-Control-Allow-Origin", origin)
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	return slices.Contains(p.AllowedOrigins, "*") || p.listsOrigin(origin)
+}
+
+// listsOrigin reports whether origin is allowed by an entry other than "*".
+func (p *CORSPolicy) listsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		// "https://*.example.com" matches any subdomain of example.com.
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) methods() []string {
+	if len(p.AllowedMethods) == 0 {
+		return []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	return p.AllowedMethods
+}
+
+func (p *CORSPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.methods() {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsHeader(header string) bool {
+	for _, allowed := range p.AllowedHeaders {
+		if allowed == "*" || strings.EqualFold(allowed, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaderList splits a comma separated header value into its trimmed elements.
+func splitHeaderList(value string) []string {
+	var elements []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.CORS = &CORS{Policies: []CORSPolicy{
+			{Prefix: "/", AllowedOrigins: []string{"*"}},
+			{
+				Prefix:           "/api",
+				AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+				AllowedMethods:   []string{"GET", "POST"},
+				AllowedHeaders:   []string{"Content-Type"},
+				AllowCredentials: true,
+				MaxAge:           600,
+			},
+			{Prefix: "/shared", AllowedOrigins: []string{"*", "https://app.example.com"}, AllowCredentials: true},
+		}}
+		s.SecurityHeaders = DefaultSecurityHeaders()
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		headers     map[string]string
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{
+			name: "Preflight allowed", method: "OPTIONS", path: "/api/a.txt",
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "content-type",
+			},
+			wantStatus: 204,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, POST",
+				"Access-Control-Allow-Headers":     "content-type",
+				"Access-Control-Max-Age":           "600",
+			},
+		},
+		{
+			name: "Preflight wildcard subdomain", method: "OPTIONS", path: "/api/a.txt",
+			headers: map[string]string{
+				"Origin":                        "https://files.example.org",
+				"Access-Control-Request-Method": "GET",
+			},
+			wantStatus:  204,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "https://files.example.org"},
+		},
+		{
+			name: "Preflight unknown origin", method: "OPTIONS", path: "/api/a.txt",
+			headers: map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			wantStatus:  403,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "Preflight disallowed method", method: "OPTIONS", path: "/api/a.txt",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			wantStatus: 403,
+		},
+		{
+			name: "Simple request any origin", method: "GET", path: "/a.txt",
+			headers:     map[string]string{"Origin": "https://other.example.net"},
+			wantStatus:  404,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		{
+			name: "Credentials for listed origin", method: "GET", path: "/shared/a.txt",
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: 404,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name: "No credentials for wildcard origin", method: "GET", path: "/shared/a.txt",
+			headers:    map[string]string{"Origin": "https://evil.example.net"},
+			wantStatus: 404,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name: "Security headers", method: "GET", path: "/a.txt",
+			wantStatus: 404,
+			wantHeaders: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+				"Strict-Transport-Security": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+tt.path, nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.wantStatus)
+		}
+		for name, want := range tt.wantHeaders {
+			if got := res.Header.Get(name); got != want {
+				t.Errorf("%s: got %s %q, want %q", tt.name, name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supportedLock lists the lock kinds in the supportedlock property.
+const supportedLock = "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+	"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"
+
+// davLock is a write lock on a resource and, if infinite, its members.
+type davLock struct {
+	token    string
+	name     string // file of the locked resource
+	href     string
+	infinite bool
+	shared   bool
+	owner    string // raw XML
+	expires  time.Time
+}
+
+// covers reports whether the lock applies to the resource at name.
+func (l *davLock) covers(name string) bool {
+	return l.name == name || l.infinite && isWithin(name, l.name)
+}
+
+// expire removes the locks that timed out. w.mu must be held.
+func (w *WebDAV) expire() {
+	now := time.Now()
+	for token, l := range w.locks {
+		if now.After(l.expires) {
+			delete(w.locks, token)
+		}
+	}
+}
+
+// acquire adds l with a new token unless it conflicts with a lock held, which
+// is returned instead: exclusive locks conflict with all others, shared ones
+// with exclusive ones.
+func (w *WebDAV) acquire(l davLock, timeout time.Duration) (davLock, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire()
+	for _, held := range w.locks {
+		if (held.covers(l.name) || l.infinite && isWithin(held.name, l.name)) && !(l.shared && held.shared) {
+			return *held, false
+		}
+	}
+	l.token, l.expires = newLockToken(), time.Now().Add(timeout)
+	if w.locks == nil {
+		w.locks = make(map[string]*davLock)
+	}
+	w.locks[l.token] = &l
+	return l, true
+}
+
+// refresh restarts the timeout of the lock on name whose token is in tokens.
+func (w *WebDAV) refresh(name string, tokens []string, timeout time.Duration) (davLock, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire()
+	for _, token := range tokens {
+		if l := w.locks[token]; l != nil && l.covers(name) {
+			l.expires = time.Now().Add(timeout)
+			return *l, true
+		}
+	}
+	return davLock{}, false
+}
+
+// unlock removes the lock with token if it applies to name.
+func (w *WebDAV) unlock(name, token string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire()
+	if l := w.locks[token]; l != nil && l.covers(name) {
+		delete(w.locks, token)
+		return true
+	}
+	return false
+}
+
+// release removes the locks on name and its members, which were deleted or
+// moved away.
+func (w *WebDAV) release(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for token, l := range w.locks {
+		if l.name == name || isWithin(l.name, name) {
+			delete(w.locks, token)
+		}
+	}
+}
+
+// holds reports whether the lock with token applies to name.
+func (w *WebDAV) holds(name, token string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire()
+	l := w.locks[token]
+	return l != nil && l.covers(name)
+}
+
+// blocking returns a lock protecting the resource at name whose token is
+// not in tokens. subtree extends the check to the resource's members and
+// membership to the collection containing it, for requests that add or
+// remove it.
+func (w *WebDAV) blocking(name string, subtree, membership bool, tokens []string) (davLock, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire()
+	for _, l := range w.locks {
+		applies := l.covers(name) || subtree && isWithin(l.name, name) || membership && l.name == filepath.Dir(name)
+		if applies && !slices.Contains(tokens, l.token) {
+			return *l, true
+		}
+	}
+	return davLock{}, false
+}
+
+// discovery returns the lockdiscovery property of the resource at name.
+func (w *WebDAV) discovery(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire()
+	var b strings.Builder
+	for _, l := range w.locks {
+		if l.covers(name) {
+			writeActiveLock(&b, *l)
+		}
+	}
+	return b.String()
+}
+
+// davUnlocked reports whether the request may modify the resource at name,
+// answering 423 Locked if a lock whose token it did not submit protects it.
+// subtree and membership are passed on to WebDAV.blocking.
+func (s *Server) davUnlocked(req *http.Request, res *http.Response, name string, subtree, membership bool) bool {
+	lock, blocked := s.WebDAV.blocking(name, subtree, membership, ifTokens(req.Header.Get("If")))
+	if !blocked {
+		return true
+	}
+	LoggerFromRequest(req).Info("Rejected request to locked resource", "method", req.Method, "lock_root", lock.href)
+	davStatus(res, http.StatusLocked)
+	return false
+}
+
+// handleLock locks a resource, creating an empty file if it does not exist,
+// or refreshes a lock if the request has no body.
+func (s *Server) handleLock(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	timeout := lockTimeout(req.Header.Get("Timeout"), s.WebDAV.MaxLockTimeout)
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxDAVBody))
+	if err != nil {
+		s.HandleBadRequest(res)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		// Refreshing a lock, RFC 4918 section 9.10.2.
+		lock, ok := s.WebDAV.refresh(name, ifTokens(req.Header.Get("If")), timeout)
+		if !ok {
+			davStatus(res, http.StatusPreconditionFailed)
+			return
+		}
+		writeLockDiscovery(res, http.StatusOK, lock)
+		return
+	}
+
+	var info struct {
+		XMLName   xml.Name `xml:"DAV: lockinfo"`
+		LockScope struct {
+			Exclusive *struct{} `xml:"DAV: exclusive"`
+			Shared    *struct{} `xml:"DAV: shared"`
+		} `xml:"DAV: lockscope"`
+		LockType struct {
+			Write *struct{} `xml:"DAV: write"`
+		} `xml:"DAV: locktype"`
+		Owner *struct {
+			Inner string `xml:",innerxml"`
+		} `xml:"DAV: owner"`
+	}
+	depth := req.Header.Get("Depth")
+	if err := xml.Unmarshal(body, &info); err != nil || info.LockType.Write == nil || (info.LockScope.Exclusive == nil) == (info.LockScope.Shared == nil) || depth != "" && depth != "0" && depth != "infinity" {
+		LoggerFromRequest(req).Info("Rejected LOCK request", "err", err)
+		s.HandleBadRequest(res)
+		return
+	}
+
+	stat, statErr := os.Stat(name)
+	if statErr != nil && !parentExists(name) {
+		davStatus(res, http.StatusConflict)
+		return
+	}
+	if statErr != nil && !s.davUnlocked(req, res, name, false, true) {
+		return
+	}
+	lock := davLock{name: name, href: davHref(req.URL.Path, statErr == nil && stat.IsDir()), infinite: depth != "0", shared: info.LockScope.Shared != nil}
+	if info.Owner != nil {
+		lock.owner = info.Owner.Inner
+	}
+	lock, ok := s.WebDAV.acquire(lock, timeout)
+	if !ok {
+		LoggerFromRequest(req).Info("Rejected conflicting lock", "lock_root", lock.href)
+		davStatus(res, http.StatusLocked)
+		return
+	}
+
+	code := http.StatusOK
+	if statErr != nil {
+		// Locking an unmapped URL creates an empty resource, RFC 4918
+		// section 9.10.4.
+		if err := WriteFile(name, nil); err != nil {
+			s.WebDAV.unlock(name, lock.token)
+			LoggerFromRequest(req).Error("Error creating locked resource", "file", name, "err", err)
+			s.HandleInternalServerError(res)
+			return
+		}
+		code = http.StatusCreated
+	}
+	res.Header.Set("Lock-Token", "<"+lock.token+">")
+	writeLockDiscovery(res, code, lock)
+}
+
+// handleUnlock removes the lock named in the Lock-Token header.
+func (s *Server) handleUnlock(req *http.Request, res *http.Response) {
+	token := strings.TrimSpace(req.Header.Get("Lock-Token"))
+	if len(token) < 2 || token[0] != '<' || token[len(token)-1] != '>' {
+		s.HandleBadRequest(res)
+		return
+	}
+	if !s.WebDAV.unlock(s.localPath(req.URL.Path), token[1:len(token)-1]) {
+		davStatus(res, http.StatusConflict)
+		return
+	}
+	s.HandleNoContent(res)
+}
+
+// writeLockDiscovery makes res the response to a LOCK request.
+func writeLockDiscovery(res *http.Response, code int, lock davLock) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`)
+	writeActiveLock(&b, lock)
+	b.WriteString("</D:lockdiscovery></D:prop>\n")
+	res.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	res.StatusCode = code
+	res.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	res.Body = io.NopCloser(strings.NewReader(b.String()))
+}
+
+func writeActiveLock(b *strings.Builder, l davLock) {
+	scope, depth := "exclusive", "0"
+	if l.shared {
+		scope = "shared"
+	}
+	if l.infinite {
+		depth = "infinity"
+	}
+	fmt.Fprintf(b, "<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:%s/></D:lockscope><D:depth>%s</D:depth>", scope, depth)
+	if l.owner != "" {
+		fmt.Fprintf(b, "<D:owner>%s</D:owner>", l.owner)
+	}
+	seconds := int64(time.Until(l.expires).Round(time.Second) / time.Second)
+	fmt.Fprintf(b, "<D:timeout>Second-%d</D:timeout><D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>%s</D:href></D:lockroot></D:activelock>",
+		max(seconds, 0), xmlEscape(l.token), xmlEscape(l.href))
+}
+
+// lockTimeout returns the first timeout in a Timeout header, capped to
+// limit, and limit if there is none.
+func lockTimeout(header string, limit time.Duration) time.Duration {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "Infinite" {
+			return limit
+		}
+		if n, err := strconv.ParseInt(strings.TrimPrefix(value, "Second-"), 10, 64); err == nil && strings.HasPrefix(value, "Second-") && n > 0 {
+			return min(time.Duration(n)*time.Second, limit)
+		}
+	}
+	return limit
+}
+
+// newLockToken returns a lock token URI with a random UUID.
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ifCondition is a condition of an If header: a state token or an entity
+// tag, possibly negated.
+type ifCondition struct {
+	not         bool
+	token, etag string
+}
+
+// ifList is a list of conditions that must all hold, for the resource
+// tagged before it or, if untagged, the requested one.
+type ifList struct {
+	resource string
+	conds    []ifCondition
+}
+
+// parseIf parses an If header, RFC 4918 section 10.4.
+func parseIf(header string) ([]ifList, bool) {
+	var lists []ifList
+	resource := ""
+	for h := strings.TrimSpace(header); h != ""; h = strings.TrimSpace(h) {
+		switch h[0] {
+		case '<':
+			end := strings.IndexByte(h, '>')
+			if end < 0 {
+				return nil, false
+			}
+			resource, h = h[1:end], h[end+1:]
+		case '(':
+			end := strings.IndexByte(h, ')')
+			if end < 0 {
+				return nil, false
+			}
+			list := ifList{resource: resource}
+			for c := strings.TrimSpace(h[1:end]); c != ""; c = strings.TrimSpace(c) {
+				var cond ifCondition
+				if strings.HasPrefix(c, "Not") {
+					cond.not, c = true, strings.TrimSpace(c[3:])
+				}
+				var closing byte
+				switch {
+				case strings.HasPrefix(c, "<"):
+					closing = '>'
+				case strings.HasPrefix(c, "["):
+					closing = ']'
+				default:
+					return nil, false
+				}
+				i := strings.IndexByte(c, closing)
+				if i < 0 {
+					return nil, false
+				}
+				if closing == '>' {
+					cond.token = c[1:i]
+				} else {
+					cond.etag = c[1:i]
+				}
+				list.conds = append(list.conds, cond)
+				c = c[i+1:]
+			}
+			if len(list.conds) == 0 {
+				return nil, false
+			}
+			lists, h = append(lists, list), h[end+1:]
+		default:
+			return nil, false
+		}
+	}
+	return lists, len(lists) > 0
+}
+
+// ifTokens returns the lock tokens submitted in an If header.
+func ifTokens(header string) []string {
+	lists, _ := parseIf(header)
+	var tokens []string
+	for _, list := range lists {
+		for _, cond := range list.conds {
+			if cond.token != "" && !cond.not {
+				tokens = append(tokens, cond.token)
+			}
+		}
+	}
+	return tokens
+}
+
+// davPrecondition evaluates the If header of req, answering 412
+// Precondition Failed if none of its lists hold and 400 Bad Request if it is
+// malformed.
+func (s *Server) davPrecondition(req *http.Request, res *http.Response) bool {
+	header := req.Header.Get("If")
+	if header == "" {
+		return true
+	}
+	lists, ok := parseIf(header)
+	if !ok {
+		s.HandleBadRequest(res)
+		return false
+	}
+	for _, list := range lists {
+		resource := req.URL.Path
+		if list.resource != "" {
+			u, err := url.Parse(list.resource)
+			if err != nil {
+				continue
+			}
+			resource = u.Path
+		}
+		if s.ifListHolds(s.localPath(resource), list) {
+			return true
+		}
+	}
+	davStatus(res, http.StatusPreconditionFailed)
+	return false
+}
+
+// ifListHolds reports whether all conditions of list hold for the resource
+// at name.
+func (s *Server) ifListHolds(name string, list ifList) bool {
+	for _, cond := range list.conds {
+		var holds bool
+		if cond.token != "" {
+			holds = s.WebDAV.holds(name, cond.token)
+		} else if info, err := os.Stat(name); err == nil {
+			holds = fileETag(info) == cond.etag
+		}
+		if holds == cond.not {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// davPropsName prefixes the sidecar files holding dead properties. A
+// collection's are stored inside it, a file's next to it with its name
+// appended.
+const davPropsName = ".davprops"
+
+// propsFile returns the sidecar file of the resource at name.
+func propsFile(name string, dir bool) string {
+	if dir {
+		return filepath.Join(name, davPropsName)
+	}
+	return filepath.Join(filepath.Dir(name), davPropsName+"."+filepath.Base(name))
+}
+
+// isSidecar reports whether the request path p names a sidecar file or
+// something inside one, which clients may not access.
+func isSidecar(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, davPropsName) {
+			return true
+		}
+	}
+	return false
+}
+
+// deadProp is a property stored for clients. Value is its content as raw
+// XML.
+type deadProp struct {
+	Space string `json:"space"`
+	Local string `json:"local"`
+	Value string `json:"value"`
+}
+
+func (p deadProp) name() xml.Name {
+	return xml.Name{Space: p.Space, Local: p.Local}
+}
+
+// loadProps reads the dead properties of the resource at name.
+func loadProps(name string, dir bool) ([]deadProp, error) {
+	data, err := os.ReadFile(propsFile(name, dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var props []deadProp
+	if err := json.Unmarshal(data, &props); err != nil {
+		return nil, fmt.Errorf("invalid properties file: %v", err)
+	}
+	return props, nil
+}
+
+// saveProps replaces the dead properties of the resource at name.
+func saveProps(name string, dir bool, props []deadProp) error {
+	file := propsFile(name, dir)
+	if len(props) == 0 {
+		return ignoreNotExist(os.Remove(file))
+	}
+	data, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0666)
+}
+
+// propUpdate sets or removes a dead property.
+type propUpdate struct {
+	remove bool
+	prop   deadProp
+}
+
+// apply returns props with the update made.
+func (u propUpdate) apply(props []deadProp) []deadProp {
+	i := slices.IndexFunc(props, func(p deadProp) bool { return p.name() == u.prop.name() })
+	switch {
+	case u.remove && i >= 0:
+		return slices.Delete(props, i, i+1)
+	case u.remove:
+		return props
+	case i >= 0:
+		props[i] = u.prop
+		return props
+	}
+	return append(props, u.prop)
+}
+
+func davName(local string) xml.Name {
+	return xml.Name{Space: "DAV:", Local: local}
+}
+
+// propRequest is what a PROPFIND asks for: all properties, only their names
+// or the listed ones.
+type propRequest struct {
+	all, names bool
+	props      []xml.Name
+}
+
+// sel groups the requested properties out of props by status: found ones
+// with 200 OK and missing ones with 404 Not Found.
+func (pr propRequest) sel(props []propValue) map[int][]propValue {
+	result := make(map[int][]propValue)
+	switch {
+	case pr.all:
+		result[http.StatusOK] = props
+	case pr.names:
+		for _, p := range props {
+			result[http.StatusOK] = append(result[http.StatusOK], propValue{name: p.name})
+		}
+	default:
+		for _, name := range pr.props {
+			i := slices.IndexFunc(props, func(p propValue) bool { return p.name == name })
+			if i < 0 {
+				result[http.StatusNotFound] = append(result[http.StatusNotFound], propValue{name: name})
+				continue
+			}
+			result[http.StatusOK] = append(result[http.StatusOK], props[i])
+		}
+	}
+	return result
+}
+
+// parsePropfind parses the body of a PROPFIND request. An empty body asks
+// for all properties, RFC 4918 section 9.1.
+func parsePropfind(r io.Reader) (propRequest, error) {
+	d := xml.NewDecoder(r)
+	root, err := rootElement(d)
+	if err == io.EOF {
+		return propRequest{all: true}, nil
+	}
+	if err != nil {
+		return propRequest{}, err
+	}
+	if root.Name != davName("propfind") {
+		return propRequest{}, fmt.Errorf("expected propfind, got %s", root.Name.Local)
+	}
+
+	var pr propRequest
+	err = eachChild(d, func(el xml.StartElement) error {
+		switch el.Name {
+		case davName("allprop"):
+			pr.all = true
+		case davName("propname"):
+			pr.names = true
+		case davName("prop"):
+			return eachChild(d, func(prop xml.StartElement) error {
+				pr.props = append(pr.props, prop.Name)
+				return d.Skip()
+			})
+		}
+		return d.Skip()
+	})
+	if err == nil && !pr.all && !pr.names && len(pr.props) == 0 {
+		err = errors.New("propfind asks for no properties")
+	}
+	return pr, err
+}
+
+// parsePropertyUpdate parses the body of a PROPPATCH request into updates
+// in document order.
+func parsePropertyUpdate(r io.Reader) ([]propUpdate, error) {
+	d := xml.NewDecoder(r)
+	root, err := rootElement(d)
+	if err == io.EOF {
+		return nil, errors.New("empty propertyupdate")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root.Name != davName("propertyupdate") {
+		return nil, fmt.Errorf("expected propertyupdate, got %s", root.Name.Local)
+	}
+
+	var updates []propUpdate
+	err = eachChild(d, func(op xml.StartElement) error {
+		remove := op.Name == davName("remove")
+		if !remove && op.Name != davName("set") {
+			return d.Skip()
+		}
+		return eachChild(d, func(prop xml.StartElement) error {
+			if prop.Name != davName("prop") {
+				return d.Skip()
+			}
+			return eachChild(d, func(el xml.StartElement) error {
+				var value struct {
+					Inner string `xml:",innerxml"`
+				}
+				if err := d.DecodeElement(&value, &el); err != nil {
+					return err
+				}
+				u := propUpdate{remove: remove, prop: deadProp{Space: el.Name.Space, Local: el.Name.Local}}
+				if !remove {
+					u.prop.Value = value.Inner
+				}
+				updates = append(updates, u)
+				return nil
+			})
+		})
+	})
+	if err == nil && len(updates) == 0 {
+		err = errors.New("propertyupdate changes no properties")
+	}
+	return updates, err
+}
+
+// rootElement reads up to the start of the document's root element. It
+// returns io.EOF for an empty document.
+func rootElement(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// eachChild calls fn for the child elements of the element whose start was
+// last read from d, up to its end. fn must consume the child, e.g. with
+// d.Skip.
+func eachChild(d *xml.Decoder, fn func(xml.StartElement) error) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := fn(t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// propValue is a property with its content as XML.
+type propValue struct {
+	name  xml.Name
+	inner string
+}
+
+// multistatus builds a 207 Multi-Status response, RFC 4918 section 13.
+type multistatus struct {
+	b strings.Builder
+}
+
+func newMultistatus() *multistatus {
+	m := &multistatus{}
+	m.b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">` + "\n")
+	return m
+}
+
+// add adds the response for the resource at href with its properties
+// grouped by status.
+func (m *multistatus) add(href string, props map[int][]propValue) {
+	fmt.Fprintf(&m.b, "<D:response><D:href>%s</D:href>", xmlEscape(href))
+	codes := make([]int, 0, len(props))
+	for code := range props {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		m.b.WriteString("<D:propstat><D:prop>")
+		for _, p := range props[code] {
+			writeProp(&m.b, p)
+		}
+		fmt.Fprintf(&m.b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>", code, http.StatusText(code))
+	}
+	m.b.WriteString("</D:response>\n")
+}
+
+// write makes res the multistatus response.
+func (m *multistatus) write(res *http.Response) {
+	m.b.WriteString("</D:multistatus>\n")
+	res.Status = "207 Multi-Status"
+	res.StatusCode = http.StatusMultiStatus
+	res.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	res.Body = io.NopCloser(strings.NewReader(m.b.String()))
+}
+
+// writeProp writes a property element, declaring its namespace on it.
+func writeProp(b *strings.Builder, p propValue) {
+	tag, attr := "D:"+p.name.Local, ""
+	switch p.name.Space {
+	case "DAV:":
+	case "":
+		tag, attr = p.name.Local, ` xmlns=""`
+	default:
+		tag, attr = "R:"+p.name.Local, ` xmlns:R="`+xmlEscape(p.name.Space)+`"`
+	}
+	if p.inner == "" {
+		fmt.Fprintf(b, "<%s%s/>", tag, attr)
+		return
+	}
+	fmt.Fprintf(b, "<%s%s>%s</%s>", tag, attr, p.inner, tag)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HandleDirectory serves a GET request for a directory: its index file if
+// the server has one, a listing if listings are enabled and 403 Forbidden
+// otherwise.
+func (s *Server) HandleDirectory(req *http.Request, res *http.Response) {
+	dir := s.localPath(req.URL.Path)
+	if s.Index != "" {
+		if info, err := os.Stat(filepath.Join(dir, s.Index)); err == nil && !info.IsDir() {
+			index := req.Clone(req.Context())
+			index.URL.Path = path.Join(req.URL.Path, s.Index)
+			s.HandleGet(index, res)
+			return
+		}
+	}
+	if !s.Listing {
+		s.HandleForbidden(res)
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		LoggerFromRequest(req).Error("Error reading directory", "dir", dir, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	title := html.EscapeString(path.Clean("/" + req.URL.Path))
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: path.Join("/", req.URL.Path, name)}).EscapedPath()
+		if entry.IsDir() {
+			href += "/"
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	res.Header.Set("Content-Type", "text/html")
+	res.Body = CreateBody(b.String())
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Content types error bodies are negotiated between.
+const (
+	contentTypeText    = "text/plain; charset=utf-8"
+	contentTypeHTML    = "text/html; charset=utf-8"
+	contentTypeProblem = "application/problem+json"
+)
+
+// ErrorPage is a custom document sent with error responses.
+type ErrorPage struct {
+	// Status is the status code the page is sent with, e.g. "404", or a
+	// class of them: "4xx" or "5xx".
+	Status string `json:"status" toml:"status"`
+	// Prefix, when set, limits the page to requests for paths under it.
+	Prefix string `json:"prefix" toml:"prefix"`
+	// File is the document, a template executed with ErrorData. Its content
+	// type follows from its extension, and HTML is escaped.
+	File string `json:"file" toml:"file"`
+}
+
+// ErrorData is what error page templates are executed with.
+type ErrorData struct {
+	Status    int
+	Title     string
+	Detail    string
+	Method    string
+	Path      string
+	RequestID string
+}
+
+// errorTemplate is a parsed html/template or text/template.
+type errorTemplate interface {
+	Execute(w io.Writer, data any) error
+}
+
+// errorPage is a loaded error page.
+type errorPage struct {
+	ErrorPage
+	contentType string
+	template    errorTemplate
+}
+
+// matches reports whether the page is sent with status for path.
+func (p *errorPage) matches(status int, path string) bool {
+	if p.Prefix != "" && !hasPathPrefix(path, p.Prefix) {
+		return false
+	}
+	if class, ok := strings.CutSuffix(p.Status, "xx"); ok {
+		return class == strconv.Itoa(status/100)
+	}
+	return p.Status == strconv.Itoa(status)
+}
+
+// ErrorPages holds the custom documents sent with error responses.
+type ErrorPages struct {
+	pages []*errorPage
+}
+
+// LoadErrorPages reads and parses the documents of pages.
+func LoadErrorPages(pages []ErrorPage) (*ErrorPages, error) {
+	e := &ErrorPages{}
+	for _, page := range pages {
+		if err := CheckErrorPage(page); err != nil {
+			return nil, err
+		}
+		text, err := os.ReadFile(page.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error page: %v", err)
+		}
+		loaded := &errorPage{ErrorPage: page, contentType: mime.TypeByExtension(filepath.Ext(page.File))}
+		if loaded.contentType == "" {
+			loaded.contentType = contentTypeText
+		}
+		name := filepath.Base(page.File)
+		if strings.HasPrefix(loaded.contentType, "text/html") {
+			loaded.template, err = htmltemplate.New(name).Parse(string(text))
+		} else {
+			loaded.template, err = texttemplate.New(name).Parse(string(text))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid error page %s: %v", page.File, err)
+		}
+		e.pages = append(e.pages, loaded)
+	}
+	return e, nil
+}
+
+// CheckErrorPage verifies the settings of page, not its document.
+func CheckErrorPage(page ErrorPage) error {
+	if page.File == "" {
+		return errors.New("error page file must be set")
+	}
+	if page.Prefix != "" && !strings.HasPrefix(page.Prefix, "/") {
+		return fmt.Errorf("error page prefix %q must start with /", page.Prefix)
+	}
+	if class, ok := strings.CutSuffix(page.Status, "xx"); ok {
+		if class == "4" || class == "5" {
+			return nil
+		}
+	} else if code, err := strconv.Atoi(page.Status); err == nil && code >= 400 && code <= 599 {
+		return nil
+	}
+	return fmt.Errorf("invalid error page status %q, expected a code from 400 to 599, 4xx or 5xx", page.Status)
+}
+
+// page returns the page sent with status for path: the one with the longest
+// prefix, preferring exact codes over classes. It returns nil if none
+// matches.
+func (e *ErrorPages) page(status int, path string) *errorPage {
+	var best *errorPage
+	for _, page := range e.pages {
+		if !page.matches(status, path) {
+			continue
+		}
+		if best == nil || len(page.Prefix) > len(best.Prefix) ||
+			len(page.Prefix) == len(best.Prefix) && !strings.HasSuffix(page.Status, "xx") && strings.HasSuffix(best.Status, "xx") {
+			best = page
+		}
+	}
+	return best
+}
+
+// Render replaces the body of the error response res to req with the one
+// req accepts: plain text, HTML or problem details (RFC 9457). A matching
+// custom page is preferred when it is acceptable. e may be nil, leaving
+// only the built-in bodies. Requests without an Accept header get the custom
+// page, if any, or keep the plain text body.
+func (e *ErrorPages) Render(req *http.Request, res *http.Response) {
+	if res.StatusCode < 400 {
+		return
+	}
+	var page *errorPage
+	if e != nil {
+		page = e.page(res.StatusCode, req.URL.Path)
+	}
+
+	// The handlers' bodies repeat the status line unless they carry more.
+	var text []byte
+	if res.Body != nil {
+		text, _ = io.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+	}
+	detail := strings.TrimSpace(string(text))
+	if detail == strings.TrimSpace(res.Status) || detail == strconv.Itoa(res.StatusCode) {
+		detail = ""
+	}
+	data := ErrorData{
+		Status:    res.StatusCode,
+		Title:     http.StatusText(res.StatusCode),
+		Detail:    detail,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		RequestID: req.Header.Get("X-Request-ID"),
+	}
+	if data.Title == "" {
+		data.Title = strings.TrimSpace(strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)))
+	}
+
+	offers := []string{contentTypeText, contentTypeHTML, contentTypeProblem}
+	if page != nil {
+		offers = append([]string{page.contentType}, offers...)
+	}
+	contentType := negotiate(req.Header.Get("Accept"), offers)
+
+	var body []byte
+	switch {
+	case page != nil && contentType == page.contentType:
+		var buf bytes.Buffer
+		if err := page.template.Execute(&buf, data); err != nil {
+			LoggerFromRequest(req).Warn("Error rendering error page", "file", page.File, "err", err)
+			contentType, body = contentTypeText, textError(data)
+			break
+		}
+		body = buf.Bytes()
+	case contentType == contentTypeHTML:
+		body = htmlError(data)
+	case contentType == contentTypeProblem:
+		body = problemError(data)
+	case len(text) > 0:
+		body = text
+	default:
+		body = textError(data)
+	}
+	res.Header.Set("Content-Type", contentType)
+	res.ContentLength = int64(len(body))
+	res.Body = io.NopCloser(bytes.NewReader(body))
+}
+
+// textError returns the plain text body of an error without one, the status
+// line followed by the detail, if any.
+func textError(data ErrorData) []byte {
+	text := fmt.Sprintf("%d %s\n", data.Status, data.Title)
+	if data.Detail != "" {
+		text += data.Detail + "\n"
+	}
+	return []byte(text)
+}
+
+// htmlError returns the HTML body of an error.
+func htmlError(data ErrorData) []byte {
+	var buf bytes.Buffer
+	defaultErrorPage.Execute(&buf, data)
+	return buf.Bytes()
+}
+
+var defaultErrorPage = htmltemplate.Must(htmltemplate.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.Title}}</title></head>
+<body>
+<h1>{{.Status}} {{.Title}}</h1>
+{{if .Detail}}<p>{{.Detail}}</p>
+{{end}}{{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>
+{{end}}</body>
+</html>
+`))
+
+// problemError returns the problem details (RFC 9457) of an error.
+func problemError(data ErrorData) []byte {
+	problem := struct {
+		Type      string `json:"type"`
+		Title     string `json:"title"`
+		Status    int    `json:"status"`
+		Detail    string `json:"detail,omitempty"`
+		Instance  string `json:"instance,omitempty"`
+		RequestID string `json:"request_id,omitempty"`
+	}{"about:blank", data.Title, data.Status, data.Detail, data.Path, data.RequestID}
+	body, _ := json.Marshal(problem)
+	return append(body, '\n')
+}
+
+// negotiate returns the offer accept prefers, the first of the most preferred
+// ones on a tie. Without an Accept header, or if none is acceptable, it
+// returns the first offer: error responses are sent either way. A client
+// accepting application/json accepts problem details.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality accept gives offer, taken from the most
+// specific media range matching it.
+func acceptQuality(accept, offer string) float64 {
+	offerType, _, _ := mime.ParseMediaType(offer)
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := rangeSpecificity(mediaRange, offerType)
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// rangeSpecificity returns how specifically mediaRange matches offer, -1 if
+// it does not.
+func rangeSpecificity(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 3
+	case mediaRange == "application/json" && offer == contentTypeProblem:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	}
+	major, _, _ := strings.Cut(offer, "/")
+	if mediaRange == major+"/*" {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "404.html"), `<p>{{.Path}} was not found ({{.RequestID}})</p>`)
+	writeTestFile(t, filepath.Join(dir, "4xx.html"), `<p>client error {{.Status}}</p>`)
+	writeTestFile(t, filepath.Join(dir, "api.txt"), `{{.Status}} {{.Title}}: {{.Path}}`)
+	pages, err := LoadErrorPages([]ErrorPage{
+		{Status: "4xx", File: filepath.Join(dir, "4xx.html")},
+		{Status: "404", File: filepath.Join(dir, "404.html")},
+		{Status: "4xx", Prefix: "/api", File: filepath.Join(dir, "api.txt")},
+	})
+	if err != nil {
+		t.Fatalf("failed to load error pages: %v", err)
+	}
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.ErrorPages = pages
+	})
+	writeTestFile(t, filepath.Join(root, "file.exe"), "binary")
+
+	tests := []struct {
+		name, path, accept string
+		status             int
+		contentType, body  string
+	}{
+		{"exact status", "/missing<b>.txt", "text/html", 404, "text/html; charset=utf-8", "<p>/missing&lt;b&gt;.txt was not found (req-1)</p>"},
+		{"status class", "/file.exe", "", 403, "text/html; charset=utf-8", "<p>client error 403</p>"},
+		{"longest prefix", "/api/missing.txt", "", 404, "text/plain; charset=utf-8", "404 Not Found: /api/missing.txt"},
+		{"problem details", "/missing.txt", "application/json", 404, "application/problem+json", ""},
+		{"unacceptable page", "/api/missing.txt", "text/html", 404, "text/html; charset=utf-8", "<h1>404 Not Found</h1>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://"+addr+test.path, nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != test.status || res.Header.Get("Content-Type") != test.contentType || !strings.Contains(string(body), test.body) {
+				t.Errorf("got %d, %q, body %q, want %d, %q, %q", res.StatusCode, res.Header.Get("Content-Type"), body, test.status, test.contentType, test.body)
+			}
+			if test.contentType != "application/problem+json" {
+				return
+			}
+			var problem map[string]any
+			if err := json.Unmarshal(body, &problem); err != nil {
+				t.Fatalf("invalid problem details %q: %v", body, err)
+			}
+			if problem["status"] != 404.0 || problem["title"] != "Not Found" || problem["instance"] != test.path || problem["request_id"] != "req-1" {
+				t.Errorf("unexpected problem details %v", problem)
+			}
+		})
+	}
+}
+
+func TestErrorNegotiation(t *testing.T) {
+	offers := []string{contentTypeText, contentTypeHTML, contentTypeProblem}
+	tests := []struct{ accept, want string }{
+		{"", contentTypeText},
+		{"*/*", contentTypeText},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", contentTypeHTML},
+		{"application/json", contentTypeProblem},
+		{"application/problem+json, text/html;q=0.5", contentTypeProblem},
+		{"text/*;q=0.5, text/html;q=0.1, application/*", contentTypeProblem},
+		{"text/*, text/plain;q=0", contentTypeHTML},
+		{"image/png", contentTypeText},
+	}
+	for _, test := range tests {
+		if got := negotiate(test.accept, offers); got != test.want {
+			t.Errorf("negotiate(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestLoadErrorPagesErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "bad.html"), `{{.Path`)
+	for _, page := range []ErrorPage{
+		{Status: "302", File: filepath.Join(dir, "bad.html")},
+		{Status: "6xx", File: filepath.Join(dir, "bad.html")},
+		{Status: "404", Prefix: "api", File: filepath.Join(dir, "bad.html")},
+		{Status: "404", File: filepath.Join(dir, "missing.html")},
+		{Status: "404", File: filepath.Join(dir, "bad.html")},
+	} {
+		if _, err := LoadErrorPages([]ErrorPage{page}); err == nil {
+			t.Errorf("%+v: loaded an invalid error page", page)
+		}
+	}
+}
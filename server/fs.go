@@ -2,19 +2,11 @@ package server
 
 import (
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
 
-func CreateFsDir() {
-	path := os.Getenv("FS")
-	err := mkdir(path)
-	if err != nil {
-		log.Printf("Failed to create fs directory at %s: %v", path, err)
-	}
-}
-
 // Reads and returns the file contents of the specified path and any errors that occured.
 func GetFile(path string) ([]byte, error) {
 	data, err := os.ReadFile(path)
@@ -43,6 +35,45 @@ func WriteFile(path string, data []byte) error {
 	return nil
 }
 
+// Writes data to the file at the specified path starting at offset, keeping
+// the rest of the file, and returns any errors that occured. A negative offset
+// appends data to the file.
+func WriteFileAt(path string, data []byte, offset int64) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err = mkdir(path); err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset < 0 {
+		flag |= os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flag, 0777)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	if offset < 0 {
+		_, err = f.Write(data)
+	} else {
+		_, err = f.WriteAt(data, offset)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+
+	return nil
+}
+
+// fileETag returns the entity tag of a file, which changes with its size and
+// modification time.
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
 // Creates a directory at the specified path and returns any errors that occured.
 func mkdir(path string) error {
 	path = filepath.Dir(path)
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// SecurityHeaders are added to every response. Empty fields are left out.
+type SecurityHeaders struct {
+	ContentSecurityPolicy string `json:"content_security_policy" toml:"content_security_policy"`
+	// HSTSMaxAge is the Strict-Transport-Security max-age in seconds. It is
+	// only sent on TLS connections.
+	HSTSMaxAge            int    `json:"hsts_max_age" toml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool   `json:"hsts_include_subdomains" toml:"hsts_include_subdomains"`
+	ContentTypeOptions    string `json:"content_type_options" toml:"content_type_options"`
+	ReferrerPolicy        string `json:"referrer_policy" toml:"referrer_policy"`
+	FrameOptions          string `json:"frame_options" toml:"frame_options"`
+}
+
+// DefaultSecurityHeaders returns a conservative set of security headers.
+func DefaultSecurityHeaders() *SecurityHeaders {
+	return &SecurityHeaders{
+		HSTSMaxAge:         365 * 24 * 60 * 60,
+		ContentTypeOptions: "nosniff",
+		ReferrerPolicy:     "strict-origin-when-cross-origin",
+		FrameOptions:       "DENY",
+	}
+}
+
+// Apply adds the headers to the response for req.
+func (h *SecurityHeaders) Apply(req *http.Request, res *http.Response) {
+	set := func(name, value string) {
+		if value != "" {
+			res.Header.Set(name, value)
+		}
+	}
+
+	set("Content-Security-Policy", h.ContentSecurityPolicy)
+	set("X-Content-Type-Options", h.ContentTypeOptions)
+	set("Referrer-Policy", h.ReferrerPolicy)
+	set("X-Frame-Options", h.FrameOptions)
+
+	if req.TLS != nil && h.HSTSMaxAge > 0 {
+		hsts := fmt.Sprintf("max-age=%d", h.HSTSMaxAge)
+		if h.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		res.Header.Set("Strict-Transport-Security", hsts)
+	}
+}
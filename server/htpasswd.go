@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserFile is an htpasswd-compatible user database. Each line holds
+// "user:hash" where the hash is one of bcrypt ($2y$, $2a$, $2b$), SHA-crypt
+// ($5$, $6$), Apache MD5 ($apr1$) or {SHA}. The file is reloaded when it changes.
+type UserFile struct {
+	file *watchedFile
+
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// LoadUserFile reads an htpasswd file from path and returns any errors that occured.
+func LoadUserFile(path string) (*UserFile, error) {
+	u := &UserFile{}
+	file, err := newWatchedFile(path, u.parse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user file: %v", err)
+	}
+	u.file = file
+	return u, nil
+}
+
+// Verify reports whether password matches the stored hash for user.
+func (u *UserFile) Verify(user, password string) bool {
+	u.file.refresh()
+
+	u.mu.RLock()
+	hashed, ok := u.users[user]
+	u.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	return checkPassword(hashed, password)
+}
+
+func (u *UserFile) parse(data []byte) error {
+	users := make(map[string]string)
+	err := parseColonFile(data, 2, func(fields []string) error {
+		users[fields[0]] = fields[1]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.users = users
+	u.mu.Unlock()
+	return nil
+}
+
+// DigestFile is an htdigest-compatible user database used for Digest
+// authentication. Each line holds "user:realm:hash" where the hash is the hex
+// encoded MD5 or SHA-256 of "user:realm:password". A user may have one line
+// per algorithm. The file is reloaded when it changes.
+type DigestFile struct {
+	file *watchedFile
+
+	mu      sync.RWMutex
+	entries map[digestKey]string
+}
+
+type digestKey struct {
+	user, realm, algorithm string
+}
+
+// LoadDigestFile reads an htdigest file from path and returns any errors that occured.
+func LoadDigestFile(path string) (*DigestFile, error) {
+	d := &DigestFile{}
+	file, err := newWatchedFile(path, d.parse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load digest file: %v", err)
+	}
+	d.file = file
+	return d, nil
+}
+
+// HA1 returns the stored hash of "user:realm:password" for the given
+// algorithm ("MD5" or "SHA-256"), or false if there is none.
+func (d *DigestFile) HA1(user, realm, algorithm string) (string, bool) {
+	d.file.refresh()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	ha1, ok := d.entries[digestKey{user, realm, algorithm}]
+	return ha1, ok
+}
+
+func (d *DigestFile) parse(data []byte) error {
+	entries := make(map[digestKey]string)
+	err := parseColonFile(data, 3, func(fields []string) error {
+		ha1 := strings.ToLower(fields[2])
+		if _, err := hex.DecodeString(ha1); err != nil {
+			return fmt.Errorf("invalid hash for user %s", fields[0])
+		}
+
+		switch len(ha1) {
+		case md5.Size * 2:
+			entries[digestKey{fields[0], fields[1], "MD5"}] = ha1
+		case sha256.Size * 2:
+			entries[digestKey{fields[0], fields[1], "SHA-256"}] = ha1
+		default:
+			return fmt.Errorf("invalid hash length for user %s", fields[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.entries = entries
+	d.mu.Unlock()
+	return nil
+}
+
+// parseColonFile calls fn with the colon separated fields of each non-empty,
+// non-comment line. Lines must have exactly n fields.
+func parseColonFile(data []byte, n int, fn func(fields []string) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, ":", n)
+		if len(fields) != n || fields[0] == "" {
+			return fmt.Errorf("line %d: expected %d colon separated fields", line, n)
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// checkPassword compares a password against an htpasswd hash.
+func checkPassword(hashed, password string) bool {
+	switch {
+	case strings.HasPrefix(hashed, "$2a$"), strings.HasPrefix(hashed, "$2b$"), strings.HasPrefix(hashed, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return constantTimeEqual(hashed[len("{SHA}"):], base64.StdEncoding.EncodeToString(sum[:]))
+	case strings.HasPrefix(hashed, "$5$"):
+		return constantTimeEqual(hashed, shaCrypt(sha256.New, "$5$", password, hashed))
+	case strings.HasPrefix(hashed, "$6$"):
+		return constantTimeEqual(hashed, shaCrypt(sha512.New, "$6$", password, hashed))
+	case strings.HasPrefix(hashed, "$apr1$"):
+		return constantTimeEqual(hashed, apr1Crypt(password, hashed))
+	default:
+		return false
+	}
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Alphabet used by the crypt(3) family of hashes.
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptEncode encodes the bytes at the given indices of sum, three at a time
+// with the first index as the most significant byte, in crypt base64.
+func cryptEncode(sum []byte, order [][3]int, tail []int) string {
+	var out strings.Builder
+	encode := func(w uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for _, o := range order {
+		encode(uint32(sum[o[0]])<<16|uint32(sum[o[1]])<<8|uint32(sum[o[2]]), 4)
+	}
+
+	var w uint32
+	for _, i := range tail {
+		w = w<<8 | uint32(sum[i])
+	}
+	encode(w, len(tail)+1)
+	return out.String()
+}
+
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt computes the SHA-crypt hash of password using the salt and rounds
+// of setting, e.g. "$5$rounds=10000$salt$...".
+func shaCrypt(newHash func() hash.Hash, magic, password, setting string) string {
+	params := strings.TrimPrefix(setting, magic)
+	rounds, customRounds := 5000, false
+	if strings.HasPrefix(params, "rounds=") {
+		end := strings.IndexByte(params, '$')
+		if end < 0 {
+			return ""
+		}
+		n, err := strconv.Atoi(params[len("rounds="):end])
+		if err != nil {
+			return ""
+		}
+		rounds = min(max(n, 1000), 999999999)
+		customRounds = true
+		params = params[end+1:]
+	}
+	salt, _, _ := strings.Cut(params, "$")
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	key := []byte(password)
+	size := newHash().Size()
+
+	// repeat returns sum repeated to fill n bytes.
+	repeat := func(sum []byte, n int) []byte {
+		out := make([]byte, 0, n)
+		for ; n > size; n -= size {
+			out = append(out, sum...)
+		}
+		return append(out, sum[:n]...)
+	}
+
+	h := newHash()
+	h.Write(key)
+	h.Write([]byte(salt))
+	h.Write(key)
+	alt := h.Sum(nil)
+
+	h = newHash()
+	h.Write(key)
+	h.Write([]byte(salt))
+	h.Write(repeat(alt, len(key)))
+	for n := len(key); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(alt)
+		} else {
+			h.Write(key)
+		}
+	}
+	sum := h.Sum(nil)
+
+	h = newHash()
+	for i := 0; i < len(key); i++ {
+		h.Write(key)
+	}
+	p := repeat(h.Sum(nil), len(key))
+
+	h = newHash()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		h.Write([]byte(salt))
+	}
+	s := repeat(h.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(p)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	if customRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.WriteString(salt)
+	out.WriteByte('$')
+	if size == sha256.Size {
+		out.WriteString(cryptEncode(sum, sha256CryptOrder, []int{31, 30}))
+	} else {
+		out.WriteString(cryptEncode(sum, sha512CryptOrder, []int{63}))
+	}
+	return out.String()
+}
+
+// apr1Crypt computes the Apache MD5 hash of password using the salt of setting.
+func apr1Crypt(password, setting string) string {
+	const magic = "$apr1$"
+	salt, _, _ := strings.Cut(strings.TrimPrefix(setting, magic), "$")
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	key := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+
+	h := md5.New()
+	h.Write([]byte(password + magic + salt))
+	for n := len(key); n > 0; n -= md5.Size {
+		h.Write(alt[:min(n, md5.Size)])
+	}
+	for n := len(key); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(key[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(key)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(key)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(key)
+		}
+		sum = h.Sum(nil)
+	}
+
+	order := [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}}
+	return magic + salt + "$" + cryptEncode(sum, order, []int{11})
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPFilter decides which clients may connect, based on CIDR allow and deny
+// lists and on temporary bans of clients making too many client errors.
+// A client is rejected if it is banned, denied, or the allow lists are
+// non-empty and do not contain it.
+type IPFilter struct {
+	// BanThreshold is the number of client errors within BanWindow that gets
+	// a client banned for BanDuration. Zero disables banning.
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+	// BanStatuses are the status codes counted as client errors, e.g. "403",
+	// or classes of them like "4xx". All 4xx responses count when empty.
+	BanStatuses []string
+
+	mu        sync.RWMutex
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	file      *watchedFile
+	fileAllow []*net.IPNet
+	fileDeny  []*net.IPNet
+
+	banMu     sync.Mutex
+	strikes   map[string][]time.Time
+	bans      map[string]time.Time
+	lastSweep time.Time
+}
+
+// maxStrikeClients caps the clients whose client errors are counted at once.
+const maxStrikeClients = 65536
+
+// Ban is a temporarily banned client.
+type Ban struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// NewIPFilter creates a filter with the given allow and deny lists.
+func NewIPFilter(allow, deny []*net.IPNet) *IPFilter {
+	return &IPFilter{
+		allow:   allow,
+		deny:    deny,
+		strikes: make(map[string][]time.Time),
+		bans:    make(map[string]time.Time),
+	}
+}
+
+// SetLists replaces the allow and deny lists at runtime.
+func (f *IPFilter) SetLists(allow, deny []*net.IPNet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allow, f.deny = allow, deny
+}
+
+// LoadFile adds the lists in the file at path, which holds one "allow <cidr>"
+// or "deny <cidr>" entry per line. The file is reloaded when it changes.
+func (f *IPFilter) LoadFile(path string) error {
+	file, err := newWatchedFile(path, f.parse)
+	if err != nil {
+		return fmt.Errorf("failed to load IP filter: %v", err)
+	}
+
+	f.mu.Lock()
+	f.file = file
+	f.mu.Unlock()
+	return nil
+}
+
+// Allowed reports whether the client at ip may connect.
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if f.banned(ip.String()) {
+		return false
+	}
+
+	f.mu.RLock()
+	file := f.file
+	f.mu.RUnlock()
+	if file != nil {
+		file.refresh()
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if containsIP(f.deny, ip) || containsIP(f.fileDeny, ip) {
+		return false
+	}
+	if len(f.allow) == 0 && len(f.fileAllow) == 0 {
+		return true
+	}
+	return containsIP(f.allow, ip) || containsIP(f.fileAllow, ip)
+}
+
+// Record counts a response with status sent to the client at ip, and bans
+// the client if it made too many client errors.
+func (f *IPFilter) Record(ip net.IP, status int) {
+	if f.BanThreshold <= 0 || ip == nil || !f.counts(status) {
+		return
+	}
+
+	key := ip.String()
+	now := time.Now()
+
+	f.banMu.Lock()
+	defer f.banMu.Unlock()
+
+	f.sweep(now)
+	if _, ok := f.strikes[key]; !ok && len(f.strikes) >= maxStrikeClients {
+		// Make room by forgetting an arbitrary client.
+		for ip := range f.strikes {
+			delete(f.strikes, ip)
+			break
+		}
+	}
+
+	recent := f.strikes[key][:0]
+	for _, t := range f.strikes[key] {
+		if now.Sub(t) < f.BanWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) < f.BanThreshold {
+		f.strikes[key] = recent
+		return
+	}
+
+	delete(f.strikes, key)
+	f.bans[key] = now.Add(f.BanDuration)
+	slog.Warn("Banned client", "ip", key, "until", f.bans[key].Format(time.RFC3339), "client_errors", len(recent))
+}
+
+// counts reports whether a response with status counts towards a ban.
+func (f *IPFilter) counts(status int) bool {
+	if len(f.BanStatuses) == 0 {
+		return status >= 400 && status <= 499
+	}
+	code := strconv.Itoa(status)
+	for _, s := range f.BanStatuses {
+		if class, ok := strings.CutSuffix(s, "xx"); ok && class == code[:1] || s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckBanStatus verifies an entry of BanStatuses.
+func CheckBanStatus(status string) error {
+	if class, ok := strings.CutSuffix(status, "xx"); ok {
+		if class == "4" || class == "5" {
+			return nil
+		}
+	} else if code, err := strconv.Atoi(status); err == nil && code >= 400 && code <= 599 {
+		return nil
+	}
+	return fmt.Errorf("invalid ban status %q, expected a code from 400 to 599, 4xx or 5xx", status)
+}
+
+// sweep forgets the client errors that left the ban window and the bans that
+// expired, at most once per window. f.banMu must be held.
+func (f *IPFilter) sweep(now time.Time) {
+	if now.Sub(f.lastSweep) < f.BanWindow {
+		return
+	}
+	f.lastSweep = now
+	for ip, strikes := range f.strikes {
+		if len(strikes) == 0 || now.Sub(strikes[len(strikes)-1]) >= f.BanWindow {
+			delete(f.strikes, ip)
+		}
+	}
+	for ip, until := range f.bans {
+		if now.After(until) {
+			delete(f.bans, ip)
+		}
+	}
+}
+
+// InheritBans takes over the bans and client error counts of old, so that
+// replacing a filter does not lift its bans.
+func (f *IPFilter) InheritBans(old *IPFilter) {
+	old.banMu.Lock()
+	defer old.banMu.Unlock()
+	f.banMu.Lock()
+	defer f.banMu.Unlock()
+
+	for ip, until := range old.bans {
+		f.bans[ip] = until
+	}
+	for ip, strikes := range old.strikes {
+		f.strikes[ip] = append([]time.Time(nil), strikes...)
+	}
+}
+
+// Bans returns the currently banned clients.
+func (f *IPFilter) Bans() []Ban {
+	f.banMu.Lock()
+	defer f.banMu.Unlock()
+
+	now := time.Now()
+	bans := []Ban{}
+	for ip, until := range f.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{IP: ip, Until: until})
+		} else {
+			delete(f.bans, ip)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
+
+// Unban lifts the ban on ip and reports whether there was one.
+func (f *IPFilter) Unban(ip string) bool {
+	f.banMu.Lock()
+	defer f.banMu.Unlock()
+
+	_, ok := f.bans[ip]
+	delete(f.bans, ip)
+	delete(f.strikes, ip)
+	return ok
+}
+
+// BansHandler serves the current bans as JSON. DELETE with an ip query
+// parameter lifts a ban.
+func (f *IPFilter) BansHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(f.Bans())
+		case http.MethodDelete:
+			ip := r.URL.Query().Get("ip")
+			if !f.Unban(ip) {
+				http.Error(w, fmt.Sprintf("%s is not banned", ip), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (f *IPFilter) banned(ip string) bool {
+	f.banMu.Lock()
+	defer f.banMu.Unlock()
+
+	until, ok := f.bans[ip]
+	if ok && time.Now().After(until) {
+		delete(f.bans, ip)
+		return false
+	}
+	return ok
+}
+
+func (f *IPFilter) parse(data []byte) error {
+	var allow, deny []*net.IPNet
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected \"allow|deny <cidr>\"", line)
+		}
+
+		networks, err := ParseNetworks(fields[1:])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, networks...)
+		case "deny":
+			deny = append(deny, networks...)
+		default:
+			return fmt.Errorf("line %d: unknown directive %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.fileAllow, f.fileDeny = allow, deny
+	f.mu.Unlock()
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIPFilterLists(t *testing.T) {
+	allow, _ := ParseNetworks([]string{"10.0.0.0/8"})
+	deny, _ := ParseNetworks([]string{"10.1.0.0/16"})
+	filter := NewIPFilter(allow, deny)
+
+	path := filepath.Join(t.TempDir(), "ipfilter")
+	writeTestFile(t, path, "allow 192.0.2.0/24\n")
+	if err := filter.LoadFile(path); err != nil {
+		t.Fatalf("failed to load file: %v", err)
+	}
+	filter.file.interval = 0
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.0.1", false},
+		{"192.0.2.7", true},
+		{"198.51.100.1", false},
+	}
+	for _, tt := range tests {
+		if got := filter.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	writeTestFile(t, path, "deny 10.0.0.1\nallow 198.51.100.0/24\n")
+	if filter.Allowed(net.ParseIP("10.0.0.1")) || !filter.Allowed(net.ParseIP("198.51.100.1")) {
+		t.Errorf("file was not reloaded")
+	}
+
+	filter.SetLists(nil, nil)
+	if filter.Allowed(net.ParseIP("10.0.0.2")) {
+		t.Errorf("lists were not replaced")
+	}
+}
+
+func TestIPFilterStrikes(t *testing.T) {
+	filter := NewIPFilter(nil, nil)
+	filter.BanThreshold, filter.BanWindow, filter.BanDuration = 2, time.Minute, time.Minute
+	ip := net.ParseIP("192.0.2.1")
+
+	filter.Record(ip, http.StatusOK)
+	filter.Record(ip, http.StatusInternalServerError)
+	filter.Record(ip, http.StatusNotFound)
+	if !filter.Allowed(ip) {
+		t.Fatal("client banned after one client error")
+	}
+	filter.Record(ip, http.StatusUnauthorized)
+	if filter.Allowed(ip) {
+		t.Fatal("client not banned after two client errors")
+	}
+
+	// Only the configured statuses count.
+	filter.BanStatuses = []string{"403", "5xx"}
+	ip = net.ParseIP("192.0.2.4")
+	filter.Record(ip, http.StatusNotFound)
+	filter.Record(ip, http.StatusForbidden)
+	if !filter.Allowed(ip) {
+		t.Fatal("client banned for a status that does not count")
+	}
+	filter.Record(ip, http.StatusBadGateway)
+	if filter.Allowed(ip) {
+		t.Fatal("client not banned after two counted statuses")
+	}
+
+	// Stale counts are forgotten once the window passed.
+	filter.BanWindow = time.Millisecond
+	filter.Record(net.ParseIP("192.0.2.2"), http.StatusForbidden)
+	time.Sleep(2 * time.Millisecond)
+	filter.Record(net.ParseIP("192.0.2.3"), http.StatusOK)
+	filter.Record(net.ParseIP("192.0.2.3"), http.StatusForbidden)
+	filter.banMu.Lock()
+	defer filter.banMu.Unlock()
+	if _, ok := filter.strikes["192.0.2.2"]; ok {
+		t.Error("stale strikes were not swept")
+	}
+}
+
+func TestIPFilterBan(t *testing.T) {
+	filter := NewIPFilter(nil, nil)
+	filter.BanThreshold, filter.BanWindow, filter.BanDuration = 3, time.Minute, time.Minute
+	filter.BanStatuses = []string{"401", "403"}
+	addr := startTestServer(t, func(s *Server) {
+		s.IPFilter = filter
+		s.Auth = newTestAuth(t)
+		s.Auth.Require("/private")
+	})
+	get := func(path, password string) (*http.Response, error) {
+		req, _ := http.NewRequest("GET", "http://"+addr+path, nil)
+		if password != "" {
+			req.SetBasicAuth("alice", password)
+		}
+		return http.DefaultClient.Do(req)
+	}
+
+	// Broken links are not among the statuses that count.
+	for i := 0; i < 5; i++ {
+		res, err := get("/missing.txt", "")
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		res.Body.Close()
+	}
+
+	for i := 0; i < 3; i++ {
+		res, err := get("/private/a.txt", "wrong")
+		if err != nil {
+			t.Fatalf("request %d failed before ban: %v", i, err)
+		}
+		res.Body.Close()
+	}
+
+	if res, err := http.Get("http://" + addr + "/missing.txt"); err == nil {
+		res.Body.Close()
+		t.Fatalf("request after ban got status %d, want connection closed", res.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	filter.BansHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/bans", nil))
+	var bans []Ban
+	if err := json.Unmarshal(rec.Body.Bytes(), &bans); err != nil || len(bans) != 1 || bans[0].IP != "127.0.0.1" {
+		t.Fatalf("got bans %s, %v", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	filter.BansHandler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/bans?ip=127.0.0.1", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unban got status %d", rec.Code)
+	}
+
+	res, err := http.Get("http://" + addr + "/missing.txt")
+	if err != nil {
+		t.Fatalf("request after unban failed: %v", err)
+	}
+	res.Body.Close()
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Listener is an address a server accepts connections on. All listeners of
+// a server share its handlers, but each has its own TLS and limit settings.
+type Listener struct {
+	// Name identifies the listener in logs. Defaults to the address.
+	Name string
+	// Network is "tcp", "tcp4", "tcp6", "unix" or "systemd". A systemd
+	// listener uses the socket passed through the LISTEN_FDS protocol whose
+	// name, or index if it has none, is the address.
+	Network string
+	// Address is host:port for TCP, the socket path for Unix domain sockets
+	// and the socket name for systemd.
+	Address string
+	// Mode, when set, is applied to the file of a Unix domain socket.
+	Mode os.FileMode
+	// TLS, when set, serves TLS on the listener.
+	TLS *tls.Config
+	// MaxConnections, when set, limits the connections accepted from this
+	// listener that are open at once.
+	MaxConnections int
+	// Timeout, when set, is how long a connection may stay open.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	ln    net.Listener
+	slots chan bool
+}
+
+// endpoint identifies the socket a listener is bound to.
+func (l *Listener) endpoint() string {
+	return l.Network + " " + l.Address
+}
+
+// name returns the listener's name, defaulting to its address.
+func (l *Listener) name() string {
+	if l.Name == "" {
+		return l.Address
+	}
+	return l.Name
+}
+
+// open binds the listener's socket, or takes over the socket handed over by
+// an upgrade.
+func (l *Listener) open() error {
+	ln, err := inheritedListener(l.endpoint())
+	if err != nil {
+		return err
+	}
+	if ln != nil {
+		l.ln = ln
+		l.slots = createSlots(l.MaxConnections)
+		return nil
+	}
+
+	switch l.Network {
+	case "unix":
+		removeStaleSocket(l.Address)
+		if ln, err = net.Listen("unix", l.Address); err != nil {
+			return err
+		}
+		if l.Mode != 0 {
+			if err := os.Chmod(l.Address, l.Mode); err != nil {
+				ln.Close()
+				return fmt.Errorf("failed to set socket permissions: %v", err)
+			}
+		}
+	case "systemd":
+		if ln, err = systemdListener(l.Address); err != nil {
+			return err
+		}
+	case "tcp", "tcp4", "tcp6":
+		if ln, err = net.Listen(l.Network, l.Address); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported network %q", l.Network)
+	}
+
+	l.ln = ln
+	l.slots = createSlots(l.MaxConnections)
+	return nil
+}
+
+// update takes over the TLS and limit settings of next.
+func (l *Listener) update(next *Listener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if next.MaxConnections != l.MaxConnections {
+		l.slots = createSlots(next.MaxConnections)
+	}
+	l.Name, l.Mode, l.TLS, l.MaxConnections, l.Timeout = next.Name, next.Mode, next.TLS, next.MaxConnections, next.Timeout
+	if l.Network == "unix" && l.Mode != 0 {
+		os.Chmod(l.Address, l.Mode)
+	}
+}
+
+// Addr returns the address the listener is bound to, nil if it is not open.
+func (l *Listener) Addr() net.Addr {
+	if l.ln == nil {
+		return nil
+	}
+	return l.ln.Addr()
+}
+
+// accept waits for a connection slot and the next connection, and applies
+// the listener's settings to it.
+func (l *Listener) accept() (net.Conn, error) {
+	l.mu.Lock()
+	slots := l.slots
+	l.mu.Unlock()
+	if slots != nil {
+		<-slots
+	}
+
+	conn, err := l.ln.Accept()
+	if err != nil {
+		if slots != nil {
+			slots <- true
+		}
+		return nil, err
+	}
+
+	l.mu.Lock()
+	tlsConfig, timeout := l.TLS, l.Timeout
+	l.mu.Unlock()
+
+	conn = &listenerConn{Conn: conn, slots: slots}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if tlsConfig != nil {
+		conn = tls.Server(conn, tlsConfig)
+	}
+	return conn, nil
+}
+
+// connTLS returns the TLS state of conn, which may be tracked, or nil if it
+// is not encrypted.
+func connTLS(conn net.Conn) *tls.ConnectionState {
+	if tracked, ok := conn.(*TrackedConn); ok {
+		conn = tracked.Conn
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	return &state
+}
+
+// createSlots creates a semaphore of size n, nil if n is zero.
+func createSlots(n int) chan bool {
+	if n <= 0 {
+		return nil
+	}
+	return createSemaphore(n)
+}
+
+// listenerConn returns its connection slot to the listener when closed.
+type listenerConn struct {
+	net.Conn
+	slots chan bool
+	once  sync.Once
+}
+
+func (c *listenerConn) Close() error {
+	c.once.Do(func() {
+		if c.slots != nil {
+			c.slots <- true
+		}
+	})
+	return c.Conn.Close()
+}
+
+// removeStaleSocket removes the Unix domain socket at path if nothing is
+// listening on it any more.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// systemd holds the sockets passed by systemd, by name and by index.
+var systemd struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files map[string]*os.File
+	err   error
+}
+
+// systemdListener returns a listener for the socket passed by systemd under
+// name. Each socket can be used once.
+func systemdListener(name string) (net.Listener, error) {
+	systemd.once.Do(loadSystemdFiles)
+	if systemd.err != nil {
+		return nil, systemd.err
+	}
+
+	systemd.mu.Lock()
+	defer systemd.mu.Unlock()
+	file, ok := systemd.files[name]
+	if !ok {
+		return nil, fmt.Errorf("no socket named %q was passed by systemd", name)
+	}
+	for key, f := range systemd.files {
+		if f == file {
+			delete(systemd.files, key)
+		}
+	}
+
+	ln, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("socket %q passed by systemd: %v", name, err)
+	}
+	return ln, nil
+}
+
+// loadSystemdFiles reads the sockets passed through the LISTEN_FDS protocol,
+// starting at file descriptor 3, and clears the protocol's variables so they
+// are not inherited by child processes.
+func loadSystemdFiles() {
+	systemd.files = make(map[string]*os.File)
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		systemd.err = errors.New("no sockets were passed by systemd")
+		return
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		systemd.err = fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+		return
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < count; i++ {
+		file := os.NewFile(uintptr(3+i), "LISTEN_FD_"+strconv.Itoa(3+i))
+		systemd.files[strconv.Itoa(i)] = file
+		if i < len(names) && names[i] != "" {
+			systemd.files[names[i]] = file
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipleListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "http.sock")
+	plain := &Listener{Name: "plain", Network: "tcp", Address: "127.0.0.1:0"}
+	local := &Listener{Name: "local", Network: "unix", Address: socket, Mode: 0600}
+	secure := &Listener{Name: "secure", Network: "tcp4", Address: "127.0.0.1:0", TLS: testTLSConfig(t)}
+
+	startTestServer(t, func(s *Server) {
+		s.Listeners = []*Listener{plain, local, secure}
+		// Nothing may connect over TCP, Unix domain sockets are not filtered.
+		s.IPFilter = NewIPFilter([]*net.IPNet{}, []*net.IPNet{{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}})
+		writeTestFile(t, filepath.Join(s.Root, "a.txt"), "a")
+	})
+
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("got socket %v, %v, want mode 0600", info, err)
+	}
+	if status := getOver(t, "unix", socket, nil); status != "HTTP/1.0 200 OK" {
+		t.Errorf("unix socket got %q", status)
+	}
+	if status := getOver(t, "tcp", plain.Addr().String(), nil); status != "" {
+		t.Errorf("filtered TCP client got %q", status)
+	}
+	if status := getOver(t, "tcp", secure.Addr().String(), &tls.Config{InsecureSkipVerify: true}); status != "" {
+		t.Errorf("filtered TLS client got %q", status)
+	}
+}
+
+func TestListenerSettings(t *testing.T) {
+	limited := &Listener{Network: "tcp", Address: "127.0.0.1:0", MaxConnections: 1, Timeout: time.Second}
+	var s *Server
+	startTestServer(t, func(srv *Server) {
+		s = srv
+		s.Listeners = []*Listener{limited}
+		writeTestFile(t, filepath.Join(s.Root, "a.txt"), "a")
+	})
+	addr := limited.Addr().String()
+
+	// The first connection holds the only slot, so the second is not served
+	// until the first one times out.
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer idle.Close()
+	start := time.Now()
+	if status := getOver(t, "tcp", addr, nil); status != "HTTP/1.0 200 OK" {
+		t.Errorf("got %q", status)
+	}
+	if waited := time.Since(start); waited < 500*time.Millisecond {
+		t.Errorf("second connection was served after %v, before the first one timed out", waited)
+	}
+
+	// Switching the listener to TLS keeps its socket.
+	next := &Listener{Network: "tcp", Address: "127.0.0.1:0", TLS: testTLSConfig(t)}
+	if err := s.SetListeners([]*Listener{next}); err != nil {
+		t.Fatalf("failed to update listeners: %v", err)
+	}
+	if status := getOver(t, "tcp", addr, &tls.Config{InsecureSkipVerify: true}); status != "HTTP/1.0 200 OK" {
+		t.Errorf("TLS got %q", status)
+	}
+
+	if err := s.SetListeners([]*Listener{{Network: "sctp", Address: "127.0.0.1:0"}}); err == nil {
+		t.Error("unsupported network was accepted")
+	}
+	if status := getOver(t, "tcp", addr, &tls.Config{InsecureSkipVerify: true}); status != "HTTP/1.0 200 OK" {
+		t.Errorf("listener was not kept after a failed update, got %q", status)
+	}
+}
+
+func TestHSTSOverTLS(t *testing.T) {
+	plain := &Listener{Network: "tcp", Address: "127.0.0.1:0"}
+	secure := &Listener{Network: "tcp", Address: "127.0.0.1:0", TLS: testTLSConfig(t)}
+	startTestServer(t, func(s *Server) {
+		s.Listeners = []*Listener{plain, secure}
+		s.SecurityHeaders = DefaultSecurityHeaders()
+		// Tracked connections wrap the TLS connection.
+		s.Conns = NewConnTracker()
+		writeTestFile(t, filepath.Join(s.Root, "a.txt"), "a")
+	})
+
+	tests := []struct {
+		name      string
+		listener  *Listener
+		tlsConfig *tls.Config
+		want      string
+	}{
+		{"plain", plain, nil, ""},
+		{"TLS", secure, &tls.Config{InsecureSkipVerify: true}, "max-age=31536000"},
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", test.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer conn.Close()
+		if test.tlsConfig != nil {
+			conn = tls.Client(conn, test.tlsConfig)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprint(conn, "GET /a.txt HTTP/1.0\r\n\r\n")
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("%s: failed to read response: %v", test.name, err)
+		}
+		if got := res.Header.Get("Strict-Transport-Security"); res.StatusCode != http.StatusOK || got != test.want {
+			t.Errorf("%s: got %d with HSTS %q, want 200 with %q", test.name, res.StatusCode, got, test.want)
+		}
+	}
+}
+
+func TestSystemdListenerWithoutSockets(t *testing.T) {
+	if _, err := systemdListener("http"); err == nil {
+		t.Error("got a listener without LISTEN_FDS")
+	}
+}
+
+// getOver requests /a.txt over a connection to address and returns the
+// status line, or an empty string if the connection was closed.
+func getOver(t *testing.T, network, address string, tlsConfig *tls.Config) string {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", address, err)
+	}
+	defer conn.Close()
+	if tlsConfig != nil {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET /a.txt HTTP/1.0\r\n\r\n")
+	status, _ := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSpace(status)
+}
+
+// testTLSConfig returns a TLS configuration with a self-signed certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
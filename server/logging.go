@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// NewLogger creates a logger writing to w in format ("text" or "json") that
+// discards records below level.
+func NewLogger(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of req carrying a request-scoped logger.
+func WithLogger(req *http.Request, logger *slog.Logger) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), loggerKey{}, logger))
+}
+
+// LoggerFromRequest returns the request-scoped logger of req, or the default logger.
+func LoggerFromRequest(req *http.Request) *slog.Logger {
+	if logger, ok := req.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// LevelHandler serves the current log level as JSON. PUT or POST with a
+// level query parameter ("debug", "info", "warn" or "error") changes it.
+func LevelHandler(level *slog.LevelVar) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var l slog.Level
+			if err := l.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("Changing log level", "from", level.Level(), "to", l)
+			level.Set(l)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": level.Level().String()})
+	})
+}
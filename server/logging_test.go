@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRequestScopedLogging(t *testing.T) {
+	var buf lockedBuffer
+	logger, err := NewLogger(&buf, "json", new(slog.LevelVar))
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	addr := startTestServer(t, func(s *Server) {
+		s.Logger = logger
+		s.Auth = newTestAuth(t)
+	})
+
+	req, _ := http.NewRequest("POST", "http://"+addr+"/file.txt", nil)
+	req.Header.Set("X-Request-ID", "abc123")
+	req.SetBasicAuth("alice", "wrong")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var entry map[string]any
+	if err := json.Unmarshal(lines[len(lines)-1], &entry); err != nil {
+		t.Fatalf("invalid log entry %q: %v", lines[len(lines)-1], err)
+	}
+	want := map[string]any{"level": "WARN", "msg": "Authentication failed", "request_id": "abc123", "path": "/file.txt"}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("got %s %v, want %v", key, entry[key], value)
+		}
+	}
+	if entry["remote_addr"] == nil {
+		t.Errorf("entry has no remote_addr")
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	level := new(slog.LevelVar)
+	handler := LevelHandler(level)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/loglevel?level=debug", nil))
+	if rec.Code != http.StatusOK || level.Level() != slog.LevelDebug {
+		t.Fatalf("got status %d and level %v, want debug", rec.Code, level.Level())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("PUT", "/loglevel?level=loud", nil))
+	if rec.Code != http.StatusBadRequest || level.Level() != slog.LevelDebug {
+		t.Errorf("invalid level: got status %d and level %v", rec.Code, level.Level())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/loglevel", nil))
+	if body := rec.Body.String(); body != "{\"level\":\"DEBUG\"}\n" {
+		t.Errorf("got %q", body)
+	}
+}
+
+// lockedBuffer is a buffer safe for use by the server and the test at once.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the histogram buckets, in seconds, used for latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them in the Prometheus text exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+type collector interface {
+	collect(w io.Writer)
+}
+
+// collectorFunc adapts a function to the collector interface.
+type collectorFunc func(w io.Writer)
+
+func (f collectorFunc) collect(w io.Writer) { f(w) }
+
+// Register adds a collector that writes its metrics, including the HELP and
+// TYPE lines, whenever the registry is scraped.
+func (r *Registry) Register(collect func(w io.Writer)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collectorFunc(collect))
+}
+
+// NewCounterVec registers a counter partitioned by the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+	return c
+}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds,
+// partitioned by the given labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, buckets: buckets, labels: labels, series: make(map[string]*histogram)}
+	r.mu.Lock()
+	r.collectors = append(r.collectors, h)
+	r.mu.Unlock()
+	return h
+}
+
+// WriteTo writes all registered metrics to w.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		c.collect(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", MetricsContentType)
+		r.WriteTo(w)
+	})
+}
+
+// MetricsContentType is the content type of the Prometheus text format.
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// CounterVec is a monotonically increasing value per label combination.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Add increases the counter for the label values by v.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Inc increases the counter for the label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) collect(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec counts observations in buckets per label combination.
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds the value v to the histogram for the label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveDuration adds d in seconds to the histogram for the label values.
+func (h *HistogramVec) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) collect(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+// WriteGauge writes a gauge without labels, including its HELP and TYPE lines.
+func WriteGauge(w io.Writer, name, help string, value float64) {
+	writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders the label set for a series key, adding le when it
+// is not empty.
+func formatLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			if i < len(names) {
+				pairs = append(pairs, names[i]+"="+quoteLabel(value))
+			}
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, "le="+quoteLabel(le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Metrics are the request, connection and storage metrics of a server or proxy.
+type Metrics struct {
+	*Registry
+
+	Requests        *CounterVec
+	RequestDuration *HistogramVec
+	BytesIn         *CounterVec
+	BytesOut        *CounterVec
+	AcceptWait      *HistogramVec
+	UpstreamLatency *HistogramVec
+	UpstreamErrors  *CounterVec
+
+	mu   sync.Mutex
+	sem  chan bool
+	root string
+}
+
+// NewMetrics creates the metrics of a server or proxy.
+func NewMetrics() *Metrics {
+	r := &Registry{}
+	m := &Metrics{
+		Registry:        r,
+		Requests:        r.NewCounterVec("http_requests_total", "Requests handled, by method and status.", "method", "status"),
+		RequestDuration: r.NewHistogramVec("http_request_duration_seconds", "Time from reading a request to writing its response.", DefaultBuckets, "method"),
+		BytesIn:         r.NewCounterVec("http_request_bytes_total", "Request body bytes read."),
+		BytesOut:        r.NewCounterVec("http_response_bytes_total", "Response body bytes written."),
+		AcceptWait:      r.NewHistogramVec("http_accept_wait_seconds", "Time accepted connections waited for a free connection slot.", DefaultBuckets),
+		UpstreamLatency: r.NewHistogramVec("proxy_upstream_duration_seconds", "Time until the upstream server responded.", DefaultBuckets),
+		UpstreamErrors:  r.NewCounterVec("proxy_upstream_errors_total", "Upstream requests that failed."),
+	}
+	r.Register(m.collectSlots)
+	r.Register(m.collectStorage)
+	return m
+}
+
+// ObserveRequest records a handled request.
+func (m *Metrics) ObserveRequest(method string, status int, d time.Duration, in, out int64) {
+	method = metricMethod(method)
+	m.Requests.Inc(method, strconv.Itoa(status))
+	m.RequestDuration.ObserveDuration(d, method)
+	m.BytesIn.Add(float64(in))
+	m.BytesOut.Add(float64(out))
+}
+
+// TrackSemaphore reports the use of the connection slots in sem.
+func (m *Metrics) TrackSemaphore(sem chan bool) {
+	m.mu.Lock()
+	m.sem = sem
+	m.mu.Unlock()
+}
+
+// TrackStorage reports the size and number of the files stored under root.
+func (m *Metrics) TrackStorage(root string) {
+	m.mu.Lock()
+	m.root = root
+	m.mu.Unlock()
+}
+
+func (m *Metrics) collectSlots(w io.Writer) {
+	m.mu.Lock()
+	sem := m.sem
+	m.mu.Unlock()
+	if sem == nil {
+		return
+	}
+	WriteGauge(w, "http_connection_slots", "Maximum number of concurrently handled connections.", float64(cap(sem)))
+	WriteGauge(w, "http_connection_slots_in_use", "Connections currently being handled.", float64(cap(sem)-len(sem)))
+}
+
+func (m *Metrics) collectStorage(w io.Writer) {
+	m.mu.Lock()
+	root := m.root
+	m.mu.Unlock()
+	if root == "" {
+		return
+	}
+
+	var size, files int64
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	WriteGauge(w, "storage_bytes", "Total size of the stored files.", float64(size))
+	WriteGauge(w, "storage_files", "Number of stored files.", float64(files))
+}
+
+// metricMethod maps methods outside the standard and WebDAV sets to OTHER,
+// keeping clients from creating arbitrary series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+		"MKCOL", "COPY", "MOVE", "PROPFIND", "PROPPATCH", "LOCK", "UNLOCK":
+		return method
+	}
+	return "OTHER"
+}
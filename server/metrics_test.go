@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryFormat(t *testing.T) {
+	r := &Registry{}
+	c := r.NewCounterVec("test_total", "A counter.", "path")
+	c.Inc(`/a"b`)
+	c.Add(2, "/c")
+	h := r.NewHistogramVec("test_seconds", "A histogram.", []float64{0.1, 1})
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	want := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{path="/a\"b"} 1
+test_total{path="/c"} 2
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 0
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="+Inf"} 2
+test_seconds_sum 2.5
+test_seconds_count 2
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.Metrics = NewMetrics()
+		s.MetricsPath = "/metrics"
+	})
+
+	res, err := http.Post("http://"+addr+"/a.txt", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("failed to post: %v", err)
+	}
+	res.Body.Close()
+
+	// The POST is recorded after its response is written, so poll briefly.
+	var body []byte
+	for i := 0; i < 50 && !bytes.Contains(body, []byte(`method="POST"`)); i++ {
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		res, err = http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			t.Fatalf("failed to get metrics: %v", err)
+		}
+		body, _ = io.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	if ct := res.Header.Get("Content-Type"); ct != MetricsContentType {
+		t.Errorf("got content type %q", ct)
+	}
+	for _, line := range []string{
+		`http_requests_total{method="POST",status="200"} 1`,
+		`http_request_duration_seconds_count{method="POST"} 1`,
+		`http_request_bytes_total 5`,
+		`http_connection_slots 10`,
+		`storage_bytes 5`,
+		`storage_files 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics lack %q:\n%s", line, body)
+		}
+	}
+	if !strings.Contains(string(body), "\nhttp_connection_slots_in_use ") {
+		t.Errorf("metrics lack slots in use:\n%s", body)
+	}
+}
+
+func TestMetricMethod(t *testing.T) {
+	for _, method := range []string{"GET", "PATCH", "PROPFIND", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK", "PROPPATCH"} {
+		if got := metricMethod(method); got != method {
+			t.Errorf("metricMethod(%q) = %q", method, got)
+		}
+	}
+	if got := metricMethod("BREW"); got != "OTHER" {
+		t.Errorf("metricMethod(%q) = %q, want OTHER", "BREW", got)
+	}
+}
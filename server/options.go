@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// sensitiveHeaders are left out of TRACE responses, which caches and
+// scripts may see, RFC 9110 section 9.3.8.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// HandleOptions reports the methods the requested resource allows the
+// client: the ones it supports, narrowed down by the ACL. A client the ACL
+// admits for nothing but OPTIONS is refused like any other request, so that
+// the answer does not reveal whether the resource exists.
+func (s *Server) HandleOptions(req *http.Request, res *http.Response) {
+	permitted := s.supportedMethods()
+	if s.ACL != nil {
+		principal := PrincipalFromRequest(req)
+		admitted, needsAuth := false, false
+		permitted = slices.DeleteFunc(permitted, func(method string) bool {
+			if method == http.MethodOptions {
+				return false
+			}
+			probe := req.Clone(req.Context())
+			probe.Method = method
+			decision := s.ACL.Evaluate(probe, remoteIP(req), principal)
+			admitted = admitted || decision.Allowed
+			needsAuth = needsAuth || decision.NeedsAuth && principal == nil
+			return !decision.Allowed && !decision.NeedsAuth
+		})
+		if !admitted {
+			s.refuse(res, needsAuth)
+			return
+		}
+	}
+	allowed := slices.DeleteFunc(s.allowedMethods(req.URL.Path), func(method string) bool {
+		return !slices.Contains(permitted, method)
+	})
+	s.writeOptions(res, allowed)
+}
+
+// HandleServerOptions answers "OPTIONS *" with the methods the server
+// supports for any resource.
+func (s *Server) HandleServerOptions(res *http.Response) {
+	s.writeOptions(res, s.supportedMethods())
+}
+
+// supportedMethods returns the methods the server supports for some
+// resource.
+func (s *Server) supportedMethods() []string {
+	allowed := []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch}
+	if s.WebDAV != nil {
+		allowed = append(allowed, davMethods...)
+	}
+	allowed = append(allowed, http.MethodOptions)
+	if s.AllowTrace {
+		allowed = append(allowed, http.MethodTrace)
+	}
+	return allowed
+}
+
+// writeOptions builds the response to an OPTIONS request, announcing the
+// patch formats if PATCH is allowed and the WebDAV compliance classes if
+// WebDAV is served.
+func (s *Server) writeOptions(res *http.Response, allowed []string) {
+	res.Status = "200 OK"
+	res.StatusCode = 200
+	res.Header.Set("Allow", strings.Join(allowed, ", "))
+	if slices.Contains(allowed, http.MethodPatch) {
+		res.Header.Set("Accept-Patch", acceptPatch)
+	}
+	if s.WebDAV != nil {
+		res.Header.Set("DAV", "1, 2")
+		res.Header.Set("MS-Author-Via", "DAV")
+	}
+	res.Body = io.NopCloser(strings.NewReader(""))
+}
+
+// HandleTrace echoes the request back as a message/http body, without its
+// credentials and cookies.
+func (s *Server) HandleTrace(req *http.Request, res *http.Response) {
+	header := req.Header.Clone()
+	for _, name := range sensitiveHeaders {
+		header.Del(name)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\r\n", req.Method, req.RequestURI, req.Proto)
+	if req.Host != "" {
+		fmt.Fprintf(&buf, "Host: %s\r\n", req.Host)
+	}
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+
+	res.Status = "200 OK"
+	res.StatusCode = 200
+	res.Header.Set("Content-Type", "message/http")
+	res.ContentLength = int64(buf.Len())
+	res.Body = io.NopCloser(&buf)
+}
+
+// handleAsterisk answers a request for the server as a whole, which only
+// OPTIONS may make, RFC 9112 section 3.2.4.
+func (s *Server) handleAsterisk(req *http.Request, res *http.Response) {
+	if req.Method != http.MethodOptions {
+		s.HandleBadRequest(res)
+		return
+	}
+	s.HandleServerOptions(res)
+}
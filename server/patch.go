@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Patch formats, RFC 5789 section 3.1. Range writes and appends take the
+// bytes to write in any content type.
+const (
+	mergePatchType = "application/merge-patch+json"
+	appendType     = "application/x-append"
+)
+
+// acceptPatch lists the patch formats for Accept-Patch.
+var acceptPatch = strings.Join([]string{mergePatchType, appendType}, ", ")
+
+// patchMu serializes PATCH requests so that a file does not change between
+// checking If-Match and writing it.
+var patchMu sync.Mutex
+
+// HandlePatch modifies an existing file: it writes the body at the offset in
+// Content-Range, appends it if the Content-Type is application/x-append or
+// X-Append is true, or applies it as a JSON Merge Patch to a .json file.
+func (s *Server) HandlePatch(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	if _, err := s.contentType(req); err != nil {
+		LoggerFromRequest(req).Info("Rejected patch of unsupported content type", "err", err)
+		s.HandleUnsupportedMediaType(res)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	contentRange := req.Header.Get("Content-Range")
+	appending := mediaType == appendType || strings.EqualFold(req.Header.Get("X-Append"), "true")
+	switch {
+	case mediaType == mergePatchType && filepath.Ext(name) != ".json":
+		LoggerFromRequest(req).Info("Rejected merge patch of a file that is not JSON")
+		s.HandleUnsupportedMediaType(res)
+		res.Header.Set("Accept-Patch", appendType)
+		return
+	case mediaType != mergePatchType && contentRange == "" && !appending:
+		// RFC 5789 section 2.2.
+		s.HandleUnsupportedMediaType(res)
+		res.Header.Set("Accept-Patch", acceptPatch)
+		return
+	case contentRange != "" && appending:
+		s.HandleBadRequest(res)
+		return
+	}
+
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		LoggerFromRequest(req).Warn("Error reading request body", "err", err)
+		s.HandleBadRequest(res)
+		return
+	}
+
+	patchMu.Lock()
+	defer patchMu.Unlock()
+	info, err := os.Stat(name)
+	if err != nil {
+		s.HandleNotFound(res)
+		return
+	}
+	if !ifMatch(req.Header.Get("If-Match"), info) {
+		davStatus(res, http.StatusPreconditionFailed)
+		res.Header.Set("ETag", fileETag(info))
+		return
+	}
+
+	switch {
+	case mediaType == mergePatchType:
+		err = mergePatchFile(name, data)
+	case appending:
+		err = WriteFileAt(name, data, -1)
+	default:
+		var first, length int64
+		if first, length, err = parseContentRange(contentRange, len(data)); err != nil {
+			LoggerFromRequest(req).Info("Rejected patch with invalid Content-Range", "content_range", contentRange, "err", err)
+			s.HandleBadRequest(res)
+			return
+		}
+		if first > info.Size() || length > max(info.Size(), first+int64(len(data))) {
+			// Writes may extend the file but not leave a hole in it, and
+			// neither may a complete length past the written bytes.
+			davStatus(res, http.StatusRequestedRangeNotSatisfiable)
+			res.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+			return
+		}
+		if err = WriteFileAt(name, data, first); err == nil && length >= 0 {
+			err = os.Truncate(name, length)
+		}
+	}
+	switch {
+	case errors.Is(err, errInvalidPatch):
+		LoggerFromRequest(req).Info("Rejected merge patch", "err", err)
+		s.HandleBadRequest(res)
+		return
+	case errors.Is(err, errInvalidTarget):
+		LoggerFromRequest(req).Info("Rejected merge patch", "err", err)
+		davStatus(res, http.StatusConflict)
+		return
+	case err != nil:
+		LoggerFromRequest(req).Error("Error patching file", "file", name, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+
+	if info, err = touchAfter(name, info); err != nil {
+		LoggerFromRequest(req).Error("Error updating modification time", "file", name, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	s.HandleNoContent(res)
+	res.Header.Set("ETag", fileETag(info))
+}
+
+// ifMatch reports whether the If-Match header, if any, lists the entity tag
+// of the file, RFC 9110 section 13.1.1.
+func ifMatch(header string, info fs.FileInfo) bool {
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag := fileETag(info)
+	for _, tag := range strings.Split(header, ",") {
+		// Weak tags never match, which the comparison takes care of.
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// touchAfter makes sure the modification time of the file at name has moved
+// past the one in before, which coarse file system clocks may not have done,
+// so that its entity tag changes. It returns the file's new info.
+func touchAfter(name string, before fs.FileInfo) (fs.FileInfo, error) {
+	info, err := os.Stat(name)
+	if err != nil || info.ModTime().After(before.ModTime()) {
+		return info, err
+	}
+	mtime := before.ModTime().Add(time.Nanosecond)
+	if err := os.Chtimes(name, time.Time{}, mtime); err != nil {
+		return nil, err
+	}
+	return os.Stat(name)
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes first-last/length" for a body of n bytes, where length may be "*".
+// It returns the first byte and the length the file should be truncated to,
+// or -1 if it keeps its size.
+func parseContentRange(header string, n int) (first, length int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, errors.New("unit must be bytes")
+	}
+	byteRange, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, errors.New("missing complete length")
+	}
+	firstStr, lastStr, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, errors.New("invalid range")
+	}
+	first, err = strconv.ParseInt(firstStr, 10, 64)
+	if err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("invalid first byte %q", firstStr)
+	}
+	last, err := strconv.ParseInt(lastStr, 10, 64)
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid last byte %q", lastStr)
+	}
+	if last-first+1 != int64(n) {
+		return 0, 0, fmt.Errorf("range of %d bytes for a body of %d", last-first+1, n)
+	}
+	if total == "*" {
+		return first, -1, nil
+	}
+	length, err = strconv.ParseInt(total, 10, 64)
+	if err != nil || length <= last {
+		return 0, 0, fmt.Errorf("invalid complete length %q", total)
+	}
+	return first, length, nil
+}
+
+var (
+	errInvalidPatch  = errors.New("invalid merge patch")
+	errInvalidTarget = errors.New("file is not valid JSON")
+)
+
+// mergePatchFile applies the JSON Merge Patch in data to the JSON file at
+// name, RFC 7396.
+func mergePatchFile(name string, data []byte) error {
+	patch, err := decodeJSON(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPatch, err)
+	}
+	current, err := GetFile(name)
+	if err != nil {
+		return err
+	}
+	var target any
+	if len(bytes.TrimSpace(current)) > 0 {
+		if target, err = decodeJSON(current); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidTarget, err)
+		}
+	}
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return err
+	}
+	return WriteFile(name, append(merged, '\n'))
+}
+
+// mergePatch returns target with patch applied, RFC 7396 section 2.
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers as written.
+func decodeJSON(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("data after the JSON value")
+	}
+	return v, nil
+}
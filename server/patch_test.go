@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	var root string
+	addr := startTestServer(t, func(s *Server) { root = s.Root })
+
+	tests := []struct {
+		name, file, initial, body string
+		headers                   []string
+		status                    int
+		want                      string
+	}{
+		{"range", "a.txt", "hello world", "HELLO", []string{"Content-Range: bytes 0-4/*"}, 204, "HELLO world"},
+		{"range extending", "a.txt", "hello", " world", []string{"Content-Range: bytes 5-10/*"}, 204, "hello world"},
+		{"range truncating", "a.txt", "hello world", "HELP", []string{"Content-Range: bytes 0-3/4"}, 204, "HELP"},
+		{"range past end", "a.txt", "hello", "x", []string{"Content-Range: bytes 6-6/*"}, 416, "hello"},
+		{"range complete length past end", "a.txt", "hello", "x", []string{"Content-Range: bytes 0-0/10"}, 416, "hello"},
+		{"range complete length at end", "a.txt", "hello", "!", []string{"Content-Range: bytes 5-5/6"}, 204, "hello!"},
+		{"range length mismatch", "a.txt", "hello", "xy", []string{"Content-Range: bytes 0-0/*"}, 400, "hello"},
+		{"range invalid", "a.txt", "hello", "x", []string{"Content-Range: items 0-0/*"}, 400, "hello"},
+		{"append type", "a.txt", "hello", " world", []string{"Content-Type: application/x-append"}, 204, "hello world"},
+		{"append header", "a.txt", "hello", " world", []string{"X-Append: true"}, 204, "hello world"},
+		{"append with range", "a.txt", "hello", "x", []string{"X-Append: true", "Content-Range: bytes 0-0/*"}, 400, "hello"},
+		{"no format", "a.txt", "hello", "x", []string{"Content-Type: text/plain"}, 415, "hello"},
+		{"merge", "a.json", `{"a":1,"b":{"c":2,"d":3}}`, `{"b":{"c":null,"e":4},"f":[1]}`, []string{"Content-Type: application/merge-patch+json"}, 204, `{"a":1,"b":{"d":3,"e":4},"f":[1]}` + "\n"},
+		{"merge replacing", "a.json", `[1,2]`, `{"a":1.50}`, []string{"Content-Type: application/merge-patch+json"}, 204, `{"a":1.50}` + "\n"},
+		{"merge invalid patch", "a.json", `{}`, `{"a":`, []string{"Content-Type: application/merge-patch+json"}, 400, `{}`},
+		{"merge invalid file", "a.json", `{`, `{}`, []string{"Content-Type: application/merge-patch+json"}, 409, `{`},
+		{"merge not JSON", "a.txt", "hello", `{}`, []string{"Content-Type: application/merge-patch+json"}, 415, "hello"},
+		{"missing", "", "", "x", []string{"X-Append: true"}, 405, ""},
+		{"unsupported type", "a.exe", "hello", "x", []string{"X-Append: true"}, 415, "hello"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := "/missing.txt"
+			if test.file != "" {
+				path = "/" + test.file
+				writeTestFile(t, filepath.Join(root, test.file), test.initial)
+			}
+			res, body := davExchange(t, addr, "PATCH", path, test.body, test.headers...)
+			if res.StatusCode != test.status {
+				t.Fatalf("got %d, want %d: %s", res.StatusCode, test.status, body)
+			}
+			if test.file == "" {
+				return
+			}
+			if data, _ := os.ReadFile(filepath.Join(root, test.file)); string(data) != test.want {
+				t.Errorf("got %q, want %q", data, test.want)
+			}
+		})
+	}
+}
+
+func TestPatchIfMatch(t *testing.T) {
+	var root string
+	addr := startTestServer(t, func(s *Server) { root = s.Root })
+	writeTestFile(t, filepath.Join(root, "a.txt"), "aaaa")
+
+	res, _ := rawExchange(t, addr, "GET /a.txt HTTP/1.0\r\n\r\n")
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("GET did not return an ETag")
+	}
+
+	res, _ = davExchange(t, addr, "PATCH", "/a.txt", "b", "Content-Range: bytes 0-0/*", "If-Match: "+etag)
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("ETag") == etag {
+		t.Fatalf("got %d with ETag %q, want 204 with a new ETag", res.StatusCode, res.Header.Get("ETag"))
+	}
+	next := res.Header.Get("ETag")
+
+	// A client that read the file before the first patch loses.
+	res, _ = davExchange(t, addr, "PATCH", "/a.txt", "c", "Content-Range: bytes 0-0/*", "If-Match: "+etag)
+	if res.StatusCode != http.StatusPreconditionFailed || res.Header.Get("ETag") != next {
+		t.Errorf("got %d with ETag %q, want 412 with %q", res.StatusCode, res.Header.Get("ETag"), next)
+	}
+	if res, _ := davExchange(t, addr, "PATCH", "/a.txt", "c", "Content-Range: bytes 1-1/*", `If-Match: "x", `+next); res.StatusCode != http.StatusNoContent {
+		t.Errorf("got %d, want 204 for a listed ETag", res.StatusCode)
+	}
+	if res, _ := davExchange(t, addr, "PATCH", "/a.txt", "d", "Content-Range: bytes 2-2/*", "If-Match: *"); res.StatusCode != http.StatusNoContent {
+		t.Errorf("got %d, want 204 for If-Match: *", res.StatusCode)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "bcda" {
+		t.Errorf("got %q, want %q", data, "bcda")
+	}
+
+	res, _ = davExchange(t, addr, "OPTIONS", "/a.txt", "")
+	if res.Header.Get("Accept-Patch") != acceptPatch {
+		t.Errorf("got Accept-Patch %q, want %q", res.Header.Get("Accept-Patch"), acceptPatch)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresignedURLs(t *testing.T) {
+	presigner := NewPresigner([]byte("0123456789abcdef0123456789abcdef"))
+	addr := startTestServer(t, func(s *Server) {
+		s.Auth = newTestAuth(t)
+		s.Auth.Require("/")
+		s.Presigner = presigner
+	})
+
+	hour := time.Now().Add(time.Hour)
+	upload := presigner.Sign("POST", "/shared/a.txt", hour, 5, "text/plain")
+	download := presigner.Sign("GET", "/shared/a.txt", hour, -1, "")
+
+	tests := []struct {
+		name        string
+		method      string
+		link        string
+		body        string
+		contentType string
+		want        int
+	}{
+		{name: "Unsigned", method: "POST", link: "/shared/a.txt", body: "hello", contentType: "text/plain", want: 401},
+		{name: "Wrong content length", method: "POST", link: upload, body: "hello world", contentType: "text/plain", want: 403},
+		{name: "Wrong content type", method: "POST", link: upload, body: "hello", contentType: "text/html", want: 403},
+		{name: "Upload", method: "POST", link: upload, body: "hello", contentType: "text/plain", want: 200},
+		{name: "Download", method: "GET", link: download, want: 200},
+		{name: "Method not signed", method: "POST", link: download, body: "hello", want: 403},
+		{name: "Other path", method: "GET", link: strings.Replace(download, "a.txt", "b.txt", 1), want: 403},
+		{name: "Dot segments", method: "GET", link: strings.Replace(download, "/shared/", "/other/../shared/./", 1), want: 200},
+		{name: "Dot segments to other path", method: "GET", link: strings.Replace(download, "/shared/a.txt", "/shared/a.txt/../b.txt", 1), want: 403},
+		{name: "Signed with dot segments", method: "GET", link: presigner.Sign("GET", "/shared//x/../a.txt", hour, -1, ""), want: 200},
+		{name: "Tampered expiry", method: "GET", link: strings.Replace(download, "X-Expires=", "X-Expires=9", 1), want: 403},
+		{name: "Expired", method: "GET", link: presigner.Sign("GET", "/shared/a.txt", time.Now().Add(-time.Second), -1, ""), want: 403},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+tt.link, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.want)
+		}
+	}
+}
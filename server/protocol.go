@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lab1/parser"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Software is sent in the Server header of the server's responses.
+const Software = "http_server"
+
+// checkProtocol verifies the parts of req that depend on its HTTP version
+// and builds the error response if they are invalid. It reports whether the
+// request can be handled.
+func (s *Server) checkProtocol(req *http.Request, res *http.Response) bool {
+	switch {
+	case req.ProtoMajor != 1:
+		s.HandleHTTPVersionNotSupported(res)
+	case req.ProtoAtLeast(1, 1) && req.Host == "":
+		// HTTP/1.1 requires a Host header, RFC 9112 section 3.2.
+		LoggerFromRequest(req).Info("Rejected HTTP/1.1 request without Host header")
+		s.HandleBadRequest(res)
+	case req.ProtoAtLeast(1, 1) && req.Header.Get("Expect") != "" && !strings.EqualFold(req.Header.Get("Expect"), "100-continue"):
+		s.HandleExpectationFailed(res)
+	default:
+		return true
+	}
+	return false
+}
+
+// expectContinue makes the body of a HTTP/1.1 request expecting 100 Continue
+// send the interim response to conn when the body is first read. HTTP/1.0
+// clients cannot expect it and their Expect header is ignored.
+func expectContinue(conn net.Conn, req *http.Request) {
+	if !req.ProtoAtLeast(1, 1) || !strings.EqualFold(req.Header.Get("Expect"), "100-continue") || req.ContentLength == 0 {
+		return
+	}
+	req.Body = &continueReader{ReadCloser: req.Body, conn: conn}
+}
+
+// continueReader sends 100 Continue before the first read of a request body.
+type continueReader struct {
+	io.ReadCloser
+	conn net.Conn
+	once sync.Once
+}
+
+func (r *continueReader) Read(p []byte) (int, error) {
+	var err error
+	r.once.Do(func() { _, err = io.WriteString(r.conn, "HTTP/1.1 100 Continue\r\n\r\n") })
+	if err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// FrameResponse prepares res for being sent in reply to req: it answers in
+// the request's HTTP/1.x version, closes the connection after the response,
+// drops bodies the status or method does not allow and adds the Date header
+// and, if unset, the Server header with software. A body of unknown length
+// is sent chunked to HTTP/1.1 clients and delimited by closing the
+// connection for HTTP/1.0 clients.
+func FrameResponse(req *http.Request, res *http.Response, software string) {
+	res.Proto, res.ProtoMajor, res.ProtoMinor = "HTTP/1.0", 1, 0
+	if req.ProtoAtLeast(1, 1) {
+		// Connections serve a single request, which HTTP/1.1 clients must
+		// be told.
+		res.Proto, res.ProtoMinor, res.Close = "HTTP/1.1", 1, true
+	}
+	res.Request = req
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if software != "" && res.Header.Get("Server") == "" {
+		res.Header.Set("Server", software)
+	}
+
+	switch {
+	case res.StatusCode < 200 || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified:
+		if res.Body != nil {
+			res.Body.Close()
+		}
+		// An unknown length keeps net/http from sending Content-Length: 0,
+		// which it does for responses to methods like PUT, RFC 9110
+		// section 8.6.
+		res.Body, res.ContentLength, res.TransferEncoding = http.NoBody, -1, nil
+		res.Header.Del("Content-Type")
+	case res.ContentLength < 0 && req.ProtoAtLeast(1, 1):
+		res.TransferEncoding = []string{"chunked"}
+	case res.ContentLength < 0:
+		res.TransferEncoding = nil
+	}
+}
+
+// bufferBody reads a body of unknown length into memory so that it is sent
+// with a Content-Length. The server's bodies are all held in memory.
+func bufferBody(res *http.Response) {
+	if res.ContentLength > 0 || res.Body == nil || res.Body == http.NoBody {
+		return
+	}
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		data = nil
+	}
+	res.ContentLength = int64(len(data))
+	res.Body = io.NopCloser(bytes.NewReader(data))
+}
+
+// allowedMethods returns the methods the resource at path supports. Files
+// that do not exist yet can only be created.
+func (s *Server) allowedMethods(path string) []string {
+	var methods []string
+	dav := s.WebDAV != nil
+	info, err := os.Stat(s.localPath(path))
+	switch {
+	case s.Metrics != nil && s.MetricsPath != "" && path == s.MetricsPath:
+		methods, dav = []string{http.MethodGet, http.MethodHead}, false
+	case strings.HasSuffix(path, "/") || err == nil && info.IsDir():
+		methods = []string{http.MethodGet, http.MethodHead}
+	case errors.Is(err, fs.ErrNotExist):
+		methods = []string{http.MethodPost}
+		if dav {
+			methods, dav = append(methods, http.MethodPut, "MKCOL", "LOCK"), false
+		}
+	default:
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch}
+		if dav {
+			methods = append(methods, http.MethodPut)
+		}
+	}
+	if dav {
+		methods = append(methods, http.MethodDelete, "COPY", "MOVE", "PROPFIND", "PROPPATCH", "LOCK", "UNLOCK")
+	}
+	methods = append(methods, http.MethodOptions)
+	if s.AllowTrace {
+		methods = append(methods, http.MethodTrace)
+	}
+	return methods
+}
+
+// cleanPath returns the canonical form of the request path p: rooted and
+// without dot segments or repeated slashes, keeping a trailing slash. It
+// reports false if p climbs above the root.
+func cleanPath(p string) (string, bool) {
+	depth := 0
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return "", false
+			}
+		default:
+			depth++
+		}
+	}
+	clean := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean, true
+}
+
+// WriteReadError answers a request that could not be read with the status
+// of its *parser.Error, 400 Bad Request for other malformed requests and 408
+// Request Timeout if it did not arrive in time. Nothing is sent if the client
+// went away.
+func WriteReadError(conn net.Conn, err error, software string) {
+	res := &http.Response{ProtoMajor: 1, ProtoMinor: 0, Header: make(http.Header), Close: true}
+	var perr *parser.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &perr):
+		res.StatusCode = perr.Status
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return
+	case errors.As(err, &netErr) && netErr.Timeout():
+		res.StatusCode = http.StatusRequestTimeout
+	case errors.As(err, &netErr):
+		return
+	default:
+		res.StatusCode = http.StatusBadRequest
+	}
+	res.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	res.Body = CreateBody(res.Status)
+	FrameResponse(&http.Request{ProtoMajor: 1, Method: http.MethodGet}, res, software)
+	bufferBody(res)
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	res.Write(conn)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long an idle bucket is kept before it is forgotten.
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimit configures the limits applied to each client below a path prefix.
+// Zero values leave that limit disabled.
+type RateLimit struct {
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Requests is the sustained number of requests per second.
+	Requests float64 `json:"requests" toml:"requests"`
+	// Burst is the number of requests allowed at once, at least 1.
+	Burst int `json:"burst" toml:"burst"`
+	// Bandwidth is the number of bytes per second read from or written to the client.
+	Bandwidth int64 `json:"bandwidth" toml:"bandwidth"`
+}
+
+// RateLimiter applies token bucket limits per client. Authenticated clients
+// are limited per user name, anonymous clients per IP address. The limit with
+// the longest matching prefix applies to a request.
+type RateLimiter struct {
+	Limits []RateLimit
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	pruned  time.Time
+}
+
+type bucketKey struct {
+	prefix    string
+	client    string
+	bandwidth bool
+}
+
+// NewRateLimiter creates a rate limiter enforcing limits.
+func NewRateLimiter(limits []RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Limits:  limits,
+		buckets: make(map[bucketKey]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// Allow takes a request token for the client sending req. If none is
+// available it returns false and how long the client should wait.
+func (r *RateLimiter) Allow(req *http.Request) (bool, time.Duration) {
+	limit := r.limitFor(req)
+	if limit == nil || limit.Requests <= 0 {
+		return true, 0
+	}
+
+	b := r.bucket(bucketKey{limit.Prefix, clientKey(req), false}, limit.Requests, float64(max(limit.Burst, 1)))
+	wait := b.take(1, false)
+	return wait == 0, wait
+}
+
+// Reader returns body throttled to the bandwidth limit of the client sending req.
+func (r *RateLimiter) Reader(req *http.Request, body io.ReadCloser) io.ReadCloser {
+	b := r.bandwidthBucket(req)
+	if b == nil {
+		return body
+	}
+	return &throttledReader{ReadCloser: body, bucket: b}
+}
+
+// Writer returns w throttled to the bandwidth limit of the client sending req.
+func (r *RateLimiter) Writer(req *http.Request, w io.Writer) io.Writer {
+	b := r.bandwidthBucket(req)
+	if b == nil {
+		return w
+	}
+	return &throttledWriter{Writer: w, bucket: b}
+}
+
+func (r *RateLimiter) bandwidthBucket(req *http.Request) *bucket {
+	limit := r.limitFor(req)
+	if limit == nil || limit.Bandwidth <= 0 {
+		return nil
+	}
+	rate := float64(limit.Bandwidth)
+	return r.bucket(bucketKey{limit.Prefix, clientKey(req), true}, rate, rate)
+}
+
+// limitFor returns the limit with the longest prefix matching req, or nil.
+func (r *RateLimiter) limitFor(req *http.Request) *RateLimit {
+	var match *RateLimit
+	for i := range r.Limits {
+		limit := &r.Limits[i]
+		if hasPathPrefix(req.URL.Path, limit.Prefix) && (match == nil || len(limit.Prefix) > len(match.Prefix)) {
+			match = limit
+		}
+	}
+	return match
+}
+
+func (r *RateLimiter) bucket(key bucketKey, rate, burst float64) *bucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.pruned) > bucketIdleTimeout {
+		for k, b := range r.buckets {
+			if b.idleSince(now) > bucketIdleTimeout {
+				delete(r.buckets, k)
+			}
+		}
+		r.pruned = now
+	}
+
+	b, ok := r.buckets[key]
+	if !ok || b.rate != rate || b.burst != burst {
+		b = &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+		r.buckets[key] = b
+	}
+	return b
+}
+
+// clientKey identifies the client sending req for rate limiting.
+func clientKey(req *http.Request) string {
+	if p := PrincipalFromRequest(req); p != nil {
+		return "user:" + p.Name
+	}
+	return "ip:" + remoteIP(req).String()
+}
+
+// bucket is a token bucket refilled at rate tokens per second up to burst.
+type bucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// take removes n tokens and returns zero, or returns how long until n tokens
+// are available. When reserve is set the tokens are taken regardless and the
+// caller is expected to wait the returned duration.
+func (b *bucket) take(n float64, reserve bool) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+
+	wait := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	if reserve {
+		b.tokens -= n
+	}
+	return wait
+}
+
+func (b *bucket) idleSince(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.last)
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	bucket *bucket
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > int(t.bucket.burst) {
+		p = p[:int(t.bucket.burst)]
+	}
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		time.Sleep(t.bucket.take(float64(n), true))
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	io.Writer
+	bucket *bucket
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), int(t.bucket.burst))]
+		time.Sleep(t.bucket.take(float64(len(chunk)), true))
+
+		n, err := t.Writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.Auth = newTestAuth(t)
+		s.RateLimiter = NewRateLimiter([]RateLimit{
+			{Prefix: "/", Requests: 100, Burst: 100},
+			{Prefix: "/limited", Requests: 0.1, Burst: 2},
+		})
+	})
+
+	send := func(path, user string) *http.Response {
+		req, _ := http.NewRequest("GET", "http://"+addr+path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := send("/limited/a.txt", ""); res.StatusCode != 404 {
+			t.Fatalf("request %d within burst: got status %d, want 404", i, res.StatusCode)
+		}
+	}
+
+	res := send("/limited/a.txt", "")
+	if res.StatusCode != 429 {
+		t.Fatalf("request over burst: got status %d, want 429", res.StatusCode)
+	}
+	if retry := res.Header.Get("Retry-After"); retry != "10" {
+		t.Errorf("got Retry-After %q, want 10", retry)
+	}
+
+	if res := send("/other.txt", ""); res.StatusCode != 404 {
+		t.Errorf("other prefix: got status %d, want 404", res.StatusCode)
+	}
+	if res := send("/limited/a.txt", "alice"); res.StatusCode != 404 {
+		t.Errorf("authenticated user has own bucket: got status %d, want 404", res.StatusCode)
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	limiter := NewRateLimiter([]RateLimit{{Prefix: "/", Bandwidth: 1000}})
+	req, _ := http.NewRequest("POST", "/upload.txt", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	start := time.Now()
+	body := limiter.Reader(req, io.NopCloser(strings.NewReader(strings.Repeat("x", 2500))))
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 1400*time.Millisecond {
+		t.Errorf("read 2500 bytes at 1000 B/s in %v", elapsed)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconfigureKeepsInFlightSettings(t *testing.T) {
+	var s *Server
+	tracker := NewConnTracker()
+	addr := startTestServer(t, func(srv *Server) {
+		s = srv
+		s.Conns = tracker
+		s.MIMETypes = map[string]string{".svg": "image/svg+xml"}
+		writeTestFile(t, filepath.Join(s.Root, "logo.svg"), "<svg/>")
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /logo.svg HTTP/1.0\r\n")
+	for i := 0; i < 50; i++ {
+		if conns := tracker.List(); len(conns) == 1 && conns[0].BytesRead > 0 {
+			break
+		}
+		time.Sleep(time.Duration(i) * time.Millisecond)
+	}
+
+	settings := s.CurrentSettings()
+	settings.MIMETypes = nil
+	s.Reconfigure(settings)
+
+	fmt.Fprint(conn, "\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("in-flight request got %d %q, want the old settings", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	res, err = http.Get("http://" + addr + "/logo.svg")
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("new request got %d, want the new settings", res.StatusCode)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	var s *Server
+	oldAddr := startTestServer(t, func(srv *Server) { s = srv })
+	writeTestFile(t, filepath.Join(s.Root, "a.txt"), "a")
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	if err := s.Rebind("127.0.0.1", port); err != nil {
+		t.Fatalf("rebind failed: %v", err)
+	}
+	newAddr := s.listener().Addr().String()
+
+	res, err := http.Get("http://" + newAddr + "/a.txt")
+	if err != nil {
+		t.Fatalf("request to the new address failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d from the new address", res.StatusCode)
+	}
+	if conn, err := net.Dial("tcp", oldAddr); err == nil {
+		conn.Close()
+		t.Errorf("old address %s still accepts connections", oldAddr)
+	}
+
+	s.Shutdown(time.Second)
+	if err := s.Rebind("127.0.0.1", port+1); err == nil {
+		t.Error("rebind after shutdown succeeded")
+	}
+}
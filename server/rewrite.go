@@ -0,0 +1,463 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RewriteRule rewrites or redirects the requests matching all its
+// conditions.
+type RewriteRule struct {
+	// Action is "rewrite", "redirect" or "slash".
+	Action string
+	// Status is the redirect status.
+	Status int
+	// Target is the rewritten path or the redirect location, with $1, ${name}
+	// and $path style references. For slash rules it is "add" or "remove".
+	Target string
+	Line   int
+	Text   string
+
+	conditions []rewriteCondition
+}
+
+// rewriteCondition matches one part of a request.
+type rewriteCondition struct {
+	// field is "path", "host", "method", "file" or a header name.
+	field   string
+	header  bool
+	negate  bool
+	methods []string
+	file    string
+	re      *regexp.Regexp
+}
+
+// RewriteRules is an ordered list of rewrite and redirect rules read from a
+// file. The first rule whose conditions all match a request is applied; the
+// file is reloaded when it changes.
+//
+// The file has one rule per line:
+//
+//	rewrite <path> <condition>...
+//	redirect 301|302|307|308 <location> <condition>...
+//	slash add|remove 301|302|307|308 <condition>...
+//
+// A rewrite serves another path internally, a redirect answers with the
+// location, and a slash rule redirects directories without a trailing slash
+// (add) or other paths with one (remove). Conditions are
+// [!]path|host|header:<Name>=<glob>, [!]path|host|header:<Name>~<regexp>,
+// method=<methods> and file=exists|missing. Globs match the whole value,
+// "*" and "?" within a path segment and "**" across segments. Each wildcard
+// and regexp group is captured: $1, $2... number them across the rule's
+// conditions, ${name} refers to named groups and $path, $query, $host
+// (without port) and $method to the request. A redirect keeps the query
+// unless its location has one.
+type RewriteRules struct {
+	file *watchedFile
+
+	mu    sync.RWMutex
+	rules []RewriteRule
+}
+
+// LoadRewriteRules reads a rules file from path and returns any errors that
+// occured.
+func LoadRewriteRules(path string) (*RewriteRules, error) {
+	r := &RewriteRules{}
+	file, err := newWatchedFile(path, r.parse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rewrite rules: %v", err)
+	}
+	r.file = file
+	return r, nil
+}
+
+// RewriteResult is the outcome of evaluating a request against the rules.
+type RewriteResult struct {
+	// Rule is the rule that matched, nil if none did.
+	Rule *RewriteRule `json:"-"`
+	// Line and Text identify the rule for the dry-run endpoint.
+	Line int    `json:"line,omitempty"`
+	Text string `json:"rule,omitempty"`
+	// Action is the matched rule's action.
+	Action string `json:"action,omitempty"`
+	// Status is the redirect status, zero for rewrites.
+	Status int `json:"status,omitempty"`
+	// Target is the rewritten request URI or the redirect location.
+	Target string `json:"target,omitempty"`
+}
+
+// Evaluate finds the rule applying to the request, whose files are served
+// from root.
+func (r *RewriteRules) Evaluate(req *http.Request, root string) RewriteResult {
+	r.file.refresh()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.rules {
+		rule := &r.rules[i]
+		vars, ok := rule.match(req, root)
+		if !ok {
+			continue
+		}
+
+		result := RewriteResult{Rule: rule, Line: rule.Line, Text: rule.Text, Action: rule.Action, Status: rule.Status}
+		switch rule.Action {
+		case "slash":
+			path := req.URL.Path + "/"
+			if rule.Target == "remove" {
+				path = strings.TrimSuffix(req.URL.Path, "/")
+			}
+			result.Target = (&url.URL{Path: path, RawQuery: req.URL.RawQuery}).String()
+		case "redirect":
+			result.Target = expandRewrite(rule.Target, vars)
+			if req.URL.RawQuery != "" && !strings.Contains(result.Target, "?") {
+				result.Target += "?" + req.URL.RawQuery
+			}
+		default:
+			result.Target = expandRewrite(rule.Target, vars)
+		}
+		return result
+	}
+	return RewriteResult{}
+}
+
+// match reports whether the rule applies to the request and returns the
+// values its target may refer to.
+func (rule *RewriteRule) match(req *http.Request, root string) (map[string]string, bool) {
+	vars := map[string]string{
+		"path":   req.URL.Path,
+		"query":  req.URL.RawQuery,
+		"host":   stripPort(req.Host),
+		"method": req.Method,
+	}
+
+	switch {
+	case rule.Action != "slash":
+	case rule.Target == "add":
+		if strings.HasSuffix(req.URL.Path, "/") || !isDir(filepath.Join(root, req.URL.Path)) {
+			return nil, false
+		}
+	default:
+		if req.URL.Path == "/" || !strings.HasSuffix(req.URL.Path, "/") || isDir(filepath.Join(root, req.URL.Path)) {
+			return nil, false
+		}
+	}
+
+	captures := 0
+	for _, c := range rule.conditions {
+		var matched bool
+		switch {
+		case c.methods != nil:
+			matched = matchMethod(c.methods, req.Method)
+		case c.file != "":
+			_, err := os.Stat(filepath.Join(root, req.URL.Path))
+			matched = (err == nil) == (c.file == "exists")
+		default:
+			value := req.Header.Get(c.field)
+			if !c.header {
+				value = vars[c.field]
+			}
+			groups := c.re.FindStringSubmatch(value)
+			matched = groups != nil
+			if matched && !c.negate {
+				for i, name := range c.re.SubexpNames()[1:] {
+					captures++
+					vars[strconv.Itoa(captures)] = groups[i+1]
+					if name != "" {
+						vars[name] = groups[i+1]
+					}
+				}
+			}
+		}
+		if matched == c.negate {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+func (r *RewriteRules) parse(data []byte) error {
+	var rules []RewriteRule
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseRewriteRule(fields)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		rule.Line, rule.Text = line, strings.Join(fields, " ")
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.rules = rules
+	r.mu.Unlock()
+	return nil
+}
+
+func parseRewriteRule(fields []string) (RewriteRule, error) {
+	rule := RewriteRule{Action: fields[0]}
+	var conditions []string
+	switch rule.Action {
+	case "rewrite":
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "/") {
+			return rule, fmt.Errorf("expected %q", "rewrite /<path> <condition>...")
+		}
+		rule.Target, conditions = fields[1], fields[2:]
+	case "redirect", "slash":
+		usage := "redirect 301|302|307|308 <location> <condition>..."
+		if rule.Action == "slash" {
+			usage = "slash add|remove 301|302|307|308 <condition>..."
+		}
+		if len(fields) < 3 {
+			return rule, fmt.Errorf("expected %q", usage)
+		}
+		status, target := fields[1], fields[2]
+		if rule.Action == "slash" {
+			status, target = fields[2], fields[1]
+			if target != "add" && target != "remove" {
+				return rule, fmt.Errorf("expected %q", usage)
+			}
+		}
+		switch status {
+		case "301", "302", "307", "308":
+			rule.Status, _ = strconv.Atoi(status)
+		default:
+			return rule, fmt.Errorf("invalid redirect status %q", status)
+		}
+		rule.Target, conditions = target, fields[3:]
+	default:
+		return rule, fmt.Errorf("unknown action %q", rule.Action)
+	}
+
+	for _, field := range conditions {
+		c, err := parseRewriteCondition(field)
+		if err != nil {
+			return rule, err
+		}
+		rule.conditions = append(rule.conditions, c)
+	}
+	return rule, nil
+}
+
+func parseRewriteCondition(field string) (rewriteCondition, error) {
+	var c rewriteCondition
+	text := field
+	if strings.HasPrefix(text, "!") {
+		c.negate, text = true, text[1:]
+	}
+	i := strings.IndexAny(text, "=~")
+	if i <= 0 {
+		return c, fmt.Errorf("invalid condition %q, expected <field>=<glob> or <field>~<regexp>", field)
+	}
+	key, op, value := text[:i], text[i], text[i+1:]
+
+	switch {
+	case key == "method" && op == '=':
+		c.methods = strings.Split(strings.ToUpper(value), ",")
+		return c, nil
+	case key == "file" && op == '=':
+		if value != "exists" && value != "missing" {
+			return c, fmt.Errorf("invalid condition %q, expected file=exists or file=missing", field)
+		}
+		c.file = value
+		return c, nil
+	case key == "path" || key == "host":
+		c.field = key
+	case strings.HasPrefix(key, "header:") && len(key) > len("header:"):
+		c.field, c.header = http.CanonicalHeaderKey(strings.TrimPrefix(key, "header:")), true
+	default:
+		return c, fmt.Errorf("invalid condition %q, unknown field %q", field, key)
+	}
+
+	expr := value
+	if op == '=' {
+		expr = globRegexp(value)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return c, fmt.Errorf("invalid condition %q: %v", field, err)
+	}
+	c.re = re
+	return c, nil
+}
+
+// globRegexp converts a glob to an anchored regular expression capturing
+// each wildcard.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString("(.*)")
+			i++
+		case glob[i] == '*':
+			b.WriteString("([^/]*)")
+		case glob[i] == '?':
+			b.WriteString("([^/])")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// expandRewrite replaces the $name and ${name} references in target with
+// their values; "$$" is a literal dollar sign.
+func expandRewrite(target string, vars map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		if target[i] != '$' || i+1 == len(target) {
+			b.WriteByte(target[i])
+			continue
+		}
+
+		rest := target[i+1:]
+		var name string
+		switch {
+		case rest[0] == '$':
+			b.WriteByte('$')
+			i++
+			continue
+		case rest[0] == '{':
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				b.WriteByte('$')
+				continue
+			}
+			name, i = rest[1:end], i+1+end
+		default:
+			n := 0
+			for n < len(rest) && isNameByte(rest[n], rest[0] >= '0' && rest[0] <= '9') {
+				n++
+			}
+			if n == 0 {
+				b.WriteByte('$')
+				continue
+			}
+			name, i = rest[:n], i+n
+		}
+		b.WriteString(vars[name])
+	}
+	return b.String()
+}
+
+// isNameByte reports whether c continues a reference, digits only for
+// numbered ones.
+func isNameByte(c byte, numbered bool) bool {
+	if c >= '0' && c <= '9' {
+		return true
+	}
+	return !numbered && (c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+}
+
+// isDir reports whether path is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// rewrite applies the server's rewrite rules to the request. It returns
+// the rewritten request and false if the request was answered with a
+// redirect.
+func (s *Server) rewrite(req *http.Request, res *http.Response) (*http.Request, bool) {
+	if s.Rewrites == nil {
+		return req, true
+	}
+	result := s.Rewrites.Evaluate(req, s.Root)
+	if result.Rule == nil {
+		return req, true
+	}
+
+	logger := LoggerFromRequest(req)
+	if result.Status != 0 {
+		logger.Debug("Redirected request", "rule", result.Line, "location", result.Target)
+		s.HandleRedirect(res, result.Status, result.Target)
+		return req, false
+	}
+
+	target, err := url.Parse(result.Target)
+	if err == nil {
+		var ok bool
+		if target.Path, ok = cleanPath(target.Path); !ok {
+			err = errors.New("path outside the root")
+		}
+	}
+	if err != nil {
+		logger.Warn("Invalid rewrite target", "rule", result.Line, "target", result.Target, "err", err)
+		s.HandleInternalServerError(res)
+		return req, false
+	}
+	logger.Debug("Rewrote request", "rule", result.Line, "target", result.Target)
+	rewritten := req.Clone(req.Context())
+	rewritten.URL.Path, rewritten.URL.RawPath = target.Path, ""
+	if strings.Contains(result.Target, "?") {
+		rewritten.URL.RawQuery = target.RawQuery
+	}
+	return rewritten, true
+}
+
+// HandleRedirect builds a redirect response to location.
+func (s *Server) HandleRedirect(res *http.Response, status int, location string) {
+	res.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	res.StatusCode = status
+	res.Header.Set("Location", location)
+	res.Body = CreateBody(res.Status)
+}
+
+// RewriteHandler evaluates the rewrite rules for the URL, method and
+// "Name: value" headers given as query parameters without applying them,
+// and answers with the matching rule as JSON.
+func (s *Server) RewriteHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		target, err := url.Parse(query.Get("url"))
+		if err != nil || query.Get("url") == "" {
+			http.Error(w, "the url parameter must be a URL", http.StatusBadRequest)
+			return
+		}
+		method := strings.ToUpper(query.Get("method"))
+		if method == "" {
+			method = http.MethodGet
+		}
+		req := &http.Request{Method: method, URL: target, Host: target.Host, Header: make(http.Header)}
+		for _, header := range query["header"] {
+			name, value, _ := strings.Cut(header, ":")
+			req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		server := s.snapshot()
+		if server.VirtualHosts != nil {
+			if host := server.VirtualHosts.Match(req.Host); host != nil {
+				server = server.withVirtualHost(host)
+			}
+		}
+		var result RewriteResult
+		if server.Rewrites != nil {
+			result = server.Rewrites.Evaluate(req, server.Root)
+		}
+		JSONHandler(func() any { return result }).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRewriteRules = `
+# Canonical host and HTTPS
+redirect 308 https://example.com$path  header:X-Forwarded-Proto=http
+redirect 301 http://example.com$path   host=www.example.com
+redirect 302 /docs/${page}             path~^/manual/(?P<page>[a-z]+)\.html$
+redirect 307 /v2/$2/$1                 path=/v1/*/** method=GET,HEAD
+slash    add 301
+slash    remove 308                    path=/blog/**
+rewrite  /index.html                   path=/app/** file=missing
+rewrite  /data.txt?from=$1             !host=example.org path=/api/*
+`
+
+func TestRewriteRules(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "rewrite.rules"), testRewriteRules)
+	rules, err := LoadRewriteRules(filepath.Join(dir, "rewrite.rules"))
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.Rewrites = rules
+	})
+	writeTestFile(t, filepath.Join(root, "index.html"), "spa")
+	writeTestFile(t, filepath.Join(root, "data.txt"), "data")
+	for _, d := range []string{"docs", "app"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0777); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "app", "app.txt"), "app file")
+
+	tests := []struct {
+		name, method, host, path string
+		header                   http.Header
+		status                   int
+		location, body           string
+	}{
+		{"https", "GET", "example.com", "/a.txt?x=1", http.Header{"X-Forwarded-Proto": {"http"}}, 308, "https://example.com/a.txt?x=1", ""},
+		{"canonical host", "GET", "www.example.com:8080", "/a.txt", nil, 301, "http://example.com/a.txt", ""},
+		{"named group", "GET", "", "/manual/intro.html", nil, 302, "/docs/intro", ""},
+		{"glob captures", "GET", "", "/v1/users/1/posts", nil, 307, "/v2/1/posts/users", ""},
+		{"method mismatch", "POST", "", "/v1/users/1", nil, 200, "", "200 OK"},
+		{"add slash", "GET", "", "/docs?page=2", nil, 301, "/docs/?page=2", ""},
+		{"remove slash", "GET", "", "/blog/post/", nil, 308, "/blog/post", ""},
+		{"spa fallback", "GET", "", "/app/settings/profile", nil, 200, "", "spa"},
+		{"existing file", "GET", "", "/app/app.txt", nil, 200, "", "app file"},
+		{"rewrite with query", "GET", "", "/api/items", nil, 200, "", "data"},
+		{"negated host", "GET", "example.org", "/api/items", nil, 404, "", ""},
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(test.method, "http://"+addr+test.path, strings.NewReader(""))
+			req.Host = test.host
+			for name, values := range test.header {
+				req.Header[name] = values
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != test.status || res.Header.Get("Location") != test.location || !strings.Contains(string(body), test.body) {
+				t.Errorf("got %d, location %q, body %q, want %d, %q, %q", res.StatusCode, res.Header.Get("Location"), body, test.status, test.location, test.body)
+			}
+		})
+	}
+}
+
+func TestRewriteRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		"rewrite index.html",
+		"redirect 303 /a",
+		"slash both 301",
+		"rewrite /a path",
+		"rewrite /a path~(",
+		"rewrite /a query=x",
+		"rewrite /a file=dir",
+		"forward /a",
+	} {
+		path := filepath.Join(t.TempDir(), "rewrite.rules")
+		writeTestFile(t, path, rules)
+		if _, err := LoadRewriteRules(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("%q: got %v, want an error on line 1", rules, err)
+		}
+	}
+}
+
+func TestRewriteHandler(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "rewrite.rules"), testRewriteRules)
+	rules, err := LoadRewriteRules(filepath.Join(dir, "rewrite.rules"))
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	s, err := CreateServer("127.0.0.1", 0, 10)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	s.Root, s.Rewrites = dir, rules
+
+	query := url.Values{"url": {"http://www.example.com/a.txt?x=1"}}
+	rec := httptest.NewRecorder()
+	s.RewriteHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/rewrites?"+query.Encode(), nil))
+	var result RewriteResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	want := RewriteResult{Line: 4, Text: "redirect 301 http://example.com$path host=www.example.com", Action: "redirect", Status: 301, Target: "http://example.com/a.txt?x=1"}
+	if result != want {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+
+	rec = httptest.NewRecorder()
+	s.RewriteHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/rewrites?url=/v1/a/b&method=post", nil))
+	if strings.TrimSpace(rec.Body.String()) != "{}" {
+		t.Errorf("got %s for a request matching no rule", rec.Body)
+	}
+}
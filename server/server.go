@@ -2,89 +2,389 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"lab1/parser"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Server is a simple implementation of an HTTP/1.0 web server for serving static files.
 type Server struct {
-	Address  string
-	Port     int
+	Address string
+	Port    int
+	// Listeners are the addresses Listen binds. Without any, it listens on
+	// TCP at Address and Port.
+	Listeners []*Listener
+	// Listener is the first of the open listeners.
 	Listener net.Listener
 	Sem      chan bool
+
+	// Root is the directory files are served from and stored in.
+	Root string
+
+	// Settings can be replaced with Reconfigure while the server runs.
+	Settings
+
+	// Metrics, when set, records request, connection and storage metrics.
+	Metrics *Metrics
+	// MetricsPath, when set along with Metrics, is the path on which GET
+	// requests are answered with the metrics instead of a file.
+	MetricsPath string
+	// Tracer, when set, records a span for every request. Trace context is
+	// continued from incoming traceparent headers either way.
+	Tracer *Tracer
+	// Conns, when set, tracks the open connections.
+	Conns *ConnTracker
+
+	state *serverState
+}
+
+// Settings are the parts of a server's configuration that can be replaced
+// while it runs.
+type Settings struct {
+	// Auth, when set, authenticates requests before they reach the handlers.
+	Auth *Auth
+	// ACL, when set, decides which clients may access which paths.
+	ACL *ACL
+	// Presigner, when set, admits requests carrying a valid presigned URL.
+	Presigner *Presigner
+	// RateLimiter, when set, limits the request rate and bandwidth of each client.
+	RateLimiter *RateLimiter
+	// CORS, when set, answers preflights and adds CORS headers to responses.
+	CORS *CORS
+	// SecurityHeaders, when set, are added to every response.
+	SecurityHeaders *SecurityHeaders
+	// IPFilter, when set, decides which clients may connect.
+	IPFilter *IPFilter
+	// AccessLog, when set, receives an entry for every handled request.
+	AccessLog *AccessLog
+	// Logger receives the server's diagnostic output. Defaults to slog.Default().
+	Logger *slog.Logger
+	// MIMETypes maps file extensions, including the dot, to content types
+	// in addition to the built-in ones.
+	MIMETypes map[string]string
+	// Index, when set, is the file served for requests to a directory.
+	Index string
+	// Listing lists the files of directories without an index.
+	Listing bool
+	// Rewrites, when set, rewrites and redirects requests before the access
+	// checks.
+	Rewrites *RewriteRules
+	// VirtualHosts, when set, serves requests from the root and settings of
+	// the virtual host named by their Host header.
+	VirtualHosts *VirtualHosts
+	// ErrorPages, when set, are the custom documents sent with error
+	// responses. Error bodies are negotiated either way.
+	ErrorPages *ErrorPages
+	// Parsing configures how strictly requests are parsed.
+	Parsing parser.Options
+	// AllowTrace enables the TRACE method, which echoes requests back for
+	// debugging.
+	AllowTrace bool
+	// WebDAV, when set, serves the WebDAV methods on the root.
+	WebDAV *WebDAV
+}
+
+// serverState is shared by a server and the snapshots its connections are
+// handled with.
+type serverState struct {
+	mu        sync.RWMutex
+	draining  atomic.Bool
+	upgrading atomic.Bool
+	closeOnce sync.Once
+	closed    bool
+	listeners []*Listener
+	accepted  chan net.Conn
+	done      chan struct{}
 }
 
 // CreateServer tries to create an HTTP server on the specified port and address.
+// Its Root has to be set before it serves files.
 func CreateServer(address string, port, maxConnections int) (*Server, error) {
-	// Init fs directory if nonexistent
-	CreateFsDir()
-
 	if maxConnections < 1 || maxConnections > 10 {
 		return nil, fmt.Errorf("invalid amount of maximum number of connections (1-10), got %d", maxConnections)
 	}
 
 	return &Server{
-		Address: address,
-		Port:    port,
-		Sem:     createSemaphore(maxConnections),
+		Address:  address,
+		Port:     port,
+		Sem:      createSemaphore(maxConnections),
+		Settings: Settings{Logger: slog.Default()},
+		state:    &serverState{accepted: make(chan net.Conn), done: make(chan struct{})},
 	}, nil
 }
 
 // Listen establishes a socket connection and listens for incoming connections.
 func (s *Server) Listen() error {
-	var err error
-	s.Listener, err = net.Listen("tcp", s.addr())
-	if err != nil {
-		log.Printf("Error starting server on %s: %v", s.addr(), err)
+	listeners := s.Listeners
+	if len(listeners) == 0 {
+		listeners = []*Listener{{Network: "tcp", Address: s.addr()}}
+	}
+	if err := s.SetListeners(listeners); err != nil {
+		s.logger().Error("Error starting server", "err", err)
 		return err
 	}
-	log.Printf("Listening for connections on %s", s.addr())
+	if s.Metrics != nil {
+		s.Metrics.TrackSemaphore(s.Sem)
+		s.Metrics.TrackStorage(s.Root)
+	}
+	if err := notifyUpgraded(); err != nil {
+		s.logger().Error("Error finishing upgrade", "err", err)
+	}
 	return nil
 }
 
-// Serve accepts and handles connections in goroutines.
+// Serve accepts and handles connections in goroutines. Connections rejected
+// by the IP filter are closed before they take up a connection slot.
 func (s *Server) Serve() error {
 	for {
-		select {
-		case <-s.Sem:
-			conn, err := s.Listener.Accept()
+		conn, err := s.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger().Error("Error accepting connection", "err", err)
+			continue
+		}
+
+		// Unix domain socket clients have no address to filter on.
+		if filter := s.snapshot().IPFilter; filter != nil && !isUnix(conn) && !filter.Allowed(connIP(conn)) {
+			s.logger().Warn("Rejected connection", "remote_addr", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+
+		if s.Conns != nil {
+			conn = s.Conns.Track(conn)
+		}
+
+		waitStart := time.Now()
+		<-s.Sem
+		if s.Metrics != nil {
+			s.Metrics.AcceptWait.ObserveDuration(time.Since(waitStart))
+		}
+		go func() {
+			err := s.HandleConnection(conn)
 			if err != nil {
-				log.Printf("Error accepting connection: %v", err)
-				continue
+				s.logger().Debug("Error handling connection", "err", err)
+			}
+			s.Sem <- true
+		}()
+	}
+}
+
+// Accept waits for the next connection accepted by any of the server's
+// listeners. It returns net.ErrClosed once the server is shut down.
+func (s *Server) Accept() (net.Conn, error) {
+	select {
+	case conn := <-s.state.accepted:
+		return conn, nil
+	case <-s.state.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// SetListeners replaces the server's open listeners with listeners. Sockets
+// that stay bound to the same address are kept, taking over the new TLS and
+// limit settings, new ones are opened before the removed ones are closed,
+// and connections accepted earlier are handled to completion. If a listener
+// fails to open, the open listeners are left unchanged.
+func (s *Server) SetListeners(listeners []*Listener) error {
+	s.state.mu.Lock()
+	if s.state.closed {
+		s.state.mu.Unlock()
+		return net.ErrClosed
+	}
+
+	current := make(map[string]*Listener)
+	for _, l := range s.state.listeners {
+		current[l.endpoint()] = l
+	}
+	var next, opened []*Listener
+	for _, l := range listeners {
+		if old, ok := current[l.endpoint()]; ok {
+			next = append(next, old)
+			continue
+		}
+		if err := l.open(); err != nil {
+			s.state.mu.Unlock()
+			for _, l := range opened {
+				l.ln.Close()
 			}
-			go func() {
-				err := s.HandleConnection(conn)
-				if err != nil {
-					log.Printf("Error handling connection: %v", err)
-				}
-				s.Sem <- true
-			}()
+			return fmt.Errorf("failed to listen on %s: %v", l.endpoint(), err)
 		}
+		next, opened = append(next, l), append(opened, l)
 	}
+
+	kept := make(map[*Listener]bool)
+	for i, l := range listeners {
+		if next[i] != l {
+			next[i].update(l)
+		}
+		kept[next[i]] = true
+	}
+	var closed []*Listener
+	for _, l := range s.state.listeners {
+		if !kept[l] {
+			closed = append(closed, l)
+		}
+	}
+	s.state.listeners = next
+	s.Listener = nil
+	if len(next) > 0 {
+		s.Listener = next[0].ln
+	}
+	s.state.mu.Unlock()
+
+	for _, l := range opened {
+		s.logger().Info("Listening for connections", "listener", l.name(), "addr", l.Addr().String(), "tls", l.TLS != nil)
+		go s.acceptLoop(l)
+	}
+	for _, l := range closed {
+		s.logger().Info("Closing listener", "listener", l.name())
+		l.ln.Close()
+	}
+	return nil
 }
 
-// HandleConnection manages incoming HTTP requests from client connections.
+// Rebind replaces the server's listeners with a TCP listener on address
+// and port.
+func (s *Server) Rebind(address string, port int) error {
+	if err := s.SetListeners([]*Listener{{Network: "tcp", Address: net.JoinHostPort(address, strconv.Itoa(port))}}); err != nil {
+		return err
+	}
+	s.state.mu.Lock()
+	s.Address, s.Port = address, port
+	s.state.mu.Unlock()
+	return nil
+}
+
+// acceptLoop passes the connections accepted by l on to Accept until l is
+// closed.
+func (s *Server) acceptLoop(l *Listener) {
+	for {
+		conn, err := l.accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger().Error("Error accepting connection", "listener", l.name(), "err", err)
+			continue
+		}
+
+		select {
+		case s.state.accepted <- conn:
+		case <-s.state.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Reconfigure replaces the server's settings. Connections accepted from now
+// on use the new settings, those already being handled finish with the
+// settings they started with.
+func (s *Server) Reconfigure(settings Settings) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	s.Settings = settings
+}
+
+// CurrentSettings returns the settings new connections are handled with.
+func (s *Server) CurrentSettings() Settings {
+	return s.snapshot().Settings
+}
+
+// snapshot returns a copy of the server that is not affected by later calls
+// to Reconfigure or SetListeners.
+func (s *Server) snapshot() *Server {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	c := *s
+	return &c
+}
+
+// listener returns the server's current listener.
+func (s *Server) listener() net.Listener {
+	return s.snapshot().Listener
+}
+
+// HandleConnection manages incoming HTTP requests from client connections,
+// using the settings in effect when it is called.
 func (s *Server) HandleConnection(conn net.Conn) error {
+	s = s.snapshot()
 	remoteAddr := conn.RemoteAddr().String()
 	defer conn.Close()
-	log.Printf("Handling connection from %s", remoteAddr)
+	logger := s.logger().With("remote_addr", remoteAddr)
+	logger.Debug("Handling connection")
 
-	req, err := http.ReadRequest(bufio.NewReader(conn))
+	start := time.Now()
+	req, err := parser.ReadRequest(bufio.NewReader(conn), s.Parsing)
 
 	if err != nil {
-		if err != io.EOF {
-			log.Printf("Error reading request from %s: %v", remoteAddr, err)
+		var perr *parser.Error
+		if errors.As(err, &perr) {
+			logger.Info("Rejected malformed request", "status", perr.Status, "reason", perr.Reason)
+			WriteReadError(conn, err, Software)
+		} else if err != io.EOF {
+			logger.Warn("Error reading request", "err", err)
+			WriteReadError(conn, err, Software)
 		} else {
-			log.Printf("Client %s closed the connection", remoteAddr)
+			logger.Debug("Client closed the connection")
 		}
 		return err
 	}
+	req.RemoteAddr = remoteAddr
+	req.TLS = connTLS(conn)
+	// Access checks and handlers all see the canonical path, so that "..",
+	// "." and repeated slashes cannot get around rules for a prefix.
+	inRoot := true
+	if req.RequestURI != "*" {
+		var clean string
+		if clean, inRoot = cleanPath(req.URL.Path); inRoot {
+			req.URL.Path, req.URL.RawPath = clean, ""
+		}
+	}
+	requestID := req.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = NewRequestID()
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	span := s.Tracer.Start(TraceContextFromHeader(req.Header), req.Method+" "+req.URL.Path, SpanServer)
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.target", req.RequestURI)
+	span.SetAttr("request_id", requestID)
+	defer span.Finish()
+
+	var host *VirtualHost
+	if s.VirtualHosts != nil {
+		if host = s.VirtualHosts.Match(req.Host); host != nil {
+			s = s.withVirtualHost(host)
+			logger = logger.With("host", host.name())
+		}
+	}
+
+	logger = logger.With("request_id", requestID, "trace_id", hex.EncodeToString(span.Context.TraceID[:]), "path", req.URL.Path)
+	req = WithSpan(WithLogger(req, logger), span)
+	SetConnState(conn, ConnHandling, req)
+	expectContinue(conn, req)
+	bodyIn := &CountingReader{ReadCloser: req.Body}
+	req.Body = bodyIn
 
 	res := &http.Response{
 		Status:     "200 OK",
@@ -92,34 +392,124 @@ func (s *Server) HandleConnection(conn net.Conn) error {
 		Proto:      "HTTP/1.0",
 		ProtoMajor: 1,
 		ProtoMinor: 0,
+		Header:     make(http.Header),
 		Body:       io.NopCloser(strings.NewReader("")),
 	}
+	if !s.checkProtocol(req, res) {
+		// The request cannot be handled in its HTTP version.
+	} else if !inRoot {
+		logger.Info("Rejected path outside the root")
+		s.HandleBadRequest(res)
+	} else if s.VirtualHosts != nil && host == nil {
+		s.HandleUnknownHost(res)
+	} else if req.RequestURI == "*" {
+		s.handleAsterisk(req, res)
+	} else if s.CORS != nil && IsPreflight(req) {
+		// Preflights carry no credentials and are answered before the access checks.
+		if s.CORS.Preflight(req, res) {
+			s.HandleNoContent(res)
+		} else {
+			s.HandleForbidden(res)
+		}
+	} else {
+		var ok bool
+		if req, ok = s.rewrite(req, res); ok {
+			if req, ok = s.admit(req, res); ok {
+				s.dispatch(req, res)
+			}
+		}
+		if s.CORS != nil {
+			s.CORS.Apply(req, res)
+		}
+	}
+	s.ErrorPages.Render(req, res)
+	if s.SecurityHeaders != nil {
+		s.SecurityHeaders.Apply(req, res)
+	}
+	if s.IPFilter != nil {
+		s.IPFilter.Record(remoteIP(req), res.StatusCode)
+	}
+	res.Header.Set("X-Request-ID", requestID)
+	span.SetAttr("http.status_code", res.StatusCode)
+	bufferBody(res)
+	FrameResponse(req, res, Software)
+
+	var w io.Writer = conn
+	if s.RateLimiter != nil {
+		w = s.RateLimiter.Writer(req, conn)
+	}
+	body := &CountingReader{ReadCloser: res.Body}
+	res.Body = body
+	SetConnState(conn, ConnWriting, nil)
+	err = res.Write(w)
+	if err != nil {
+		logger.Warn("Error writing response", "err", err)
+	}
+
+	logger.Debug("Handled request", "method", req.Method, "status", res.StatusCode, "duration", time.Since(start))
+	if s.Metrics != nil {
+		s.Metrics.ObserveRequest(req.Method, res.StatusCode, time.Since(start), bodyIn.N, body.N)
+	}
+	if s.AccessLog != nil {
+		entry := NewAccessEntry(req, start)
+		entry.Status, entry.Bytes, entry.Duration = res.StatusCode, body.N, time.Since(start)
+		s.AccessLog.Log(entry)
+	}
+	return err
+}
+
+// dispatch passes the request on to the handler for its method. HEAD is
+// handled as GET, with the body left out when the response is written.
+func (s *Server) dispatch(req *http.Request, res *http.Response) {
+	if isSidecar(req.URL.Path) {
+		s.HandleNotFound(res)
+		return
+	}
+	if s.WebDAV != nil && slices.Contains(davMethods, req.Method) {
+		s.HandleWebDAV(req, res)
+		return
+	}
+	allowed := s.allowedMethods(req.URL.Path)
 	switch req.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
+		if s.Metrics != nil && s.MetricsPath != "" && req.URL.Path == s.MetricsPath {
+			s.HandleMetrics(res)
+			return
+		}
 		s.HandleGet(req, res)
 	case http.MethodPost:
+		if !slices.Contains(allowed, http.MethodPost) {
+			s.HandleMethodNotAllowed(res, allowed)
+			return
+		}
+		if s.WebDAV != nil && !s.davUnlocked(req, res, s.localPath(req.URL.Path), false, true) {
+			return
+		}
 		s.HandlePost(req, res)
-	case http.MethodHead:
-		s.HandleNotImplemented(res)
-	case http.MethodPut:
-		s.HandleNotImplemented(res)
-	case http.MethodDelete:
-		s.HandleNotImplemented(res)
-	case http.MethodConnect:
-		s.HandleNotImplemented(res)
+	case http.MethodPatch:
+		if !slices.Contains(allowed, http.MethodPatch) {
+			s.HandleMethodNotAllowed(res, allowed)
+			return
+		}
+		if s.WebDAV != nil && !s.davUnlocked(req, res, s.localPath(req.URL.Path), false, false) {
+			return
+		}
+		s.HandlePatch(req, res)
 	case http.MethodOptions:
-		s.HandleNotImplemented(res)
+		s.HandleOptions(req, res)
 	case http.MethodTrace:
-		s.HandleNotImplemented(res)
+		if !s.AllowTrace {
+			s.HandleNotImplemented(res)
+			res.Header.Set("Allow", strings.Join(allowed, ", "))
+			return
+		}
+		s.HandleTrace(req, res)
 	default:
-		s.HandleBadRequest(res)
-	}
-
-	err = res.Write(conn)
-	if err != nil {
-		log.Printf("Error writing response to %s: %v", remoteAddr, err)
+		// Methods the server does not implement for any resource,
+		// including unknown ones, RFC 9110 section 9.1.
+		s.HandleNotImplemented(res)
+		res.Header.Set("Allow", strings.Join(allowed, ", "))
 	}
-	return err
 }
 
 // DetermineContentType checks the file extension of a request.
@@ -136,37 +526,75 @@ func DetermineContentType(req *http.Request) (string, error) {
 		return "image/jpeg", nil
 	case ".txt", "":
 		return "text/plain", nil
+	case ".json":
+		return "application/json", nil
 	default:
-		log.Printf("Invalid content type for extension: %s", ext)
 		return "", fmt.Errorf("unsupported content type: %s", ext)
 	}
 }
 
+// contentType determines the content type of a request from the server's
+// MIME types, falling back to the built-in ones.
+func (s *Server) contentType(req *http.Request) (string, error) {
+	if contentType, ok := s.MIMETypes[filepath.Ext(req.URL.Path)]; ok {
+		return contentType, nil
+	}
+	return DetermineContentType(req)
+}
+
+// localPath returns the file the request path p names, which is always
+// inside the root.
+func (s *Server) localPath(p string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
 // HandleGet serves GET requests.
 func (s *Server) HandleGet(req *http.Request, res *http.Response) {
-	contentType, err := DetermineContentType(req)
+	filePath := s.localPath(req.URL.Path)
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		s.HandleDirectory(req, res)
+		return
+	}
+
+	contentType, err := s.contentType(req)
 	if err != nil {
-		log.Printf("Error determining content type: %v", err)
-		s.HandleBadRequest(res)
+		LoggerFromRequest(req).Info("Error determining content type", "err", err)
+		// Files of unsupported types are not served, whether they exist or
+		// not is still reported.
+		if _, statErr := os.Stat(filePath); os.IsNotExist(statErr) {
+			s.HandleNotFound(res)
+		} else {
+			s.HandleForbidden(res)
+		}
 		return
 	}
 
-	res.Header = make(http.Header)
 	res.Header.Set("Content-Type", contentType)
+	if info, err := os.Stat(filePath); err == nil {
+		res.Header.Set("ETag", fileETag(info))
+	}
 
-	filePath := filepath.Join(os.Getenv("FS"), req.URL.Path)
 	data, err := GetFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			s.HandleNotFound(res)
 		} else {
-			log.Printf("Error reading file %s: %v", filePath, err)
+			LoggerFromRequest(req).Error("Error reading file", "file", filePath, "err", err)
 			s.HandleInternalServerError(res)
 		}
 		return
 	}
 
-	res.Body = io.NopCloser(strings.NewReader(string(data)))
+	res.ContentLength = int64(len(data))
+	res.Body = io.NopCloser(bytes.NewReader(data))
+}
+
+// HandleMetrics answers with the server's metrics.
+func (s *Server) HandleMetrics(res *http.Response) {
+	var buf bytes.Buffer
+	s.Metrics.WriteTo(&buf)
+	res.Header.Set("Content-Type", MetricsContentType)
+	res.Body = io.NopCloser(&buf)
 }
 
 // HandlePost processes POST requests.
@@ -176,24 +604,25 @@ func (s *Server) HandlePost(req *http.Request, res *http.Response) {
 		s.HandleBadRequest(res)
 		return
 	}
-	filePath := filepath.Join(os.Getenv("FS"), req.URL.Path)
+	filePath := s.localPath(req.URL.Path)
 
-	_, err := DetermineContentType(req)
+	_, err := s.contentType(req)
 	if err != nil {
-		s.HandleBadRequest(res)
+		LoggerFromRequest(req).Info("Rejected upload of unsupported content type", "err", err)
+		s.HandleUnsupportedMediaType(res)
 		return
 	}
 
 	data, err := io.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		LoggerFromRequest(req).Warn("Error reading request body", "err", err)
 		s.HandleBadRequest(res)
 		return
 	}
 
 	err = WriteFile(filePath, data)
 	if err != nil {
-		log.Printf("Error writing to file %s with data %s: %v", filePath, data, err)
+		LoggerFromRequest(req).Error("Error writing to file", "file", filePath, "err", err)
 		s.HandleInternalServerError(res)
 		return
 	}
@@ -208,14 +637,75 @@ func (s *Server) HandleNotFound(res *http.Response) {
 	res.Body = io.NopCloser(strings.NewReader("404 Not Found"))
 }
 
-// HandleNotFound builds a 404 Not Found response.
+// HandleNoContent builds a 204 No Content response.
+func (s *Server) HandleNoContent(res *http.Response) {
+	res.Status = "204 No Content"
+	res.StatusCode = 204
+	res.Body = io.NopCloser(strings.NewReader(""))
+}
+
+// HandleUnauthorized builds a 401 Unauthorized response.
+func (s *Server) HandleUnauthorized(res *http.Response) {
+	res.Status = "401 Unauthorized"
+	res.StatusCode = 401
+	res.Body = io.NopCloser(strings.NewReader("401 Unauthorized"))
+}
+
+// HandleForbidden builds a 403 Forbidden response.
+func (s *Server) HandleForbidden(res *http.Response) {
+	res.Status = "403 Forbidden"
+	res.StatusCode = 403
+	res.Body = io.NopCloser(strings.NewReader("403 Forbidden"))
+}
+
+// HandleTooManyRequests builds a 429 Too Many Requests response asking the
+// client to retry after wait.
+func (s *Server) HandleTooManyRequests(res *http.Response, wait time.Duration) {
+	res.Status = "429 Too Many Requests"
+	res.StatusCode = 429
+	res.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	res.Body = io.NopCloser(strings.NewReader("429 Too Many Requests"))
+}
+
+// HandleMethodNotAllowed builds a 405 Method Not Allowed response listing
+// the allowed methods.
+func (s *Server) HandleMethodNotAllowed(res *http.Response, allowed []string) {
+	res.Status = "405 Method Not Allowed"
+	res.StatusCode = 405
+	res.Header.Set("Allow", strings.Join(allowed, ", "))
+	res.Body = io.NopCloser(strings.NewReader("405 Method Not Allowed"))
+}
+
+// HandleUnsupportedMediaType builds a 415 Unsupported Media Type response.
+func (s *Server) HandleUnsupportedMediaType(res *http.Response) {
+	res.Status = "415 Unsupported Media Type"
+	res.StatusCode = 415
+	res.Body = io.NopCloser(strings.NewReader("415 Unsupported Media Type"))
+}
+
+// HandleExpectationFailed builds a 417 Expectation Failed response.
+func (s *Server) HandleExpectationFailed(res *http.Response) {
+	res.Status = "417 Expectation Failed"
+	res.StatusCode = 417
+	res.Body = io.NopCloser(strings.NewReader("417 Expectation Failed"))
+}
+
+// HandleHTTPVersionNotSupported builds a 505 HTTP Version Not Supported
+// response.
+func (s *Server) HandleHTTPVersionNotSupported(res *http.Response) {
+	res.Status = "505 HTTP Version Not Supported"
+	res.StatusCode = 505
+	res.Body = io.NopCloser(strings.NewReader("505 HTTP Version Not Supported"))
+}
+
+// HandleNotImplemented builds a 501 Not Implemented response.
 func (s *Server) HandleNotImplemented(res *http.Response) {
 	res.Status = "501 Not Implemented"
 	res.StatusCode = 501
 	res.Body = io.NopCloser(strings.NewReader("501 Not Implemented"))
 }
 
-// HandleNotFound builds a 404 Not Found response.
+// HandleBadRequest builds a 400 Bad Request response.
 func (s *Server) HandleBadRequest(res *http.Response) {
 	res.Status = "400 Bad Request"
 	res.StatusCode = 400
@@ -233,11 +723,124 @@ func CreateBody(text string) io.ReadCloser {
 	return io.NopCloser(strings.NewReader(text + "\n"))
 }
 
-// Close attempts to close the server's listener and logs any error.
+// SetDraining marks the server as draining, failing its readiness check so
+// load balancers stop sending it traffic while it keeps serving.
+func (s *Server) SetDraining(draining bool) {
+	if s.state.draining.Swap(draining) != draining {
+		s.logger().Info("Changed draining state", "draining", draining)
+	}
+}
+
+// Draining reports whether the server is draining.
+func (s *Server) Draining() bool {
+	return s.state.draining.Load()
+}
+
+// DrainHandler starts draining on POST and stops on DELETE.
+func (s *Server) DrainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			s.SetDraining(true)
+		case http.MethodDelete:
+			s.SetDraining(false)
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Shutdown drains the server, stops accepting connections and waits up to
+// timeout for the connections being handled to finish. Serve returns once
+// the listener is closed.
+func (s *Server) Shutdown(timeout time.Duration) error {
+	s.SetDraining(true)
+	if s.close() {
+		s.logger().Info("Shutting down", "timeout", timeout)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for active := s.activeConnections(); active > 0; active = s.activeConnections() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d connections still active after %v", active, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// Ready reports why the server should not receive traffic, nil if it should.
+func (s *Server) Ready() error {
+	switch {
+	case s.listener() == nil:
+		return errors.New("not listening")
+	case s.Draining():
+		return errors.New("draining")
+	}
+	return nil
+}
+
+// CheckRoot verifies that files can be stored in the server's root.
+func (s *Server) CheckRoot() error {
+	f, err := os.CreateTemp(s.Root, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("root is not writable: %v", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Stats returns the server's connection usage.
+func (s *Server) Stats() any {
+	return map[string]any{
+		"connections_active": s.activeConnections(),
+		"connection_slots":   cap(s.Sem),
+		"draining":           s.Draining(),
+	}
+}
+
+// activeConnections returns the number of connections being handled.
+func (s *Server) activeConnections() int {
+	return cap(s.Sem) - len(s.Sem)
+}
+
+// Close stops accepting connections, closing the server's listeners and
+// logging any errors. Serve returns once it is closed.
 func (s *Server) Close() {
-	if err := s.Listener.Close(); err != nil {
-		log.Printf("Error closing server listener: %v", err)
+	s.close()
+}
+
+// close closes the server's listeners and reports whether they were open.
+func (s *Server) close() bool {
+	closed := false
+	s.state.closeOnce.Do(func() {
+		closed = true
+		s.state.mu.Lock()
+		s.state.closed = true
+		listeners := s.state.listeners
+		close(s.state.done)
+		s.state.mu.Unlock()
+
+		for _, l := range listeners {
+			if err := l.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.logger().Error("Error closing server listener", "listener", l.name(), "err", err)
+			}
+		}
+	})
+	return closed
+}
+
+// logger returns the server's logger, falling back to the default logger.
+func (s *Server) logger() *slog.Logger {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	if s.Logger == nil {
+		return slog.Default()
 	}
+	return s.Logger
 }
 
 // addr returns the server's address and port as a string.
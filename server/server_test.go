@@ -5,12 +5,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
-
-	"github.com/joho/godotenv"
 )
 
 type testReq struct {
@@ -28,20 +27,25 @@ func TestMain(m *testing.M) {
 	cleanup()
 }
 
+// testRoot is the root of the server started by setup. It is created by
+// the first upload, inside a temporary directory.
+var testRoot string
+
 func setup() {
-	err := godotenv.Load("../.env")
+	dir, err := os.MkdirTemp("", "fs")
 	if err != nil {
 		panic(err)
 	}
+	testRoot = filepath.Join(dir, "fs")
 	log.Println("Setup: creating server")
 	server, _ := CreateServer("0.0.0.0", 8080, 10)
+	server.Root = testRoot
 	server.Listen()
 	go server.Serve()
 }
 
 func cleanup() {
-	fsPath := os.Getenv("FS")
-	os.RemoveAll(fsPath)
+	os.RemoveAll(filepath.Dir(testRoot))
 }
 
 func TestGetNotFound(t *testing.T) {
@@ -49,6 +53,7 @@ func TestGetNotFound(t *testing.T) {
 		{name: "Get non existant file", reqType: "GET", path: "", want: "404 Not Found"},
 		{name: "Get non existant file", reqType: "GET", path: "/path", want: "404 Not Found"},
 		{name: "Get non existant file", reqType: "GET", path: "/hej", want: "404 Not Found"},
+		{name: "Head non existant file", reqType: "HEAD", path: "/hej", want: "404"},
 	}
 
 	for _, tr := range tests {
@@ -58,7 +63,7 @@ func TestGetNotFound(t *testing.T) {
 
 func TestPostContentType(t *testing.T) {
 	tests := []testReq{
-		{name: "Post .exe", reqType: "POST", path: "/path.exe", want: "400 Bad Request", body: "Hello world"},
+		{name: "Post .exe", reqType: "POST", path: "/path.exe", want: "415 Unsupported Media Type", body: "Hello world"},
 		{name: "Post .css", reqType: "POST", path: "/path.css", want: "200 OK", body: "Hello world"},
 		{name: "Post .jpg", reqType: "POST", path: "/path.jpg", want: "200 OK", body: "Hello world"},
 		{name: "Post .jpeg", reqType: "POST", path: "/path.jpeg", want: "200 OK", body: "Hello world"},
@@ -77,7 +82,7 @@ func TestPostFile(t *testing.T) {
 		{name: "Get non-existent file", reqType: "GET", path: "/tesasdfasdft.txt", want: "404 Not Found"},
 		{name: "Post test.txt", reqType: "POST", path: "/test.txt", want: "200 OK", body: "Hello world"},
 		{name: "Get test.txt", reqType: "GET", path: "/test.txt", want: "Hello world"},
-		{name: "Post without specifying filename", reqType: "POST", path: "", want: "400 Bad Request"},
+		{name: "Post without specifying filename", reqType: "POST", path: "", want: "405 Method Not Allowed"},
 	}
 
 	for _, tr := range tests {
@@ -120,7 +125,6 @@ func TestNotImplemented(t *testing.T) {
 	tests := []testReq{
 		{name: "Send DELETE", reqType: "DELETE", path: "/testdir/test1.txt", want: "501 Not Implemented"},
 		{name: "Send PUT", reqType: "PUT", path: "/testdir/test1.txt", want: "501 Not Implemented"},
-		{name: "Send HEAD", reqType: "HEAD", path: "/testdir/test1.txt", want: "501"},
 	}
 
 	for _, tr := range tests {
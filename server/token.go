@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Operations a bearer token can be scoped to.
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpDelete = "delete"
+	OpList   = "list"
+)
+
+// Scope grants a set of operations on the paths below Prefix.
+type Scope struct {
+	Prefix string   `json:"prefix"`
+	Ops    []string `json:"ops"`
+}
+
+// ParseScope parses a scope written as "op,op:/prefix", e.g. "read,list:/public".
+func ParseScope(s string) (Scope, error) {
+	ops, prefix, found := strings.Cut(s, ":")
+	if !found || !strings.HasPrefix(prefix, "/") {
+		return Scope{}, fmt.Errorf("invalid scope %q, expected op,op:/prefix", s)
+	}
+
+	scope := Scope{Prefix: prefix}
+	for _, op := range strings.Split(ops, ",") {
+		switch op {
+		case OpRead, OpWrite, OpDelete, OpList:
+			scope.Ops = append(scope.Ops, op)
+		default:
+			return Scope{}, fmt.Errorf("invalid operation %q in scope %q", op, s)
+		}
+	}
+	return scope, nil
+}
+
+// Claims is the payload of a bearer token.
+type Claims struct {
+	Subject   string  `json:"sub"`
+	IssuedAt  int64   `json:"iat,omitempty"`
+	NotBefore int64   `json:"nbf,omitempty"`
+	ExpiresAt int64   `json:"exp,omitempty"`
+	Scopes    []Scope `json:"scopes"`
+}
+
+// Operation returns the operation req performs on its path.
+func Operation(req *http.Request) string {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if strings.HasSuffix(req.URL.Path, "/") {
+			return OpList
+		}
+		return OpRead
+	case "PROPFIND":
+		// Only the collection's own properties can be read without listing
+		// its members.
+		if strings.HasSuffix(req.URL.Path, "/") && req.Header.Get("Depth") != "0" {
+			return OpList
+		}
+		return OpRead
+	case http.MethodOptions, http.MethodTrace:
+		return OpRead
+	case http.MethodDelete:
+		return OpDelete
+	default:
+		return OpWrite
+	}
+}
+
+// TokenAuth signs and verifies JSON Web Tokens using either an HMAC secret
+// (HS256) or an Ed25519 key (EdDSA).
+type TokenAuth struct {
+	// Leeway is the allowed clock skew when checking exp and nbf.
+	Leeway time.Duration
+
+	secret     []byte
+	publicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// LoadTokenKey reads a token key from path. PEM encoded Ed25519 private
+// (PKCS #8) or public (PKIX) keys select EdDSA; anything else is used as an
+// HMAC secret. Tokens can only be signed with a private key or secret.
+func LoadTokenKey(path string) (*TokenAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := bytes.TrimSpace(data)
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("HMAC secret in %s is too short, need at least 32 bytes", path)
+		}
+		return &TokenAuth{secret: secret}, nil
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key in %s is not an Ed25519 key", path)
+		}
+		return &TokenAuth{privateKey: private, publicKey: private.Public().(ed25519.PublicKey)}, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %v", err)
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key in %s is not an Ed25519 key", path)
+		}
+		return &TokenAuth{publicKey: public}, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+}
+
+// NewHMACTokenAuth creates a token authenticator using an HS256 secret.
+func NewHMACTokenAuth(secret []byte) *TokenAuth {
+	return &TokenAuth{secret: secret}
+}
+
+// NewEd25519TokenAuth creates a token authenticator using an Ed25519 key pair.
+// private may be nil when tokens only need to be verified.
+func NewEd25519TokenAuth(public ed25519.PublicKey, private ed25519.PrivateKey) *TokenAuth {
+	return &TokenAuth{publicKey: public, privateKey: private}
+}
+
+// Sign encodes and signs the claims as a JWT.
+func (t *TokenAuth) Sign(claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	var header string
+	switch {
+	case t.secret != nil:
+		header = `{"alg":"HS256","typ":"JWT"}`
+	case t.privateKey != nil:
+		header = `{"alg":"EdDSA","typ":"JWT"}`
+	default:
+		return "", errors.New("signing requires an HMAC secret or Ed25519 private key")
+	}
+
+	signed := encodeSegment([]byte(header)) + "." + encodeSegment(payload)
+	return signed + "." + encodeSegment(t.sign([]byte(signed))), nil
+}
+
+// Verify checks the token signature and validity period and returns its claims.
+func (t *TokenAuth) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	rawHeader, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	// The algorithm is fixed by the configured key, never chosen by the token.
+	switch {
+	case t.secret != nil && header.Alg == "HS256":
+		if !hmac.Equal(signature, t.sign(signed)) {
+			return nil, errors.New("invalid token signature")
+		}
+	case t.publicKey != nil && header.Alg == "EdDSA":
+		if !ed25519.Verify(t.publicKey, signed, signature) {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unexpected token algorithm %q", header.Alg)
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %v", err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token payload: %v", err)
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(t.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-t.Leeway)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	return &claims, nil
+}
+
+// sign returns the signature of data with the configured key.
+func (t *TokenAuth) sign(data []byte) []byte {
+	if t.secret != nil {
+		mac := hmac.New(sha256.New, t.secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	return ed25519.Sign(t.privateKey, data)
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
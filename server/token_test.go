@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	hmacTokens := NewHMACTokenAuth([]byte("0123456789abcdef0123456789abcdef"))
+	edTokens := NewEd25519TokenAuth(public, private)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		signer *TokenAuth
+		claims Claims
+		tamper func(string) string
+		valid  bool
+	}{
+		{name: "HS256", signer: hmacTokens, claims: Claims{Subject: "ci", ExpiresAt: now.Add(time.Hour).Unix()}, valid: true},
+		{name: "EdDSA", signer: edTokens, claims: Claims{Subject: "ci", ExpiresAt: now.Add(time.Hour).Unix()}, valid: true},
+		{name: "Expired", signer: hmacTokens, claims: Claims{Subject: "ci", ExpiresAt: now.Add(-time.Minute).Unix()}},
+		{name: "Not yet valid", signer: hmacTokens, claims: Claims{Subject: "ci", NotBefore: now.Add(time.Minute).Unix()}},
+		{name: "No subject", signer: hmacTokens, claims: Claims{}},
+		{
+			name: "Tampered payload", signer: hmacTokens, claims: Claims{Subject: "ci"},
+			tamper: func(token string) string {
+				parts := strings.Split(token, ".")
+				parts[1] = encodeSegment([]byte(`{"sub":"admin"}`))
+				return strings.Join(parts, ".")
+			},
+		},
+		{
+			name: "Unsigned", signer: hmacTokens, claims: Claims{Subject: "ci"},
+			tamper: func(token string) string {
+				parts := strings.Split(token, ".")
+				return encodeSegment([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		token, err := tt.signer.Sign(tt.claims)
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %v", tt.name, err)
+		}
+		if tt.tamper != nil {
+			token = tt.tamper(token)
+		}
+
+		_, err = tt.signer.Verify(token)
+		if tt.valid && err != nil {
+			t.Errorf("%s: valid token rejected: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: invalid token accepted", tt.name)
+		}
+	}
+
+	// A token signed with one key must not verify with another.
+	token, _ := edTokens.Sign(Claims{Subject: "ci"})
+	if _, err := hmacTokens.Verify(token); err == nil {
+		t.Errorf("EdDSA token accepted by HMAC verifier")
+	}
+}
+
+func TestBearerScopes(t *testing.T) {
+	tokens := NewHMACTokenAuth([]byte("0123456789abcdef0123456789abcdef"))
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.Listing = true
+		s.Auth, _ = NewAuth("files", nil)
+		s.Auth.Tokens = tokens
+		s.Auth.Require("/")
+	})
+	os.Mkdir(filepath.Join(root, "public"), 0o755)
+	os.Mkdir(filepath.Join(root, "uploads"), 0o755)
+
+	token, err := tokens.Sign(Claims{
+		Subject: "ci",
+		Scopes: []Scope{
+			{Prefix: "/public", Ops: []string{OpRead}},
+			{Prefix: "/uploads", Ops: []string{OpRead, OpWrite, OpList}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{name: "No token", method: "GET", path: "/public/a.txt", want: 401},
+		{name: "Garbage token", method: "GET", path: "/public/a.txt", token: "abc", want: 401},
+		{name: "Read in scope", method: "GET", path: "/public/a.txt", token: token, want: 404},
+		{name: "Write out of scope", method: "POST", path: "/public/a.txt", token: token, want: 403},
+		{name: "Write in scope", method: "POST", path: "/uploads/a.txt", token: token, want: 200},
+		{name: "Read outside prefixes", method: "GET", path: "/private/a.txt", token: token, want: 403},
+		{name: "Write out of scope through dot segments", method: "POST", path: "/uploads/../public/a.txt", token: token, want: 403},
+		{name: "Read outside prefixes through dot segments", method: "GET", path: "/public/../private/a.txt", token: token, want: 403},
+		{name: "Read outside prefixes with double slash", method: "GET", path: "/public//../private/a.txt", token: token, want: 403},
+		{name: "List out of scope", method: "GET", path: "/public/", token: token, want: 403},
+		{name: "List out of scope without trailing slash", method: "GET", path: "/public", token: token, want: 403},
+		{name: "List in scope without trailing slash", method: "GET", path: "/uploads", token: token, want: 200},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://"+addr+tt.path, strings.NewReader("data"))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	scope, err := ParseScope("read,list:/public")
+	if err != nil || scope.Prefix != "/public" || len(scope.Ops) != 2 {
+		t.Errorf("got %+v, %v", scope, err)
+	}
+
+	for _, invalid := range []string{"read", "read:public", "fly:/public"} {
+		if _, err := ParseScope(invalid); err == nil {
+			t.Errorf("ParseScope(%q) succeeded", invalid)
+		}
+	}
+}
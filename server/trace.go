@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds.
+const (
+	SpanServer = "server"
+	SpanClient = "client"
+)
+
+// TraceContext identifies a span as carried by the W3C traceparent and
+// tracestate headers.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	// State is the vendor specific tracestate, propagated unchanged.
+	State string
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the sampled flag is set.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&1 != 0
+}
+
+// Traceparent formats the context as a version 00 traceparent header.
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header. Fields added by versions
+// newer than 00 are ignored.
+func ParseTraceparent(header string) (TraceContext, error) {
+	var tc TraceContext
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 {
+		return tc, errors.New("traceparent needs four fields")
+	}
+	version, err := hex.DecodeString(fields[0])
+	if err != nil || len(fields[0]) != 2 || version[0] == 0xff {
+		return tc, fmt.Errorf("invalid traceparent version %q", fields[0])
+	}
+	if version[0] == 0 && len(fields) != 4 {
+		return tc, errors.New("version 00 traceparent has extra fields")
+	}
+	if !isLowerHex(fields[1]) || !isLowerHex(fields[2]) || !isLowerHex(fields[3]) {
+		return tc, errors.New("traceparent fields must be lowercase hex")
+	}
+
+	if len(fields[1]) != 32 || len(fields[2]) != 16 || len(fields[3]) != 2 {
+		return tc, errors.New("traceparent field has the wrong length")
+	}
+	hex.Decode(tc.TraceID[:], []byte(fields[1]))
+	hex.Decode(tc.SpanID[:], []byte(fields[2]))
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(fields[3]))
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return TraceContext{}, errors.New("traceparent has an all-zero ID")
+	}
+	return tc, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// TraceContextFromHeader extracts the trace context of an incoming request.
+// The zero context is returned when the request carries none or an invalid one.
+func TraceContextFromHeader(h http.Header) TraceContext {
+	tc, err := ParseTraceparent(h.Get("traceparent"))
+	if err != nil {
+		return TraceContext{}
+	}
+	tc.State = h.Get("tracestate")
+	return tc
+}
+
+// Inject sets the traceparent and tracestate headers for tc.
+func (tc TraceContext) Inject(h http.Header) {
+	h.Set("traceparent", tc.Traceparent())
+	if tc.State != "" {
+		h.Set("tracestate", tc.State)
+	} else {
+		h.Del("tracestate")
+	}
+}
+
+// Span is a timed operation within a trace.
+type Span struct {
+	Context    TraceContext
+	ParentID   [8]byte
+	Name       string
+	Kind       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+
+	tracer *Tracer
+	once   sync.Once
+}
+
+// SetAttr sets an attribute on the span.
+func (s *Span) SetAttr(key string, value any) {
+	s.Attributes[key] = value
+}
+
+// Finish ends the span and exports it if it is sampled and a tracer records spans.
+func (s *Span) Finish() {
+	s.once.Do(func() {
+		s.End = time.Now()
+		if s.tracer != nil && s.tracer.Exporter != nil && s.Context.Sampled() {
+			s.tracer.Exporter.Export(s)
+		}
+	})
+}
+
+// MarshalJSON encodes the span as a single JSON object.
+func (s *Span) MarshalJSON() ([]byte, error) {
+	var parent string
+	if s.ParentID != [8]byte{} {
+		parent = hex.EncodeToString(s.ParentID[:])
+	}
+	return json.Marshal(map[string]any{
+		"trace_id":       hex.EncodeToString(s.Context.TraceID[:]),
+		"span_id":        hex.EncodeToString(s.Context.SpanID[:]),
+		"parent_span_id": parent,
+		"name":           s.Name,
+		"kind":           s.Kind,
+		"start":          s.Start.Format(time.RFC3339Nano),
+		"end":            s.End.Format(time.RFC3339Nano),
+		"duration_ms":    float64(s.End.Sub(s.Start).Microseconds()) / 1000,
+		"attributes":     s.Attributes,
+	})
+}
+
+// SpanExporter receives finished spans.
+type SpanExporter interface {
+	Export(span *Span)
+}
+
+// Tracer starts spans and passes finished ones to its exporter. A nil Tracer,
+// or one without an exporter, still creates and propagates trace contexts
+// but records nothing.
+type Tracer struct {
+	Exporter SpanExporter
+}
+
+// Start starts a span named name as a child of parent. A new trace is
+// started when parent is not valid.
+func (t *Tracer) Start(parent TraceContext, name, kind string) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+	if parent.IsValid() {
+		span.Context = parent
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Flags = 1
+	}
+	rand.Read(span.Context.SpanID[:])
+	return span
+}
+
+// OpenTracer creates a tracer exporting spans to target, either the URL of a
+// collector accepting JSON lines or a file the lines are appended to.
+func OpenTracer(target string) (*Tracer, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return &Tracer{Exporter: NewHTTPExporter(target)}, nil
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open span file: %v", err)
+	}
+	return &Tracer{Exporter: NewJSONExporter(file)}, nil
+}
+
+type spanKey struct{}
+
+// WithSpan returns a copy of req carrying span.
+func WithSpan(req *http.Request, span *Span) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), spanKey{}, span))
+}
+
+// SpanFromRequest returns the span of req, nil if it has none.
+func SpanFromRequest(req *http.Request) *Span {
+	span, _ := req.Context().Value(spanKey{}).(*Span)
+	return span
+}
+
+// JSONExporter writes each span as a line of JSON.
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONExporter creates an exporter writing to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// Export writes the span.
+func (e *JSONExporter) Export(span *Span) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		slog.Error("Error encoding span", "err", err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(data, '\n'))
+}
+
+// HTTPExporter posts batches of spans as JSON lines to a collector. Spans
+// are dropped when the collector falls behind.
+type HTTPExporter struct {
+	URL      string
+	Interval time.Duration
+	// Timeout limits each post, so that a stuck collector cannot hold up
+	// the spans behind it.
+	Timeout time.Duration
+
+	spans chan *Span
+	done  chan struct{}
+}
+
+// NewHTTPExporter creates an exporter posting to url and starts sending.
+func NewHTTPExporter(url string) *HTTPExporter {
+	e := &HTTPExporter{
+		URL:      url,
+		Interval: time.Second,
+		Timeout:  10 * time.Second,
+		spans:    make(chan *Span, 1024),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues the span for sending.
+func (e *HTTPExporter) Export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		slog.Warn("Dropping span, collector is falling behind", "collector", e.URL)
+	}
+}
+
+// Close sends the queued spans and stops the exporter.
+func (e *HTTPExporter) Close() {
+	close(e.spans)
+	<-e.done
+}
+
+func (e *HTTPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.send(&batch)
+				return
+			}
+			data, _ := json.Marshal(span)
+			batch.Write(append(data, '\n'))
+		case <-ticker.C:
+			e.send(&batch)
+		}
+	}
+}
+
+func (e *HTTPExporter) send(batch *bytes.Buffer) {
+	if batch.Len() == 0 {
+		return
+	}
+	defer batch.Reset()
+
+	client := &http.Client{Timeout: e.Timeout}
+	res, err := client.Post(e.URL, "application/x-ndjson", bytes.NewReader(batch.Bytes()))
+	if err != nil {
+		slog.Warn("Error exporting spans", "collector", e.URL, "err", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		slog.Warn("Collector rejected spans", "collector", e.URL, "status", res.StatusCode)
+	}
+}
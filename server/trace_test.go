@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		tc, err := ParseTraceparent(tt.header)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseTraceparent(%q) = %v, want valid %v", tt.header, err, tt.valid)
+		}
+		// Contexts are passed on as version 00.
+		if err == nil && tc.Traceparent() != "00"+tt.header[2:55] {
+			t.Errorf("got %q back from %q", tc.Traceparent(), tt.header)
+		}
+	}
+}
+
+func TestServerSpan(t *testing.T) {
+	var buf lockedBuffer
+	addr := startTestServer(t, func(s *Server) {
+		s.Tracer = &Tracer{Exporter: NewJSONExporter(&buf)}
+	})
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/missing.txt", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	res.Body.Close()
+
+	id := res.Header.Get("X-Request-ID")
+	if id == "" {
+		t.Errorf("response has no request ID")
+	}
+
+	var span map[string]any
+	for i := 0; i < 50 && len(buf.Bytes()) == 0; i++ {
+		time.Sleep(time.Duration(i) * time.Millisecond)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &span); err != nil {
+		t.Fatalf("invalid span %q: %v", buf.Bytes(), err)
+	}
+	attrs, _ := span["attributes"].(map[string]any)
+	want := map[string]any{
+		"trace_id":       "4bf92f3577b34da6a3ce929d0e0e4736",
+		"parent_span_id": "00f067aa0ba902b7",
+		"kind":           SpanServer,
+	}
+	for key, value := range want {
+		if span[key] != value {
+			t.Errorf("got %s %v, want %v", key, span[key], value)
+		}
+	}
+	if attrs["request_id"] != id || fmt.Sprint(attrs["http.status_code"]) != "404" {
+		t.Errorf("got attributes %v", attrs)
+	}
+}
+
+func TestHTTPExporterTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stuck
+	}))
+	defer collector.Close()
+	defer close(stuck)
+
+	exporter := NewHTTPExporter(collector.URL)
+	exporter.Timeout = 50 * time.Millisecond
+	exporter.Export(&Span{Name: "GET /"})
+
+	closed := make(chan struct{})
+	go func() {
+		exporter.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exporter blocked on a stuck collector")
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Environment variables through which Upgrade hands sockets to the new
+// process: a JSON list of the endpoints of the sockets, which start at file
+// descriptor 3, and the file descriptor the new process reports on once it
+// listens.
+const (
+	upgradeFDsEnv   = "UPGRADE_FDS"
+	upgradeReadyEnv = "UPGRADE_READY_FD"
+)
+
+// upgradeCommand returns the command starting the new process: the running
+// binary with the same arguments and standard streams.
+var upgradeCommand = func() (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd, nil
+}
+
+// listenerFile duplicates the socket of ln for handing it to another
+// process. Unlike the listener's File method, this leaves the socket in
+// non-blocking mode, so that ln can still be closed while it accepts.
+func listenerFile(ln net.Listener, name string) (*os.File, error) {
+	conn, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("not a socket")
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	if err := raw.Control(func(s uintptr) { fd, err = syscall.Dup(int(s)) }); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// Upgrade starts a new process of the running binary and hands it the
+// server's listening sockets, and the admin listener's if admin is set. It
+// returns once the new process listens; the caller then shuts the server
+// down to drain its connections. If the new process fails to listen within
+// timeout it is killed and the server keeps serving.
+func (s *Server) Upgrade(timeout time.Duration, admin *Admin) error {
+	if !s.state.upgrading.CompareAndSwap(false, true) {
+		return errors.New("an upgrade is already in progress")
+	}
+	defer s.state.upgrading.Store(false)
+
+	s.state.mu.RLock()
+	closed := s.state.closed
+	sockets := make(map[string]net.Listener)
+	var endpoints []string
+	for _, l := range s.state.listeners {
+		sockets[l.endpoint()] = l.ln
+		endpoints = append(endpoints, l.endpoint())
+	}
+	s.state.mu.RUnlock()
+	if closed {
+		return net.ErrClosed
+	}
+	if admin != nil && admin.Listener != nil {
+		sockets[admin.endpoint()] = admin.Listener
+		endpoints = append(endpoints, admin.endpoint())
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, endpoint := range endpoints {
+		f, err := listenerFile(sockets[endpoint], endpoint)
+		if err != nil {
+			return fmt.Errorf("cannot hand over %s: %v", endpoint, err)
+		}
+		files = append(files, f)
+	}
+
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, notify)
+
+	cmd, err := upgradeCommand()
+	if err != nil {
+		return fmt.Errorf("failed to find the binary: %v", err)
+	}
+	encoded, _ := json.Marshal(endpoints)
+	cmd.Env = append(os.Environ(), upgradeFDsEnv+"="+string(encoded), upgradeReadyEnv+"="+strconv.Itoa(3+len(endpoints)))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start new process: %v", err)
+	}
+	logger := s.logger().With("pid", cmd.Process.Pid)
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if err := cmd.Wait(); err != nil {
+			logger.Error("New process exited", "err", err)
+		} else {
+			logger.Info("New process exited", "status", cmd.ProcessState.ExitCode())
+		}
+	}()
+
+	// Our copies are closed so that the pipe reports EOF if the new process
+	// exits before it listens.
+	for _, f := range files {
+		f.Close()
+	}
+	files = nil
+
+	logger.Info("Started new process, waiting for it to listen")
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		result <- err
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	if err != nil {
+		if killErr := cmd.Process.Kill(); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
+			logger.Error("Failed to kill new process", "err", killErr)
+		}
+		<-exited
+		return fmt.Errorf("new process did not start listening: %v", err)
+	}
+
+	// The new process now serves the sockets, closing ours must not remove
+	// the Unix domain socket files.
+	for _, ln := range sockets {
+		if unix, ok := ln.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	if admin != nil {
+		admin.Close()
+	}
+	logger.Info("Handed listeners over to new process")
+	return nil
+}
+
+// inherited holds the sockets handed over by the process that upgraded to
+// this one, by endpoint.
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// inheritedListener returns the socket handed over for endpoint, nil if there
+// is none. Each socket can be used once.
+func inheritedListener(endpoint string) (net.Listener, error) {
+	inherited.once.Do(loadInheritedFiles)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	file, ok := inherited.files[endpoint]
+	if !ok {
+		return nil, nil
+	}
+	delete(inherited.files, endpoint)
+
+	ln, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket %s: %v", endpoint, err)
+	}
+	return ln, nil
+}
+
+// loadInheritedFiles reads the sockets handed over by Upgrade and clears its
+// variable so that it is not inherited by child processes.
+func loadInheritedFiles() {
+	inherited.files = make(map[string]*os.File)
+	value := os.Getenv(upgradeFDsEnv)
+	os.Unsetenv(upgradeFDsEnv)
+
+	var endpoints []string
+	if value == "" || json.Unmarshal([]byte(value), &endpoints) != nil {
+		return
+	}
+	for i, endpoint := range endpoints {
+		inherited.files[endpoint] = os.NewFile(uintptr(3+i), endpoint)
+	}
+}
+
+// notifyUpgraded tells the process that handed over its sockets that this
+// one listens, and closes the handed over sockets that were not used.
+func notifyUpgraded() error {
+	fd, err := strconv.Atoi(os.Getenv(upgradeReadyEnv))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(upgradeReadyEnv)
+
+	inherited.once.Do(loadInheritedFiles)
+	inherited.mu.Lock()
+	for endpoint, file := range inherited.files {
+		file.Close()
+		delete(inherited.files, endpoint)
+	}
+	inherited.mu.Unlock()
+
+	ready := os.NewFile(uintptr(fd), "upgrade")
+	defer ready.Close()
+	if _, err := ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to notify the previous process: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpgrade(t *testing.T) {
+	var s *Server
+	addr := startTestServer(t, func(srv *Server) { s = srv })
+	writeTestFile(t, filepath.Join(s.Root, "a.txt"), "old")
+
+	// The new process is this test binary running TestUpgradedProcess, which
+	// serves a different root.
+	newRoot := t.TempDir()
+	writeTestFile(t, filepath.Join(newRoot, "a.txt"), "new")
+	t.Setenv("UPGRADE_TEST_ROOT", newRoot)
+	original := upgradeCommand
+	upgradeCommand = func() (*exec.Cmd, error) {
+		return exec.Command(os.Args[0], "-test.run=^TestUpgradedProcess$"), nil
+	}
+	t.Cleanup(func() { upgradeCommand = original })
+
+	// A request in flight during the upgrade is answered by the old process.
+	inFlight, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer inFlight.Close()
+	fmt.Fprint(inFlight, "GET /a.txt HTTP/1.0\r\n")
+
+	if err := s.Upgrade(10*time.Second, nil); err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(5 * time.Second) }()
+
+	fmt.Fprint(inFlight, "\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(inFlight), nil)
+	if err != nil {
+		t.Fatalf("in-flight request failed: %v", err)
+	}
+	if body, _ := io.ReadAll(res.Body); string(body) != "old" {
+		t.Errorf("in-flight request got %q, want the old process's file", body)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("old process did not drain: %v", err)
+	}
+
+	res, err = http.Get("http://" + addr + "/a.txt")
+	if err != nil {
+		t.Fatalf("request after the upgrade failed: %v", err)
+	}
+	defer res.Body.Close()
+	if body, _ := io.ReadAll(res.Body); string(body) != "new" {
+		t.Errorf("got %q after the upgrade, want the new process's file", body)
+	}
+}
+
+func TestUpgradeFailure(t *testing.T) {
+	var s *Server
+	addr := startTestServer(t, func(srv *Server) { s = srv })
+	writeTestFile(t, filepath.Join(s.Root, "a.txt"), "a")
+
+	original := upgradeCommand
+	upgradeCommand = func() (*exec.Cmd, error) { return exec.Command("false"), nil }
+	t.Cleanup(func() { upgradeCommand = original })
+
+	if err := s.Upgrade(10*time.Second, nil); err == nil {
+		t.Fatal("upgrade to a process that exits succeeded")
+	}
+	res, err := http.Get("http://" + addr + "/a.txt")
+	if err != nil {
+		t.Fatalf("server stopped serving after a failed upgrade: %v", err)
+	}
+	res.Body.Close()
+}
+
+// TestUpgradedProcess is the new process started by TestUpgrade. It serves
+// the inherited listener until TestUpgrade removes its root.
+func TestUpgradedProcess(t *testing.T) {
+	root := os.Getenv("UPGRADE_TEST_ROOT")
+	if root == "" {
+		t.Skip("only run by TestUpgrade")
+	}
+	s, err := CreateServer("127.0.0.1", 0, 10)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	s.Root = root
+	if err := s.Listen(); err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := os.Stat(root); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
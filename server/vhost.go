@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// VirtualHost serves the requests for a set of host names with its own root
+// and settings. Settings left unset fall back to the server's.
+type VirtualHost struct {
+	// Names are the host names served, without port. A name starting with
+	// "*." matches any subdomain, e.g. "*.example.com" matches
+	// "www.example.com" but not "example.com".
+	Names []string
+	// Root is the directory files are served from and stored in.
+	Root string
+	// Index, when set, is the file served for requests to a directory.
+	Index string
+	// Listing lists the files of directories without an index.
+	Listing bool
+	// MIMETypes are the host's content types, added to the server's.
+	MIMETypes map[string]string
+	// Auth, when set, replaces the server's authentication.
+	Auth *Auth
+	// AccessLog, when set, receives the host's entries instead of the
+	// server's access log.
+	AccessLog *AccessLog
+}
+
+// name returns the primary name of the host.
+func (h *VirtualHost) name() string {
+	if len(h.Names) == 0 {
+		return ""
+	}
+	return h.Names[0]
+}
+
+// VirtualHosts routes requests to virtual hosts by their Host header.
+type VirtualHosts struct {
+	// Hosts are the virtual hosts. Exact names take precedence over
+	// wildcards, and longer wildcards over shorter ones.
+	Hosts []*VirtualHost
+	// Default, when set, serves requests for unknown hosts and requests
+	// without a Host header.
+	Default *VirtualHost
+	// UnknownStatus is the status requests for unknown hosts are answered
+	// with when there is no default host: 421 Misdirected Request or 404 Not
+	// Found. Defaults to 421.
+	UnknownStatus int
+
+	exact     map[string]*VirtualHost
+	wildcards map[string]*VirtualHost
+}
+
+// NewVirtualHosts indexes hosts by name. Names must be unique.
+func NewVirtualHosts(hosts []*VirtualHost, defaultHost *VirtualHost, unknownStatus int) (*VirtualHosts, error) {
+	v := &VirtualHosts{
+		Hosts:         hosts,
+		Default:       defaultHost,
+		UnknownStatus: unknownStatus,
+		exact:         make(map[string]*VirtualHost),
+		wildcards:     make(map[string]*VirtualHost),
+	}
+	if v.UnknownStatus == 0 {
+		v.UnknownStatus = http.StatusMisdirectedRequest
+	}
+	if v.UnknownStatus != http.StatusMisdirectedRequest && v.UnknownStatus != http.StatusNotFound {
+		return nil, fmt.Errorf("unknown host status must be 421 or 404, got %d", v.UnknownStatus)
+	}
+
+	for _, host := range hosts {
+		if len(host.Names) == 0 {
+			return nil, fmt.Errorf("virtual host with root %q has no names", host.Root)
+		}
+		for _, name := range host.Names {
+			name = normalizeHost(name)
+			names := v.exact
+			if suffix, ok := strings.CutPrefix(name, "*."); ok {
+				name, names = suffix, v.wildcards
+			}
+			if _, ok := names[name]; ok {
+				return nil, fmt.Errorf("duplicate virtual host name %q", name)
+			}
+			names[name] = host
+		}
+	}
+	return v, nil
+}
+
+// Match returns the virtual host serving host, which may include a port.
+// It returns the default host if none matches, nil if there is none.
+func (v *VirtualHosts) Match(host string) *VirtualHost {
+	host = normalizeHost(stripPort(host))
+	if match, ok := v.exact[host]; ok {
+		return match
+	}
+	for suffix := host; ; {
+		_, rest, found := strings.Cut(suffix, ".")
+		if !found {
+			break
+		}
+		if match, ok := v.wildcards[rest]; ok {
+			return match
+		}
+		suffix = rest
+	}
+	return v.Default
+}
+
+// AccessLogs returns the access logs of the virtual hosts.
+func (v *VirtualHosts) AccessLogs() []*AccessLog {
+	var logs []*AccessLog
+	for _, host := range v.Hosts {
+		if host.AccessLog != nil {
+			logs = append(logs, host.AccessLog)
+		}
+	}
+	return logs
+}
+
+// stripPort removes the port from a Host header.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// normalizeHost lowercases a host name and removes its trailing dot.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// withVirtualHost returns a copy of the server that serves host.
+func (s *Server) withVirtualHost(host *VirtualHost) *Server {
+	c := *s
+	c.Root, c.Index, c.Listing = host.Root, host.Index, host.Listing
+	if len(host.MIMETypes) > 0 {
+		c.MIMETypes = make(map[string]string)
+		for ext, contentType := range s.MIMETypes {
+			c.MIMETypes[ext] = contentType
+		}
+		for ext, contentType := range host.MIMETypes {
+			c.MIMETypes[ext] = contentType
+		}
+	}
+	if host.Auth != nil {
+		c.Auth = host.Auth
+	}
+	if host.AccessLog != nil {
+		c.AccessLog = host.AccessLog
+	}
+	return &c
+}
+
+// HandleUnknownHost builds the response to a request for a host that is not
+// served.
+func (s *Server) HandleUnknownHost(res *http.Response) {
+	if s.VirtualHosts.UnknownStatus == http.StatusNotFound {
+		s.HandleNotFound(res)
+		return
+	}
+	res.Status = "421 Misdirected Request"
+	res.StatusCode = http.StatusMisdirectedRequest
+	res.Body = CreateBody("421 Misdirected Request")
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVirtualHostsMatch(t *testing.T) {
+	site := &VirtualHost{Names: []string{"example.com", "www.example.com"}}
+	sub := &VirtualHost{Names: []string{"*.example.com"}}
+	deep := &VirtualHost{Names: []string{"*.api.example.com"}}
+	fallback := &VirtualHost{Names: []string{"default"}}
+	hosts, err := NewVirtualHosts([]*VirtualHost{site, sub, deep, fallback}, fallback, 0)
+	if err != nil {
+		t.Fatalf("failed to create virtual hosts: %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want *VirtualHost
+	}{
+		{"example.com", site},
+		{"WWW.Example.com.:8080", site},
+		{"blog.example.com", sub},
+		{"v1.api.example.com", deep},
+		{"api.example.com", sub},
+		{"example.org", fallback},
+		{"", fallback},
+	}
+	for _, test := range tests {
+		if got := hosts.Match(test.host); got != test.want {
+			t.Errorf("Match(%q) = %v, want %v", test.host, got.Names, test.want.Names)
+		}
+	}
+
+	hosts.Default = nil
+	if got := hosts.Match("example.org"); got != nil {
+		t.Errorf("got %v for an unknown host without a default", got.Names)
+	}
+
+	if _, err := NewVirtualHosts([]*VirtualHost{site, {Names: []string{"Example.com"}}}, nil, 0); err == nil {
+		t.Error("duplicate names were accepted")
+	}
+	if _, err := NewVirtualHosts(nil, nil, 500); err == nil {
+		t.Error("unknown host status 500 was accepted")
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	siteRoot, docsRoot := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(siteRoot, "index.html"), "site index")
+	writeTestFile(t, filepath.Join(siteRoot, "app.js"), "site script")
+	if err := os.Mkdir(filepath.Join(docsRoot, "guides"), 0777); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	writeTestFile(t, filepath.Join(docsRoot, "guides", "a <b>.txt"), "a")
+
+	var site, docs *VirtualHost
+	var s *Server
+	addr := startTestServer(t, func(srv *Server) {
+		s = srv
+		site = &VirtualHost{
+			Names:     []string{"example.com"},
+			Root:      siteRoot,
+			Index:     "index.html",
+			MIMETypes: map[string]string{".js": "text/javascript"},
+		}
+		docs = &VirtualHost{Names: []string{"*.docs.example.com"}, Root: docsRoot, Listing: true, Auth: newTestAuth(t)}
+		docs.Auth.Require("/", http.MethodPost)
+		s.VirtualHosts, _ = NewVirtualHosts([]*VirtualHost{site, docs}, nil, 0)
+		writeTestFile(t, filepath.Join(s.Root, "index.html"), "server root")
+	})
+
+	tests := []struct {
+		name, method, host, path string
+		status                   int
+		body                     string
+	}{
+		{"index", "GET", "example.com", "/", 200, "site index"},
+		{"host MIME type", "GET", "example.com:8080", "/app.js", 200, "site script"},
+		{"listing", "GET", "v2.docs.example.com", "/guides", 200, `<a href="/guides/a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`},
+		{"no listing", "GET", "example.com", "/missing-index/", 404, ""},
+		{"host auth", "POST", "v2.docs.example.com", "/new.txt", 401, ""},
+		{"unknown host", "GET", "example.org", "/index.html", 421, ""},
+		{"no host", "GET", "", "/index.html", 421, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(test.method, "http://"+addr+test.path, strings.NewReader(""))
+			req.Host = test.host
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != test.status || !strings.Contains(string(body), test.body) {
+				t.Errorf("got %d %q, want %d containing %q", res.StatusCode, body, test.status, test.body)
+			}
+		})
+	}
+
+	if err := os.Mkdir(filepath.Join(siteRoot, "private"), 0777); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "http://"+addr+"/private/", nil)
+	req.Host = "example.com"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("directory without index or listing got %d, want 403", res.StatusCode)
+	}
+
+	settings := s.CurrentSettings()
+	settings.VirtualHosts, _ = NewVirtualHosts([]*VirtualHost{site}, nil, http.StatusNotFound)
+	s.Reconfigure(settings)
+	req, _ = http.NewRequest("GET", "http://"+addr+"/index.html", nil)
+	req.Host = "example.org"
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown host got %d, want the configured 404", res.StatusCode)
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often a watched file is stat:ed for changes.
+const watchInterval = time.Second
+
+// watchedFile keeps the parsed contents of a file up to date. The file is
+// stat:ed at most once per interval and re-parsed when its modification time
+// or size changes. A file that fails to parse keeps the previous contents.
+type watchedFile struct {
+	path     string
+	parse    func(data []byte) error
+	interval time.Duration
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// newWatchedFile reads and parses the file at path, returning any errors that
+// occured during the initial load.
+func newWatchedFile(path string, parse func(data []byte) error) (*watchedFile, error) {
+	w := &watchedFile{path: path, parse: parse, interval: watchInterval}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// refresh reloads the file if it has changed since it was last read.
+func (w *watchedFile) refresh() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if time.Since(w.checked) < w.interval {
+		return
+	}
+	w.checked = time.Now()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		slog.Error("Error checking file for changes", "path", w.path, "err", err)
+		return
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return
+	}
+
+	if err := w.load(); err != nil {
+		slog.Error("Error reloading file, keeping previous version", "path", w.path, "err", err)
+		return
+	}
+	slog.Info("Reloaded file", "path", w.path)
+}
+
+// load reads and parses the file. Callers other than newWatchedFile must hold w.mu.
+func (w *watchedFile) load() error {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if err := w.parse(data); err != nil {
+		return err
+	}
+
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	w.checked = time.Now()
+	return nil
+}
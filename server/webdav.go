@@ -0,0 +1,561 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebDAV serves the methods of RFC 4918 on the storage root so that it can be
+// mounted as a network drive. Dead properties are stored in sidecar files
+// next to the resources, which clients cannot access, and locks are held in
+// memory.
+type WebDAV struct {
+	// MaxLockTimeout caps the lock timeouts clients ask for and is the
+	// timeout of locks that do not ask for one.
+	MaxLockTimeout time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*davLock // by token
+}
+
+// NewWebDAV creates a WebDAV handler without locks.
+func NewWebDAV() *WebDAV {
+	return &WebDAV{MaxLockTimeout: time.Hour, locks: make(map[string]*davLock)}
+}
+
+// davMethods are the methods WebDAV adds to the server's.
+var davMethods = []string{http.MethodPut, http.MethodDelete, "MKCOL", "COPY", "MOVE", "PROPFIND", "PROPPATCH", "LOCK", "UNLOCK"}
+
+// maxDAVBody limits the XML bodies of WebDAV requests.
+const maxDAVBody = 1 << 20
+
+// HandleWebDAV serves a request with one of the WebDAV methods.
+func (s *Server) HandleWebDAV(req *http.Request, res *http.Response) {
+	if !s.davPrecondition(req, res) {
+		return
+	}
+	switch req.Method {
+	case http.MethodPut:
+		s.handlePut(req, res)
+	case http.MethodDelete:
+		s.handleDelete(req, res)
+	case "MKCOL":
+		s.handleMkcol(req, res)
+	case "COPY", "MOVE":
+		s.handleCopyMove(req, res)
+	case "PROPFIND":
+		s.handlePropfind(req, res)
+	case "PROPPATCH":
+		s.handleProppatch(req, res)
+	case "LOCK":
+		s.handleLock(req, res)
+	case "UNLOCK":
+		s.handleUnlock(req, res)
+	}
+}
+
+// handlePut stores the request body as the resource, 201 Created if it is
+// new and 204 No Content if it replaced one.
+func (s *Server) handlePut(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	info, err := os.Stat(name)
+	switch {
+	case err == nil && info.IsDir():
+		s.HandleMethodNotAllowed(res, s.allowedMethods(req.URL.Path))
+		return
+	case !parentExists(name):
+		davStatus(res, http.StatusConflict)
+		return
+	}
+	if _, err := s.contentType(req); err != nil {
+		LoggerFromRequest(req).Info("Rejected upload of unsupported content type", "err", err)
+		s.HandleUnsupportedMediaType(res)
+		return
+	}
+	if !s.davUnlocked(req, res, name, false, true) {
+		return
+	}
+
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		LoggerFromRequest(req).Warn("Error reading request body", "err", err)
+		s.HandleBadRequest(res)
+		return
+	}
+	if err := WriteFile(name, data); err != nil {
+		LoggerFromRequest(req).Error("Error writing to file", "file", name, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	if info == nil {
+		davStatus(res, http.StatusCreated)
+		return
+	}
+	s.HandleNoContent(res)
+}
+
+// handleDelete removes a file or a collection with all its members.
+func (s *Server) handleDelete(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	info, err := os.Stat(name)
+	switch {
+	case err != nil:
+		s.HandleNotFound(res)
+		return
+	case name == filepath.Clean(s.Root):
+		s.HandleForbidden(res)
+		return
+	case info.IsDir() && req.Header.Get("Depth") != "" && req.Header.Get("Depth") != "infinity":
+		// Collections are always deleted with their members, RFC 4918
+		// section 9.6.1.
+		s.HandleBadRequest(res)
+		return
+	}
+	if !s.davUnlocked(req, res, name, true, true) {
+		return
+	}
+	if err := removeResource(name, info); err != nil {
+		LoggerFromRequest(req).Error("Error deleting resource", "file", name, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	s.WebDAV.release(name)
+	s.HandleNoContent(res)
+}
+
+// handleMkcol creates a collection.
+func (s *Server) handleMkcol(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	if body, _ := io.ReadAll(io.LimitReader(req.Body, 1)); len(body) > 0 {
+		// No body format for MKCOL is supported, RFC 4918 section 9.3.
+		s.HandleUnsupportedMediaType(res)
+		return
+	}
+	if _, err := os.Stat(name); err == nil {
+		s.HandleMethodNotAllowed(res, s.allowedMethods(req.URL.Path))
+		return
+	}
+	if !parentExists(name) {
+		davStatus(res, http.StatusConflict)
+		return
+	}
+	if !s.davUnlocked(req, res, name, false, true) {
+		return
+	}
+	if err := os.Mkdir(name, 0777); err != nil {
+		LoggerFromRequest(req).Error("Error creating collection", "dir", name, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	davStatus(res, http.StatusCreated)
+}
+
+// handleCopyMove copies or moves a resource to the URL in the Destination
+// header, replacing what is there unless Overwrite is F.
+func (s *Server) handleCopyMove(req *http.Request, res *http.Response) {
+	src := s.localPath(req.URL.Path)
+	info, err := os.Stat(src)
+	if err != nil {
+		s.HandleNotFound(res)
+		return
+	}
+	dest, err := url.Parse(req.Header.Get("Destination"))
+	if err != nil || dest.Path == "" {
+		s.HandleBadRequest(res)
+		return
+	}
+	// The destination is checked and used in the canonical form request
+	// paths are, or ".." could get it past the ACL.
+	destPath, inRoot := cleanPath(dest.Path)
+	switch {
+	case !inRoot:
+		s.HandleBadRequest(res)
+		return
+	case dest.Host != "" && !strings.EqualFold(dest.Host, req.Host):
+		// Other servers cannot be copied to, RFC 4918 section 9.8.5.
+		davStatus(res, http.StatusBadGateway)
+		return
+	case isSidecar(destPath):
+		s.HandleForbidden(res)
+		return
+	}
+	dst := s.localPath(destPath)
+	if !info.IsDir() {
+		// A file may not be given a name it could not be uploaded under.
+		probe := req.Clone(req.Context())
+		probe.URL.Path = destPath
+		if _, err := s.contentType(probe); err != nil {
+			LoggerFromRequest(req).Info("Rejected destination of unsupported content type", "destination", destPath, "err", err)
+			s.HandleUnsupportedMediaType(res)
+			return
+		}
+	}
+
+	depth := req.Header.Get("Depth")
+	overwrite := req.Header.Get("Overwrite")
+	switch {
+	case depth != "" && depth != "infinity" && (depth != "0" || req.Method == "MOVE"):
+		s.HandleBadRequest(res)
+		return
+	case overwrite != "" && overwrite != "T" && overwrite != "F":
+		s.HandleBadRequest(res)
+		return
+	case src == dst || isWithin(dst, src) || src == filepath.Clean(s.Root):
+		s.HandleForbidden(res)
+		return
+	case !parentExists(dst):
+		davStatus(res, http.StatusConflict)
+		return
+	}
+	dstInfo, err := os.Stat(dst)
+	exists := err == nil
+	err = nil
+	if exists && overwrite == "F" {
+		davStatus(res, http.StatusPreconditionFailed)
+		return
+	}
+	if !s.authorizeDestination(req, res, destPath) || req.Method == "MOVE" && !s.authorizeRemoval(req, res) {
+		return
+	}
+	if req.Method == "MOVE" && !s.davUnlocked(req, res, src, true, true) || !s.davUnlocked(req, res, dst, true, true) {
+		return
+	}
+
+	if exists {
+		if err = removeResource(dst, dstInfo); err == nil {
+			s.WebDAV.release(dst)
+		}
+	}
+	if err == nil && req.Method == "MOVE" {
+		if err = moveResource(src, dst, info.IsDir()); err == nil {
+			s.WebDAV.release(src)
+		}
+	} else if err == nil {
+		err = copyResource(src, dst, info, depth != "0")
+	}
+	if err != nil {
+		LoggerFromRequest(req).Error("Error copying resource", "method", req.Method, "src", src, "dst", dst, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	if exists {
+		s.HandleNoContent(res)
+		return
+	}
+	davStatus(res, http.StatusCreated)
+}
+
+// handlePropfind reports the properties of a resource and, depending on the
+// Depth header, of its members.
+func (s *Server) handlePropfind(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	info, err := os.Stat(name)
+	if err != nil {
+		s.HandleNotFound(res)
+		return
+	}
+	depth := -1
+	switch req.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		depth = 0
+	case "1":
+		depth = 1
+	default:
+		s.HandleBadRequest(res)
+		return
+	}
+	pr, err := parsePropfind(io.LimitReader(req.Body, maxDAVBody))
+	if err != nil {
+		LoggerFromRequest(req).Info("Rejected PROPFIND body", "err", err)
+		s.HandleBadRequest(res)
+		return
+	}
+
+	ms := newMultistatus()
+	if err := s.propfind(ms, path.Clean("/"+req.URL.Path), name, info, pr, depth); err != nil {
+		LoggerFromRequest(req).Error("Error reading properties", "file", name, "err", err)
+		s.HandleInternalServerError(res)
+		return
+	}
+	ms.write(res)
+}
+
+// propfind adds the properties of the resource at name, and of its members
+// down to depth levels or all of them if depth is negative, to ms.
+func (s *Server) propfind(ms *multistatus, href, name string, info fs.FileInfo, pr propRequest, depth int) error {
+	props, err := s.davProps(href, name, info)
+	if err != nil {
+		return err
+	}
+	ms.add(davHref(href, info.IsDir()), pr.sel(props))
+	if !info.IsDir() || depth == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), davPropsName) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed while listing.
+			continue
+		}
+		if err := s.propfind(ms, path.Join(href, entry.Name()), filepath.Join(name, entry.Name()), info, pr, max(depth-1, -1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleProppatch sets and removes dead properties. The update is atomic:
+// if a property cannot be changed, none are.
+func (s *Server) handleProppatch(req *http.Request, res *http.Response) {
+	name := s.localPath(req.URL.Path)
+	info, err := os.Stat(name)
+	if err != nil {
+		s.HandleNotFound(res)
+		return
+	}
+	if !s.davUnlocked(req, res, name, false, false) {
+		return
+	}
+	updates, err := parsePropertyUpdate(io.LimitReader(req.Body, maxDAVBody))
+	if err != nil {
+		LoggerFromRequest(req).Info("Rejected PROPPATCH body", "err", err)
+		s.HandleBadRequest(res)
+		return
+	}
+
+	status := http.StatusOK
+	for _, u := range updates {
+		if u.prop.Space == "DAV:" && slices.Contains(davLiveProps, u.prop.Local) {
+			status = http.StatusFailedDependency
+		}
+	}
+	if status == http.StatusOK {
+		props, err := loadProps(name, info.IsDir())
+		if err == nil {
+			for _, u := range updates {
+				props = u.apply(props)
+			}
+			err = saveProps(name, info.IsDir(), props)
+		}
+		if err != nil {
+			LoggerFromRequest(req).Error("Error writing properties", "file", name, "err", err)
+			s.HandleInternalServerError(res)
+			return
+		}
+	}
+
+	results := make(map[int][]propValue)
+	for _, u := range updates {
+		code := status
+		if u.prop.Space == "DAV:" && slices.Contains(davLiveProps, u.prop.Local) {
+			// Live properties are computed and protected, RFC 4918
+			// section 9.2.
+			code = http.StatusForbidden
+		}
+		results[code] = append(results[code], propValue{name: u.prop.name()})
+	}
+	ms := newMultistatus()
+	ms.add(davHref(req.URL.Path, info.IsDir()), results)
+	ms.write(res)
+}
+
+// davLiveProps are the DAV: properties the server computes.
+var davLiveProps = []string{"creationdate", "displayname", "getcontentlength", "getcontenttype", "getetag", "getlastmodified", "resourcetype", "supportedlock", "lockdiscovery"}
+
+// davProps returns the live and dead properties of the resource at name.
+func (s *Server) davProps(href, name string, info fs.FileInfo) ([]propValue, error) {
+	dav := func(local, inner string) propValue {
+		return propValue{name: davName(local), inner: inner}
+	}
+	modified := info.ModTime().UTC()
+	props := []propValue{
+		dav("creationdate", modified.Format(time.RFC3339)),
+		dav("displayname", xmlEscape(path.Base(href))),
+		dav("getlastmodified", modified.Format(http.TimeFormat)),
+	}
+	if info.IsDir() {
+		props = append(props, dav("resourcetype", "<D:collection/>"))
+	} else {
+		props = append(props,
+			dav("resourcetype", ""),
+			dav("getcontentlength", strconv.FormatInt(info.Size(), 10)),
+			dav("getetag", xmlEscape(fileETag(info))))
+		if contentType, err := s.contentType(&http.Request{URL: &url.URL{Path: href}}); err == nil {
+			props = append(props, dav("getcontenttype", xmlEscape(contentType)))
+		}
+	}
+	props = append(props, dav("supportedlock", supportedLock), dav("lockdiscovery", s.WebDAV.discovery(name)))
+
+	dead, err := loadProps(name, info.IsDir())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range dead {
+		props = append(props, propValue{name: p.name(), inner: p.Value})
+	}
+	return props, nil
+}
+
+// authorizeDestination checks that the client may write to dest, the
+// target of a COPY or MOVE, as if it had sent the request there.
+func (s *Server) authorizeDestination(req *http.Request, res *http.Response, dest string) bool {
+	probe := req.Clone(req.Context())
+	probe.URL.Path = dest
+	principal := PrincipalFromRequest(req)
+	switch {
+	case principal == nil && s.Auth != nil && s.Auth.Required(probe):
+		s.HandleUnauthorized(res)
+		s.Auth.Challenge(res, false)
+		return false
+	case principal != nil && !principal.Allows(Operation(probe), dest):
+		LoggerFromRequest(req).Warn("Principal is not allowed to access destination", "principal", principal.Name, "destination", dest)
+		s.HandleForbidden(res)
+		return false
+	}
+	return s.checkACL(probe, res)
+}
+
+// authorizeRemoval checks that the principal and the ACL would allow the
+// request's resource to be deleted, which a MOVE does to its source.
+func (s *Server) authorizeRemoval(req *http.Request, res *http.Response) bool {
+	probe := req.Clone(req.Context())
+	probe.Method = http.MethodDelete
+	if principal := PrincipalFromRequest(req); principal != nil && !principal.Allows(OpDelete, req.URL.Path) {
+		LoggerFromRequest(req).Warn("Principal is not allowed to delete source", "principal", principal.Name, "path", req.URL.Path)
+		s.HandleForbidden(res)
+		return false
+	}
+	return s.checkACL(probe, res)
+}
+
+// davHref returns the escaped href of the resource at p, with a trailing
+// slash for collections.
+func davHref(p string, dir bool) string {
+	href := (&url.URL{Path: path.Clean("/" + p)}).EscapedPath()
+	if dir && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	return href
+}
+
+// davStatus builds a response with the status code and its text as body.
+func davStatus(res *http.Response, code int) {
+	res.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	res.StatusCode = code
+	res.Body = io.NopCloser(strings.NewReader(res.Status))
+}
+
+// parentExists reports whether the directory containing name exists.
+func parentExists(name string) bool {
+	info, err := os.Stat(filepath.Dir(name))
+	return err == nil && info.IsDir()
+}
+
+// isWithin reports whether name is inside the directory dir.
+func isWithin(name, dir string) bool {
+	return strings.HasPrefix(name, dir+string(filepath.Separator))
+}
+
+// removeResource deletes a file with its properties or a collection with
+// all its members.
+func removeResource(name string, info fs.FileInfo) error {
+	if info.IsDir() {
+		return os.RemoveAll(name)
+	}
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return ignoreNotExist(os.Remove(propsFile(name, false)))
+}
+
+// moveResource renames a resource along with its properties.
+func moveResource(src, dst string, dir bool) error {
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	if dir {
+		// The properties of a collection are stored inside it.
+		return nil
+	}
+	return ignoreNotExist(os.Rename(propsFile(src, false), propsFile(dst, false)))
+}
+
+// copyResource copies a resource along with its properties, including the
+// members of a collection if recursive is set.
+func copyResource(src, dst string, info fs.FileInfo, recursive bool) error {
+	if !info.IsDir() {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+		return ignoreNotExist(copyFile(propsFile(src, false), propsFile(dst, false)))
+	}
+
+	if err := os.Mkdir(dst, 0777); err != nil {
+		return err
+	}
+	if err := ignoreNotExist(copyFile(propsFile(src, true), propsFile(dst, true))); err != nil || !recursive {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), davPropsName) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := copyResource(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), info, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// davExchange sends a WebDAV request with the given headers and body and
+// returns the response and its body.
+func davExchange(t *testing.T, addr, method, path, body string, headers ...string) (*http.Response, string) {
+	t.Helper()
+	raw := fmt.Sprintf("%s %s HTTP/1.0\r\nHost: %s\r\nContent-Length: %d\r\n", method, path, addr, len(body))
+	for _, header := range headers {
+		raw += header + "\r\n"
+	}
+	return rawExchange(t, addr, raw+"\r\n"+body)
+}
+
+func startDAVServer(t *testing.T) (addr, root string) {
+	addr = startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.WebDAV = NewWebDAV()
+	})
+	return addr, root
+}
+
+func TestWebDAVBasic(t *testing.T) {
+	addr, root := startDAVServer(t)
+
+	steps := []struct {
+		name, method, path, body string
+		headers                  []string
+		status                   int
+	}{
+		{"put", "PUT", "/res.txt", "hello", nil, 201},
+		{"put again", "PUT", "/res.txt", "hello again", nil, 204},
+		{"put without parent", "PUT", "/missing/res.txt", "hello", nil, 409},
+		{"put unsupported type", "PUT", "/res.exe", "hello", nil, 415},
+		{"mkcol", "MKCOL", "/coll", "", nil, 201},
+		{"mkcol again", "MKCOL", "/coll", "", nil, 405},
+		{"mkcol with body", "MKCOL", "/other", "<x/>", nil, 415},
+		{"mkcol without parent", "MKCOL", "/a/b", "", nil, 409},
+		{"put into collection", "PUT", "/coll/res.txt", "member", nil, 201},
+		{"put onto collection", "PUT", "/coll", "hello", nil, 405},
+		{"delete collection depth 0", "DELETE", "/coll", "", []string{"Depth: 0"}, 400},
+		{"delete collection", "DELETE", "/coll", "", nil, 204},
+		{"delete missing", "DELETE", "/coll", "", nil, 404},
+		{"delete root", "DELETE", "/", "", nil, 403},
+	}
+	for _, step := range steps {
+		if res, body := davExchange(t, addr, step.method, step.path, step.body, step.headers...); res.StatusCode != step.status {
+			t.Errorf("%s: got %d, want %d: %s", step.name, res.StatusCode, step.status, body)
+		}
+	}
+
+	if res, body := rawExchange(t, addr, "GET /res.txt HTTP/1.0\r\n\r\n"); res.StatusCode != 200 || body != "hello again" {
+		t.Errorf("got %d %q, want the stored file", res.StatusCode, body)
+	}
+	if _, err := os.Stat(filepath.Join(root, "coll")); err == nil {
+		t.Error("collection was not deleted")
+	}
+
+	res, _ := davExchange(t, addr, "OPTIONS", "/res.txt", "")
+	if res.Header.Get("DAV") != "1, 2" || res.Header.Get("Allow") != "GET, HEAD, POST, PATCH, PUT, DELETE, COPY, MOVE, PROPFIND, PROPPATCH, LOCK, UNLOCK, OPTIONS" {
+		t.Errorf("got DAV %q and Allow %q", res.Header.Get("DAV"), res.Header.Get("Allow"))
+	}
+}
+
+func TestWebDAVCopyMove(t *testing.T) {
+	addr, root := startDAVServer(t)
+	os.Mkdir(filepath.Join(root, "src"), 0o755)
+	os.Mkdir(filepath.Join(root, "src", "sub"), 0o755)
+	writeTestFile(t, filepath.Join(root, "src", "a.txt"), "a")
+	writeTestFile(t, filepath.Join(root, "src", "sub", "b.txt"), "b")
+	writeTestFile(t, filepath.Join(root, "c.txt"), "c")
+	dest := func(p string) string { return "Destination: http://" + addr + p }
+
+	steps := []struct {
+		name, method, path string
+		headers            []string
+		status             int
+	}{
+		{"copy file", "COPY", "/c.txt", []string{dest("/d.txt")}, 201},
+		{"copy onto file", "COPY", "/c.txt", []string{dest("/d.txt")}, 204},
+		{"copy without overwrite", "COPY", "/c.txt", []string{dest("/d.txt"), "Overwrite: F"}, 412},
+		{"copy without parent", "COPY", "/c.txt", []string{dest("/missing/d.txt")}, 409},
+		{"copy onto itself", "COPY", "/c.txt", []string{dest("/c.txt")}, 403},
+		{"copy into itself", "COPY", "/src", []string{dest("/src/copy")}, 403},
+		{"copy to other server", "COPY", "/c.txt", []string{"Destination: http://example.com/d.txt"}, 502},
+		{"copy missing", "COPY", "/missing.txt", []string{dest("/d.txt")}, 404},
+		{"copy without destination", "COPY", "/c.txt", nil, 400},
+		{"copy to unsupported type", "COPY", "/c.txt", []string{dest("/c.exe")}, 415},
+		{"move to unsupported type", "MOVE", "/c.txt", []string{dest("/c.exe")}, 415},
+		{"copy collection", "COPY", "/src", []string{dest("/copy")}, 201},
+		{"copy collection depth 0", "COPY", "/src", []string{dest("/shallow"), "Depth: 0"}, 201},
+		{"move collection", "MOVE", "/src", []string{dest("/moved")}, 201},
+		{"move depth 0", "MOVE", "/moved", []string{dest("/other"), "Depth: 0"}, 400},
+		{"move onto file", "MOVE", "/d.txt", []string{dest("/c.txt")}, 204},
+	}
+	for _, step := range steps {
+		if res, body := davExchange(t, addr, step.method, step.path, "", step.headers...); res.StatusCode != step.status {
+			t.Errorf("%s: got %d, want %d: %s", step.name, res.StatusCode, step.status, body)
+		}
+	}
+
+	for _, test := range []struct {
+		path   string
+		exists bool
+	}{
+		{"copy/sub/b.txt", true},
+		{"shallow", true},
+		{"shallow/a.txt", false},
+		{"moved/sub/b.txt", true},
+		{"src", false},
+		{"c.txt", true},
+		{"d.txt", false},
+		{"c.exe", false},
+	} {
+		if _, err := os.Stat(filepath.Join(root, test.path)); (err == nil) != test.exists {
+			t.Errorf("%s: got exists %v, want %v", test.path, err == nil, test.exists)
+		}
+	}
+}
+
+func TestWebDAVCopyDestinationACL(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl")
+	writeTestFile(t, aclFile, `
+deny  /private/**  *  *  *
+allow /**          *  *  *
+`)
+	acl, err := LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("failed to load ACL: %v", err)
+	}
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.WebDAV = NewWebDAV()
+		s.ACL = acl
+	})
+	os.Mkdir(filepath.Join(root, "private"), 0o755)
+	writeTestFile(t, filepath.Join(root, "a.txt"), "a")
+
+	if res, _ := davExchange(t, addr, "COPY", "/a.txt", "", "Destination: /private/a.txt"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("got %d, want 403 for a denied destination", res.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(root, "private", "a.txt")); err == nil {
+		t.Error("file was copied to a denied destination")
+	}
+}
+
+func TestWebDAVDestinationTraversal(t *testing.T) {
+	aclFile := filepath.Join(t.TempDir(), "acl")
+	writeTestFile(t, aclFile, "allow /public/** * * *\n")
+	acl, err := LoadACL(aclFile)
+	if err != nil {
+		t.Fatalf("failed to load ACL: %v", err)
+	}
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.WebDAV = NewWebDAV()
+		s.ACL = acl
+	})
+	os.Mkdir(filepath.Join(root, "public"), 0o755)
+	os.Mkdir(filepath.Join(root, "private"), 0o755)
+	writeTestFile(t, filepath.Join(root, "public", "a.txt"), "a")
+
+	tests := []struct {
+		method, dest string
+		status       int
+	}{
+		{"COPY", "/public/../private/x", http.StatusForbidden},
+		{"MOVE", "/public/../private/x", http.StatusForbidden},
+		{"COPY", "/public//..//private/x", http.StatusForbidden},
+		{"COPY", "/public/../../x", http.StatusBadRequest},
+		{"COPY", "/public/./b.txt", http.StatusCreated},
+	}
+	for _, test := range tests {
+		if res, _ := davExchange(t, addr, test.method, "/public/a.txt", "", "Destination: "+test.dest); res.StatusCode != test.status {
+			t.Errorf("%s to %s: got %d, want %d", test.method, test.dest, res.StatusCode, test.status)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "private", "x")); err == nil {
+		t.Error("file was copied past the ACL")
+	}
+	if _, err := os.Stat(filepath.Join(root, "public", "b.txt")); err != nil {
+		t.Errorf("file was not copied within the ACL: %v", err)
+	}
+}
+
+func TestWebDAVScopes(t *testing.T) {
+	tokens := NewHMACTokenAuth([]byte("0123456789abcdef0123456789abcdef"))
+	var root string
+	addr := startTestServer(t, func(s *Server) {
+		root = s.Root
+		s.WebDAV = NewWebDAV()
+		s.Auth, _ = NewAuth("files", nil)
+		s.Auth.Tokens = tokens
+		s.Auth.Require("/")
+	})
+	os.Mkdir(filepath.Join(root, "public"), 0o755)
+	writeTestFile(t, filepath.Join(root, "public", "a.txt"), "a")
+	os.Mkdir(filepath.Join(root, "up"), 0o755)
+	writeTestFile(t, filepath.Join(root, "up", "keep.txt"), "keep")
+	token, err := tokens.Sign(Claims{Subject: "ci", Scopes: []Scope{
+		{Prefix: "/public", Ops: []string{OpRead}},
+		{Prefix: "/up", Ops: []string{OpRead, OpWrite}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	bearer := "Authorization: Bearer " + token
+
+	tests := []struct {
+		method, path string
+		headers      []string
+		status       int
+	}{
+		{"PROPFIND", "/public", []string{"Depth: 1"}, http.StatusForbidden},
+		{"PROPFIND", "/public/", []string{"Depth: 1"}, http.StatusForbidden},
+		{"PROPFIND", "/public", nil, http.StatusForbidden},
+		{"PROPFIND", "/public", []string{"Depth: 0"}, http.StatusMultiStatus},
+		{"PROPFIND", "/public/a.txt", []string{"Depth: 1"}, http.StatusMultiStatus},
+		{"DELETE", "/up/keep.txt", nil, http.StatusForbidden},
+		{"MOVE", "/up/keep.txt", []string{"Destination: /up/moved.txt"}, http.StatusForbidden},
+		{"COPY", "/up/keep.txt", []string{"Destination: /up/copied.txt"}, http.StatusCreated},
+	}
+	for _, test := range tests {
+		res, body := davExchange(t, addr, test.method, test.path, "", append(test.headers, bearer)...)
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s %v: got %d, want %d", test.method, test.path, test.headers, res.StatusCode, test.status)
+		}
+		if test.path == "/public" && strings.Contains(body, "a.txt") {
+			t.Errorf("%s %s %v: members were listed", test.method, test.path, test.headers)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "up", "keep.txt")); err != nil {
+		t.Errorf("source was removed without the delete scope: %v", err)
+	}
+}
+
+func TestWebDAVProperties(t *testing.T) {
+	addr, root := startDAVServer(t)
+	os.Mkdir(filepath.Join(root, "coll"), 0o755)
+	os.Mkdir(filepath.Join(root, "coll", "sub"), 0o755)
+	writeTestFile(t, filepath.Join(root, "coll", "a.txt"), "hello")
+	writeTestFile(t, filepath.Join(root, "coll", "sub", "b.txt"), "b")
+
+	const propfind = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/><D:resourcetype/><Z:color xmlns:Z="urn:test"/></D:prop></D:propfind>`
+	for _, test := range []struct {
+		depth     string
+		responses int
+	}{
+		{"0", 1},
+		{"1", 3},
+		{"infinity", 4},
+	} {
+		res, body := davExchange(t, addr, "PROPFIND", "/coll/", propfind, "Depth: "+test.depth)
+		if res.StatusCode != http.StatusMultiStatus {
+			t.Fatalf("depth %s: got %d, want 207", test.depth, res.StatusCode)
+		}
+		if n := strings.Count(body, "<D:response>"); n != test.responses {
+			t.Errorf("depth %s: got %d responses, want %d: %s", test.depth, n, test.responses, body)
+		}
+	}
+	res, body := davExchange(t, addr, "PROPFIND", "/coll/a.txt", propfind, "Depth: 0")
+	if !strings.Contains(body, "<D:getcontentlength>5</D:getcontentlength>") || !strings.Contains(body, "404 Not Found") {
+		t.Errorf("got %d: %s", res.StatusCode, body)
+	}
+
+	const set = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:color>blue</Z:color></D:prop></D:set></D:propertyupdate>`
+	if res, body := davExchange(t, addr, "PROPPATCH", "/coll/a.txt", set); res.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "200 OK") {
+		t.Fatalf("got %d: %s", res.StatusCode, body)
+	}
+	if _, body := davExchange(t, addr, "PROPFIND", "/coll/a.txt", propfind, "Depth: 0"); !strings.Contains(body, ">blue</R:color>") {
+		t.Errorf("set property was not returned: %s", body)
+	}
+
+	const protected = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:size>big</Z:size><D:getetag>x</D:getetag></D:prop></D:set></D:propertyupdate>`
+	res, body = davExchange(t, addr, "PROPPATCH", "/coll/a.txt", protected)
+	if !strings.Contains(body, "403 Forbidden") || !strings.Contains(body, "424 Failed Dependency") {
+		t.Errorf("got %d: %s", res.StatusCode, body)
+	}
+	if _, body := davExchange(t, addr, "PROPFIND", "/coll/a.txt", "", "Depth: 0"); strings.Contains(body, "big") {
+		t.Errorf("failed PROPPATCH changed properties: %s", body)
+	}
+
+	// Properties move with their resource and are hidden from clients.
+	if res, _ := davExchange(t, addr, "MOVE", "/coll/a.txt", "", "Destination: /coll/c.txt"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d, want 201", res.StatusCode)
+	}
+	if _, body := davExchange(t, addr, "PROPFIND", "/coll/c.txt", propfind, "Depth: 0"); !strings.Contains(body, ">blue</R:color>") {
+		t.Errorf("property did not move: %s", body)
+	}
+	if _, body := davExchange(t, addr, "PROPFIND", "/coll/", "", "Depth: 1"); strings.Contains(body, davPropsName) {
+		t.Errorf("sidecar listed: %s", body)
+	}
+	if res, _ := rawExchange(t, addr, "GET /coll/"+davPropsName+".c.txt HTTP/1.0\r\n\r\n"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d, want 404 for a sidecar", res.StatusCode)
+	}
+
+	const remove = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:remove><D:prop><Z:color/></D:prop></D:remove></D:propertyupdate>`
+	davExchange(t, addr, "PROPPATCH", "/coll/c.txt", remove)
+	if _, err := os.Stat(filepath.Join(root, "coll", davPropsName+".c.txt")); err == nil {
+		t.Error("sidecar remains without properties")
+	}
+
+	if res, _ := davExchange(t, addr, "PROPFIND", "/coll/", "<D:propfind", "Depth: 0"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d, want 400 for malformed XML", res.StatusCode)
+	}
+}
+
+var lockTokenPattern = regexp.MustCompile(`^<(opaquelocktoken:[0-9a-f-]+)>$`)
+
+func lockResource(t *testing.T, addr, path, scope string, headers ...string) (int, string) {
+	t.Helper()
+	body := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:` + scope + `/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>alice</D:owner></D:lockinfo>`
+	res, _ := davExchange(t, addr, "LOCK", path, body, headers...)
+	m := lockTokenPattern.FindStringSubmatch(res.Header.Get("Lock-Token"))
+	if m == nil {
+		return res.StatusCode, ""
+	}
+	return res.StatusCode, m[1]
+}
+
+func TestWebDAVLocks(t *testing.T) {
+	addr, root := startDAVServer(t)
+	os.Mkdir(filepath.Join(root, "coll"), 0o755)
+	writeTestFile(t, filepath.Join(root, "a.txt"), "a")
+
+	status, token := lockResource(t, addr, "/a.txt", "exclusive", "Timeout: Second-60")
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("got %d with token %q, want 200 with a token", status, token)
+	}
+	if status, _ := lockResource(t, addr, "/a.txt", "shared"); status != http.StatusLocked {
+		t.Errorf("got %d, want 423 for a conflicting lock", status)
+	}
+
+	steps := []struct {
+		name, method, path string
+		headers            []string
+		status             int
+	}{
+		{"put without token", "PUT", "/a.txt", nil, 423},
+		{"put with bogus token", "PUT", "/a.txt", []string{"If: (<opaquelocktoken:bogus>)"}, 412},
+		{"put with malformed If", "PUT", "/a.txt", []string{"If: <opaquelocktoken"}, 400},
+		{"put with token", "PUT", "/a.txt", []string{"If: (<" + token + ">)"}, 204},
+		{"put with tagged token", "PUT", "/a.txt", []string{"If: <http://" + addr + "/a.txt> (<" + token + ">)"}, 204},
+		{"delete without token", "DELETE", "/a.txt", nil, 423},
+		{"move without token", "MOVE", "/a.txt", []string{"Destination: /b.txt"}, 423},
+		{"copy without token", "COPY", "/a.txt", []string{"Destination: /b.txt"}, 201},
+		{"refresh", "LOCK", "/a.txt", []string{"If: (<" + token + ">)", "Timeout: Second-120"}, 200},
+		{"refresh with bogus token", "LOCK", "/a.txt", []string{"If: (Not <opaquelocktoken:bogus>)"}, 412},
+		{"unlock with bogus token", "UNLOCK", "/a.txt", []string{"Lock-Token: <opaquelocktoken:bogus>"}, 409},
+		{"unlock without token", "UNLOCK", "/a.txt", nil, 400},
+		{"unlock", "UNLOCK", "/a.txt", []string{"Lock-Token: <" + token + ">"}, 204},
+		{"put after unlock", "PUT", "/a.txt", nil, 204},
+	}
+	for _, step := range steps {
+		if res, body := davExchange(t, addr, step.method, step.path, "", step.headers...); res.StatusCode != step.status {
+			t.Errorf("%s: got %d, want %d: %s", step.name, res.StatusCode, step.status, body)
+		}
+	}
+
+	// Shared locks coexist, an exclusive one does not.
+	if status, _ := lockResource(t, addr, "/a.txt", "shared"); status != http.StatusOK {
+		t.Errorf("got %d, want 200 for a shared lock", status)
+	}
+	if status, _ := lockResource(t, addr, "/a.txt", "shared"); status != http.StatusOK {
+		t.Errorf("got %d, want 200 for a second shared lock", status)
+	}
+	if status, _ := lockResource(t, addr, "/a.txt", "exclusive"); status != http.StatusLocked {
+		t.Errorf("got %d, want 423 for an exclusive lock", status)
+	}
+
+	// A depth infinity lock on a collection covers its members.
+	status, collToken := lockResource(t, addr, "/coll", "exclusive")
+	if status != http.StatusOK {
+		t.Fatalf("got %d, want 200", status)
+	}
+	if res, _ := davExchange(t, addr, "PUT", "/coll/new.txt", "new"); res.StatusCode != http.StatusLocked {
+		t.Errorf("got %d, want 423 for a member of a locked collection", res.StatusCode)
+	}
+	if res, _ := davExchange(t, addr, "PUT", "/coll/new.txt", "new", "If: (<"+collToken+">)"); res.StatusCode != http.StatusCreated {
+		t.Errorf("got %d, want 201 with the collection's token", res.StatusCode)
+	}
+	if _, body := davExchange(t, addr, "PROPFIND", "/coll/new.txt", "", "Depth: 0"); !strings.Contains(body, collToken) {
+		t.Errorf("lockdiscovery does not show the collection lock: %s", body)
+	}
+
+	// Locking an unmapped URL creates an empty resource.
+	if status, _ := lockResource(t, addr, "/null.txt", "exclusive"); status != http.StatusCreated {
+		t.Errorf("got %d, want 201 for a lock on an unmapped URL", status)
+	}
+	if info, err := os.Stat(filepath.Join(root, "null.txt")); err != nil || info.Size() != 0 {
+		t.Errorf("locking did not create an empty file: %v", err)
+	}
+}
+
+func TestWebDAVLockTimeout(t *testing.T) {
+	var dav *WebDAV
+	addr := startTestServer(t, func(s *Server) {
+		dav = NewWebDAV()
+		dav.MaxLockTimeout = 50 * time.Millisecond
+		s.WebDAV = dav
+		writeTestFile(t, filepath.Join(s.Root, "a.txt"), "a")
+	})
+
+	if status, _ := lockResource(t, addr, "/a.txt", "exclusive", "Timeout: Infinite"); status != http.StatusOK {
+		t.Fatalf("got %d, want 200", status)
+	}
+	if res, _ := davExchange(t, addr, "PUT", "/a.txt", "b"); res.StatusCode != http.StatusLocked {
+		t.Errorf("got %d, want 423 while locked", res.StatusCode)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if res, _ := davExchange(t, addr, "PUT", "/a.txt", "b"); res.StatusCode != http.StatusNoContent {
+		t.Errorf("got %d, want 204 after the lock expired", res.StatusCode)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", time.Hour},
+		{"Second-60", time.Minute},
+		{"Infinite, Second-60", time.Hour},
+		{"Second-7200", time.Hour},
+		{"Second-x, Second-30", 30 * time.Second},
+	}
+	for _, test := range tests {
+		if got := lockTimeout(test.header, time.Hour); got != test.want {
+			t.Errorf("lockTimeout(%q) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}